@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/log"
+	"github.com/javiercm1410/gyro/pkg/audit"
+	iam "github.com/javiercm1410/gyro/pkg/providers/aws"
+	"github.com/spf13/cobra"
+)
+
+// rotationAuditLog is the audit log opened by the running rotate command,
+// checked for write failures once the command returns.
+var rotationAuditLog *audit.Log
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Inspect the rotation audit log",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyConfig(cmd); err != nil {
+			return fmt.Errorf("%w: %w", errUsage, err)
+		}
+		return nil
+	},
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:     "verify",
+	Short:   "Check the hash chain of the audit log",
+	Example: "gyro audit verify --audit-log /var/log/gyro/audit.jsonl",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := auditLogPath(cmd)
+		count, err := audit.Verify(path)
+		if err != nil {
+			return fmt.Errorf("%s: %d valid records before the error: %w", path, count, err)
+		}
+		log.Infof("Audit log %s is intact: %d records verified", path, count)
+		return nil
+	},
+}
+
+func auditLogPath(cmd *cobra.Command) string {
+	path, _ := cmd.Flags().GetString("audit-log")
+	if path == "" {
+		return audit.DefaultPath()
+	}
+	return path
+}
+
+// openRotationAudit opens the audit log of a rotate command and wraps the
+// IAM client, and the clients of the --org accounts in place, so every
+// mutating call is recorded with the caller's ARN.
+func openRotationAudit(cmd *cobra.Command, wrapper iam.UserWrapper, accounts []iam.UserWrapper) (iam.UserWrapper, error) {
+	identity, err := wrapper.GetCallerIdentity()
+	if err != nil {
+		return wrapper, fmt.Errorf("couldn't resolve the caller for the audit log: %w", err)
+	}
+
+	auditLog, err := audit.Open(auditLogPath(cmd))
+	if err != nil {
+		return wrapper, err
+	}
+	rotationAuditLog = auditLog
+	for i := range accounts {
+		accounts[i] = accounts[i].WithAudit(auditLog, identity.Arn)
+	}
+	return wrapper.WithAudit(auditLog, identity.Arn), nil
+}
+
+// closeRotationAudit closes the audit log of a rotate command and reports
+// records that could not be written. Execute calls it whether the command
+// succeeded or not.
+func closeRotationAudit() error {
+	if rotationAuditLog == nil {
+		return nil
+	}
+	writeErr := rotationAuditLog.Err()
+	if err := rotationAuditLog.Close(); err != nil && writeErr == nil {
+		writeErr = err
+	}
+	rotationAuditLog = nil
+	if writeErr != nil {
+		return fmt.Errorf("changes are missing from the audit log: %w", writeErr)
+	}
+	return nil
+}
+
+func init() {
+	RootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditVerifyCmd)
+
+	auditCmd.PersistentFlags().String("audit-log", "", "Audit log file (default ~/.config/gyro/audit.jsonl)")
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/javiercm1410/gyro/pkg/audit"
+	iam "github.com/javiercm1410/gyro/pkg/providers/aws"
+)
+
+func TestCloseRotationAudit(t *testing.T) {
+	tests := []struct {
+		name       string
+		open       bool
+		failAppend bool
+		commandErr error
+		wantCode   int
+	}{
+		{name: "no audit log", wantCode: ExitOK},
+		{name: "records written", open: true, wantCode: ExitOK},
+		{name: "record lost after a successful command", open: true, failAppend: true, wantCode: ExitError},
+		{
+			name:       "record lost after a partial failure",
+			open:       true,
+			failAppend: true,
+			commandErr: fmt.Errorf("%w: 1 user failed", iam.ErrPartialFailure),
+			wantCode:   ExitPartialFailure,
+		},
+		{
+			name:       "command error without lost records",
+			open:       true,
+			commandErr: fmt.Errorf("%w: 1 user failed", iam.ErrPartialFailure),
+			wantCode:   ExitPartialFailure,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.open {
+				auditLog, err := audit.Open(filepath.Join(t.TempDir(), "audit.jsonl"))
+				if err != nil {
+					t.Fatalf("Open: %v", err)
+				}
+				if test.failAppend {
+					// Writes to a closed file fail like a full disk would.
+					auditLog.Close()
+				}
+				auditLog.Append(audit.Record{Action: iam.ActionCreateKey, UserName: "alice", Outcome: audit.OutcomeSuccess})
+				rotationAuditLog = auditLog
+			}
+
+			err := errors.Join(test.commandErr, closeRotationAudit())
+			if code := exitCode(err); code != test.wantCode {
+				t.Errorf("exit code = %d, want %d (error %v)", code, test.wantCode, err)
+			}
+			if lost := err != nil && strings.Contains(err.Error(), "audit log"); lost != test.failAppend {
+				t.Errorf("error = %v, want audit failure reported %v", err, test.failAppend)
+			}
+			if rotationAuditLog != nil {
+				t.Error("audit log left open")
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/javiercm1410/gyro/pkg/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// Sources a flag value can come from, from lowest to highest precedence.
+const (
+	sourceDefault = iota
+	sourceFile
+	sourceEnv
+	sourceCommandLine
+)
+
+// sourceAnnotation marks the flags applyConfig set, with the source of the
+// value, so they can be told apart from flags given on the command line.
+const sourceAnnotation = "gyro-config-source"
+
+// applyConfig fills every flag the user did not set on the command line,
+// first from GYRO_* environment variables and then from the config file.
+// Flags keep their built-in defaults when neither source provides a value.
+// Flags it sets are Changed like command line flags; flagSource tells them
+// apart.
+func applyConfig(cmd *cobra.Command) error {
+	path, _ := cmd.Flags().GetString("config")
+	explicit := cmd.Flags().Changed("config")
+	if !explicit {
+		if env, ok := os.LookupEnv(config.EnvName("config")); ok && env != "" {
+			path, explicit = env, true
+		}
+	}
+	if path == "" {
+		path = config.DefaultPath()
+	}
+
+	cfg, err := config.Load(path, explicit)
+	if err != nil {
+		return err
+	}
+	fileValues := cfg.FlagValues()
+
+	var applyErr error
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if applyErr != nil || flag.Changed || flag.Name == "config" {
+			return
+		}
+
+		value, ok := os.LookupEnv(config.EnvName(flag.Name))
+		source, origin := sourceEnv, config.EnvName(flag.Name)
+		if !ok {
+			value, ok = fileValues[flag.Name]
+			source, origin = sourceFile, path
+		}
+		if !ok {
+			return
+		}
+
+		if err := cmd.Flags().Set(flag.Name, value); err != nil {
+			applyErr = fmt.Errorf("invalid value '%s' for %s from %s: %w", value, flag.Name, origin, err)
+			return
+		}
+		applyErr = cmd.Flags().SetAnnotation(flag.Name, sourceAnnotation, []string{strconv.Itoa(source)})
+	})
+
+	return applyErr
+}
+
+// flagSource returns where the value of a flag came from: the command line,
+// the environment, the config file or the built-in default.
+func flagSource(cmd *cobra.Command, name string) int {
+	flag := cmd.Flags().Lookup(name)
+	if flag == nil || !flag.Changed {
+		return sourceDefault
+	}
+	if values := flag.Annotations[sourceAnnotation]; len(values) == 1 {
+		if source, err := strconv.Atoi(values[0]); err == nil {
+			return source
+		}
+	}
+	return sourceCommandLine
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+// newConfigTestCommand returns a command with the flags the precedence
+// tests read, parsed from args.
+func newConfigTestCommand(t *testing.T, args ...string) *cobra.Command {
+	t.Helper()
+	cmd := &cobra.Command{Use: "test"}
+	cmd.Flags().String("config", "", "")
+	cmd.Flags().Int32("limit", 0, "")
+	cmd.Flags().Bool("all", false, "")
+	cmd.Flags().Int32("quantity", 0, "")
+	cmd.Flags().Int("age", 90, "")
+	if err := cmd.ParseFlags(args); err != nil {
+		t.Fatalf("ParseFlags: %v", err)
+	}
+	return cmd
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string
+		env       map[string]string
+		args      []string
+		wantLimit int32
+		wantAge   int
+	}{
+		{name: "defaults", wantAge: 90},
+		{name: "file", file: "age: 30\nlimit: 5\n", wantLimit: 5, wantAge: 30},
+		{name: "environment over file", file: "age: 30\n", env: map[string]string{"GYRO_AGE": "45"}, wantAge: 45},
+		{name: "command line over environment", env: map[string]string{"GYRO_AGE": "45"}, args: []string{"--age", "60"}, wantAge: 60},
+		{name: "--quantity over file limit", file: "limit: 5\n", args: []string{"--quantity", "7"}, wantLimit: 7, wantAge: 90},
+		{name: "--limit over file all", file: "all: true\n", args: []string{"--limit", "10"}, wantLimit: 10, wantAge: 90},
+		{name: "--limit over environment all", env: map[string]string{"GYRO_ALL": "true"}, args: []string{"--limit", "10"}, wantLimit: 10, wantAge: 90},
+		{name: "--all over file limit", file: "limit: 5\n", args: []string{"--all"}, wantAge: 90},
+		{name: "file all", file: "all: true\nlimit: 5\n", wantAge: 90},
+		{name: "environment limit over file all", file: "all: true\n", env: map[string]string{"GYRO_LIMIT": "3"}, wantLimit: 3, wantAge: 90},
+		{name: "--all over --limit", args: []string{"--all", "--limit", "10"}, wantAge: 90},
+		{name: "--limit over --quantity", args: []string{"--limit", "10", "--quantity", "7"}, wantLimit: 10, wantAge: 90},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(test.file), 0o600); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+			t.Setenv("GYRO_CONFIG", path)
+			for _, name := range []string{"GYRO_AGE", "GYRO_LIMIT", "GYRO_ALL", "GYRO_QUANTITY"} {
+				t.Setenv(name, test.env[name])
+				if _, ok := test.env[name]; !ok {
+					os.Unsetenv(name)
+				}
+			}
+
+			cmd := newConfigTestCommand(t, test.args...)
+			if err := applyConfig(cmd); err != nil {
+				t.Fatalf("applyConfig: %v", err)
+			}
+			if limit := userLimit(cmd); limit != test.wantLimit {
+				t.Errorf("limit = %d, want %d", limit, test.wantLimit)
+			}
+			if age, _ := cmd.Flags().GetInt("age"); age != test.wantAge {
+				t.Errorf("age = %d, want %d", age, test.wantAge)
+			}
+		})
+	}
+}
+
+func TestApplyConfigRejectsUnknownKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("limt: 5\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	cmd := newConfigTestCommand(t, "--config", path)
+	if err := applyConfig(cmd); err == nil {
+		t.Error("applyConfig accepted a misspelled key")
+	}
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/javiercm1410/gyro/pkg/audit"
+	iam "github.com/javiercm1410/gyro/pkg/providers/aws"
+)
+
+// Process exit codes, documented in the README.
+const (
+	ExitOK             = 0
+	ExitError          = 1
+	ExitUsage          = 2
+	ExitAuth           = 3
+	ExitUserNotFound   = 4
+	ExitNoLoginProfile = 5
+	ExitThrottled      = 6
+	ExitPartialFailure = 7
+	ExitAuditTampered  = 8
+)
+
+// errUsage marks invalid flags, arguments or configuration.
+var errUsage = errors.New("usage error")
+
+func usageErrorf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", errUsage, fmt.Sprintf(format, args...))
+}
+
+// exitCode maps an error returned by a command to the process exit code.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, errUsage):
+		return ExitUsage
+	case errors.Is(err, iam.ErrAuth):
+		return ExitAuth
+	case errors.Is(err, iam.ErrUserNotFound):
+		return ExitUserNotFound
+	case errors.Is(err, iam.ErrNoLoginProfile):
+		return ExitNoLoginProfile
+	case errors.Is(err, iam.ErrThrottled):
+		return ExitThrottled
+	case errors.Is(err, iam.ErrPartialFailure):
+		return ExitPartialFailure
+	case errors.Is(err, audit.ErrTampered):
+		return ExitAuditTampered
+	}
+	return ExitError
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/javiercm1410/gyro/pkg/audit"
+	iam "github.com/javiercm1410/gyro/pkg/providers/aws"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "success", want: ExitOK},
+		{name: "unexpected error", err: errors.New("disk full"), want: ExitError},
+		{name: "usage", err: usageErrorf("--age must be positive"), want: ExitUsage},
+		{name: "flag error", err: fmt.Errorf("%w: %w", errUsage, errors.New("unknown flag: --agee")), want: ExitUsage},
+		{name: "authentication", err: fmt.Errorf("%w: no AWS credentials configured", iam.ErrAuth), want: ExitAuth},
+		{name: "user not found", err: fmt.Errorf("get user alice: %w", iam.ErrUserNotFound), want: ExitUserNotFound},
+		{name: "no login profile", err: fmt.Errorf("get login profile of alice: %w", iam.ErrNoLoginProfile), want: ExitNoLoginProfile},
+		{name: "throttled", err: fmt.Errorf("%w: %w", iam.ErrThrottled, errors.New("Throttling: Rate exceeded")), want: ExitThrottled},
+		{name: "partial failure", err: fmt.Errorf("%w: 2 of 5 users failed", iam.ErrPartialFailure), want: ExitPartialFailure},
+		{name: "tampered audit log", err: fmt.Errorf("record 3: %w", audit.ErrTampered), want: ExitAuditTampered},
+		{
+			name: "joined with a lost audit record",
+			err:  errors.Join(fmt.Errorf("%w: 1 user failed", iam.ErrPartialFailure), errors.New("changes are missing from the audit log")),
+			want: ExitPartialFailure,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := exitCode(test.err); got != test.want {
+				t.Errorf("exitCode(%v) = %d, want %d", test.err, got, test.want)
+			}
+		})
+	}
+}
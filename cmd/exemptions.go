@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/javiercm1410/gyro/pkg/exemptions"
+	iam "github.com/javiercm1410/gyro/pkg/providers/aws"
+	"github.com/javiercm1410/gyro/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var exemptionsCmd = &cobra.Command{
+	Use:   "exemptions",
+	Short: "Inspect the exemptions file",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyConfig(cmd); err != nil {
+			return fmt.Errorf("%w: %w", errUsage, err)
+		}
+
+		if err := validateFormat(cmd); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+var exemptionsCheckCmd = &cobra.Command{
+	Use:     "check",
+	Short:   "List exemptions that expired or expire soon",
+	Example: "gyro exemptions check --days 14",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		days, _ := cmd.Flags().GetInt("days")
+		format, _ := cmd.Flags().GetString("format")
+		path, _ := cmd.Flags().GetString("output-file")
+		if days < 0 {
+			return usageErrorf("days cannot be negative, got %d", days)
+		}
+
+		register, err := loadExemptions(cmd)
+		if err != nil {
+			return err
+		}
+
+		statuses := register.Check(time.Duration(days)*24*time.Hour, time.Now())
+		if len(statuses) == 0 {
+			log.Infof("No exemption expires within %d days", days)
+			return nil
+		}
+
+		data := make([]iam.UserData, 0, len(statuses))
+		for _, status := range statuses {
+			data = append(data, status)
+		}
+		utils.DisplayData(format, path, 0, data)
+		return nil
+	},
+}
+
+// loadExemptions reads the file named by --exemptions-file, or the default
+// exemptions file when it exists. Expired entries are reported, as their users
+// are rotated again.
+func loadExemptions(cmd *cobra.Command) (*exemptions.Register, error) {
+	path, _ := cmd.Flags().GetString("exemptions-file")
+	explicit := path != ""
+	if !explicit {
+		path = exemptions.DefaultPath()
+	}
+
+	register, err := exemptions.Load(path, explicit)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errUsage, err)
+	}
+
+	for _, status := range register.Check(0, time.Now()) {
+		target := status.User
+		if status.AccessKeyId != "" {
+			target = fmt.Sprintf("access key %s of %s", status.AccessKeyId, status.User)
+		}
+		if status.Account != "" {
+			target += " in account " + status.Account
+		}
+		log.Warnf("Exemption of %s expired on %s and no longer applies", target, status.Expires.Format(time.DateOnly))
+	}
+	return register, nil
+}
+
+// scopeExemptions sets the account of the default credentials on the
+// register when some of its entries are limited to one account. --org runs
+// know the account of every client and do not need it.
+func scopeExemptions(register *exemptions.Register, wrapper iam.UserWrapper, accounts []iam.UserWrapper) error {
+	if accounts != nil || !register.AccountScoped() {
+		return nil
+	}
+	identity, err := wrapper.GetCallerIdentity()
+	if err != nil {
+		return fmt.Errorf("couldn't determine the account the exemptions apply to: %w", err)
+	}
+	register.DefaultAccount = identity.Account
+	return nil
+}
+
+func init() {
+	RootCmd.AddCommand(exemptionsCmd)
+	exemptionsCmd.AddCommand(exemptionsCheckCmd)
+
+	exemptionsCmd.PersistentFlags().String("exemptions-file", "", "Exemptions file (default ~/.config/gyro/exemptions.yaml)")
+	exemptionsCmd.PersistentFlags().StringP("format", "f", "table", "Output format (table, json, yaml, csv, file)")
+	exemptionsCmd.PersistentFlags().StringP("output-file", "o", "", "Write the output to this file instead of stdout (file format: ./output.json)")
+	exemptionsCheckCmd.Flags().Int("days", 30, "List exemptions expiring within N days")
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// runGyro runs the root command with args, after putting back the default
+// of every flag a previous run set.
+func runGyro(t *testing.T, args ...string) error {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+
+	var reset func(cmd *cobra.Command)
+	reset = func(cmd *cobra.Command) {
+		for _, flags := range []*pflag.FlagSet{cmd.Flags(), cmd.PersistentFlags()} {
+			flags.VisitAll(func(flag *pflag.Flag) {
+				if slice, ok := flag.Value.(pflag.SliceValue); ok {
+					slice.Replace(nil)
+				} else {
+					flag.Value.Set(flag.DefValue)
+				}
+				flag.Changed = false
+				delete(flag.Annotations, sourceAnnotation)
+			})
+		}
+		for _, child := range cmd.Commands() {
+			reset(child)
+		}
+	}
+	reset(RootCmd)
+
+	RootCmd.SetArgs(args)
+	return RootCmd.Execute()
+}
+
+func TestFromReport(t *testing.T) {
+	report := filepath.Join("testdata", "credential-report.csv")
+
+	tests := []struct {
+		name     string
+		args     []string
+		report   string
+		want     []string
+		wantCode int
+	}{
+		{name: "keys", args: []string{"keys"}, want: []string{"alice", "bob"}},
+		{name: "keys of one user", args: []string{"keys", "--username", "bob"}, want: []string{"bob"}},
+		{name: "keys by path from the ARN", args: []string{"keys", "--path-prefix", "/svc/"}, want: []string{"alice"}},
+		{name: "users", args: []string{"users"}, want: []string{"<root_account>", "alice", "carol"}},
+		{name: "users by name", args: []string{"users", "--exclude-name", "<root_account>", "--name", "c*"}, want: []string{"carol"}},
+		{name: "keys with a group filter", args: []string{"keys", "--group", "admins"}, wantCode: ExitUsage},
+		{name: "users with a tag filter", args: []string{"users", "--tag", "team=payments"}, wantCode: ExitUsage},
+		{name: "keys with --skip-current-user", args: []string{"keys", "--skip-current-user"}, wantCode: ExitUsage},
+		{name: "missing report", args: []string{"keys"}, report: "missing.csv", wantCode: ExitError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.report == "" {
+				test.report = report
+			}
+			output := filepath.Join(t.TempDir(), "output.json")
+			args := append(test.args, "--from-report", test.report, "--format", "json", "--output-file", output, "--timezone", "UTC")
+
+			err := runGyro(t, args...)
+			if code := exitCode(err); code != test.wantCode {
+				t.Fatalf("exit code = %d, want %d (error %v)", code, test.wantCode, err)
+			}
+			if test.wantCode != ExitOK {
+				return
+			}
+
+			raw, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatalf("ReadFile: %v", err)
+			}
+			var rows []struct{ UserName string }
+			if err := json.Unmarshal(raw, &rows); err != nil {
+				t.Fatalf("parse output: %v", err)
+			}
+			var got []string
+			for _, row := range rows {
+				got = append(got, row.UserName)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("users = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/javiercm1410/gyro/pkg/audit"
+	iam "github.com/javiercm1410/gyro/pkg/providers/aws"
+	"github.com/javiercm1410/gyro/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var historyCmd = &cobra.Command{
+	Use:     "history",
+	Short:   "Show the rotations recorded in the audit log",
+	Example: "gyro history --user alice --action delete-key --since 2026-01-01",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyConfig(cmd); err != nil {
+			return fmt.Errorf("%w: %w", errUsage, err)
+		}
+
+		if err := validateFormat(cmd); err != nil {
+			return err
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		userName, _ := cmd.Flags().GetString("user")
+		keyId, _ := cmd.Flags().GetString("key-id")
+		actions, _ := cmd.Flags().GetStringSlice("action")
+		since, _ := cmd.Flags().GetString("since")
+		until, _ := cmd.Flags().GetString("until")
+		timeZone, _ := cmd.Flags().GetString("timezone")
+		format, _ := cmd.Flags().GetString("format")
+		path, _ := cmd.Flags().GetString("output-file")
+
+		loc, err := time.LoadLocation(timeZone)
+		if err != nil {
+			return usageErrorf("invalid timezone '%s': %v", timeZone, err)
+		}
+		query := audit.Query{UserName: userName, AccessKeyId: keyId, Actions: actions}
+		if query.Since, err = parseHistoryTime(since, loc); err != nil {
+			return usageErrorf("invalid --since: %v", err)
+		}
+		if query.Until, err = parseHistoryTime(until, loc); err != nil {
+			return usageErrorf("invalid --until: %v", err)
+		}
+
+		auditPath := auditLogPath(cmd)
+		if _, err := audit.Verify(auditPath); errors.Is(err, audit.ErrTampered) {
+			log.Warnf("The audit log failed verification, history may be incomplete: %v", err)
+		}
+		records, err := audit.Read(auditPath)
+		if err != nil {
+			return err
+		}
+
+		records = audit.Filter(records, query)
+		data := make([]iam.UserData, 0, len(records))
+		for _, record := range records {
+			record.Time = record.Time.In(loc)
+			data = append(data, record)
+		}
+		utils.DisplayData(format, path, 0, data)
+		return nil
+	},
+}
+
+// parseHistoryTime reads a YYYY-MM-DD date in loc or an RFC 3339 time. An
+// empty value is the zero time, meaning no bound.
+func parseHistoryTime(value string, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if date, err := time.ParseInLocation(time.DateOnly, value, loc); err == nil {
+		return date, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// withLastRotated fills the LastRotated column of access key data from the
+// audit log. An unreadable log only costs the column.
+func withLastRotated(cmd *cobra.Command, options BaseCommandOptions, data []iam.UserData) []iam.UserData {
+	records, err := audit.Read(auditLogPath(cmd))
+	if err != nil {
+		log.Warnf("Couldn't read the audit log for the LastRotated column: %v", err)
+		return data
+	}
+	loc, err := time.LoadLocation(options.TimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+	return iam.WithLastRotated(data, records, loc)
+}
+
+func init() {
+	RootCmd.AddCommand(historyCmd)
+
+	historyCmd.Flags().String("audit-log", "", "Audit log file (default ~/.config/gyro/audit.jsonl)")
+	historyCmd.Flags().String("user", "", "Only records of this IAM user")
+	historyCmd.Flags().String("key-id", "", "Only records of this access key id")
+	historyCmd.Flags().StringSlice("action", nil, "Only these actions, e.g. create-key,delete-key")
+	historyCmd.Flags().String("since", "", "Only records at or after this date (YYYY-MM-DD or RFC 3339)")
+	historyCmd.Flags().String("until", "", "Only records before this date (YYYY-MM-DD or RFC 3339)")
+	historyCmd.Flags().StringP("timezone", "t", "America/Santo_Domingo", "Timezone for displaying dates")
+	historyCmd.Flags().StringP("format", "f", "table", "Output format (table, json, yaml, csv, file)")
+	historyCmd.Flags().StringP("output-file", "o", "", "Write the output to this file instead of stdout (file format: ./output.json)")
+}
@@ -11,12 +11,30 @@ var keysCmd = &cobra.Command{
 	Short:   "Get IAM Access Keys",
 	Aliases: []string{"keys", "k"},
 	Example: "gyro keys",
-	Run: func(cmd *cobra.Command, args []string) {
-		inputs, options := configureListCommand(cmd)
+	RunE: func(cmd *cobra.Command, args []string) error {
+		inputs, options, err := configureListCommand(cmd)
+		if err != nil {
+			return err
+		}
 
-		userKeyData := iam.GetUserAccessKey(inputs)
+		// With --org, the accounts that could be read are shown even when
+		// others failed.
+		userKeyData, listErr := iam.GetUserAccessKey(inputs)
+		if listErr != nil && userKeyData == nil {
+			return listErr
+		}
+		userKeyData, err = removeCurrentUser(options, inputs.Client, userKeyData)
+		if err != nil {
+			return err
+		}
+
+		userKeyData = withLastRotated(cmd, options, userKeyData)
 
 		utils.DisplayData(options.Format, options.Path, options.Age, userKeyData)
+		if listErr != nil {
+			return listErr
+		}
+		return iam.CheckResults(userKeyData)
 	},
 }
 
@@ -24,4 +42,5 @@ func init() {
 	RootCmd.AddCommand(keysCmd)
 
 	initializeBaseCommandFlags(keysCmd)
+	keysCmd.Flags().String("from-report", "", "Analyse a saved credential report CSV instead of calling AWS")
 }
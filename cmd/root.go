@@ -1,11 +1,17 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/charmbracelet/log"
 	iam "github.com/javiercm1410/gyro/pkg/providers/aws"
+	"github.com/javiercm1410/gyro/pkg/utils"
 	"github.com/spf13/cobra"
 )
 
@@ -18,31 +24,77 @@ var RootCmd = &cobra.Command{
 }
 
 type BaseCommandOptions struct {
-	Quantity int32
-	Path     string
-	User     string
-	TimeZone string
-	Format   string
-	Age      int
-	Expired  bool
+	Quantity    int32
+	Path        string
+	User        string
+	TimeZone    string
+	Format      string
+	Age         int
+	Expired     bool
+	Exclude     []string
+	SkipUser    bool
+	Report      bool
+	ReportFile  string
+	TagPolicy   bool
+	Concurrency int
+	RateLimit   float64
+	Filter      iam.UserFilter
+	AWS         iam.ConfigOptions
+	// Org scans every account of the organization, see orgAccounts.
+	Org                bool
+	AccountsFile       string
+	OrgRole            string
+	AccountConcurrency int
 }
 
 type RotateCommandOptions struct {
 	BaseCommandOptions
-	ExpireOnly       bool
-	DryRun           bool
-	Notify           bool
-	SkipConfirmation bool
-	SkipCurrentUser  bool
+	ExpireOnly         bool
+	RemoveLoginProfile bool
+	DryRun             bool
+	Notify             bool
+	SkipConfirmation   bool
+	AllowCurrentKey    bool
+	Staged             bool
+	StagedStateFile    string
+	StateDir           string
+	VerifyKey          bool
+	VerifyTimeout      time.Duration
+	GracePeriod        time.Duration
+	DeleteAfter        time.Duration
+	SlackWebhookURL    string
+	SecretSink         string
+	OnePasswordVault   string
+	PasswordLength     int
+	Passphrase         bool
 }
 
 func init() {
+	RootCmd.PersistentFlags().Int32(
+		"limit",
+		0,
+		"Stop after listing N users (default: all users)",
+	)
+
+	RootCmd.PersistentFlags().Bool(
+		"all",
+		false,
+		"List every user, walking all pages (overrides --limit)",
+	)
+
 	RootCmd.PersistentFlags().Int32P(
 		"quantity",
 		"n",
-		50,
+		0,
 		"Number of users to be listed",
 	)
+	RootCmd.PersistentFlags().MarkDeprecated("quantity", "use --limit instead")
+
+	RootCmd.PersistentFlags().String(
+		"config",
+		"",
+		"Config file (default ~/.config/gyro/config.yaml)",
+	)
 
 	RootCmd.PersistentFlags().BoolP(
 		"debug",
@@ -51,48 +103,202 @@ func init() {
 		"Show detailed output",
 	)
 
+	// Commands report their own errors with an exit code, see Execute.
+	RootCmd.SilenceUsage = true
+	RootCmd.SilenceErrors = true
+	RootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	})
+
 	// Add validation example:
 	RootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
-		quantity, _ := cmd.Flags().GetInt32("quantity")
-		if quantity < 1 {
-			return fmt.Errorf("quantity must be a positive number, got %d", quantity)
-		}
-		return nil
+		return validateLimit(cmd)
 	}
 }
 
+// Execute runs the root command and exits with the code matching the error,
+// see exitCode. Audit log write failures of a rotation are joined to the
+// command's error.
 func Execute() {
-	if err := RootCmd.Execute(); err != nil {
+	if err := errors.Join(RootCmd.Execute(), closeRotationAudit()); err != nil {
 		log.Error("Command execution failed", "error", err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
 
+// validateLimit checks --limit and its deprecated alias --quantity.
+func validateLimit(cmd *cobra.Command) error {
+	for _, name := range []string{"limit", "quantity"} {
+		value, _ := cmd.Flags().GetInt32(name)
+		if value < 0 {
+			return usageErrorf("%s cannot be negative, got %d", name, value)
+		}
+	}
+	return nil
+}
+
+// userLimit resolves --all, --limit and the deprecated --quantity into the
+// number of users to list, 0 meaning every user. The one from the source
+// with the highest precedence wins, see flagSource; from the same source
+// --all beats --limit, which beats --quantity.
+func userLimit(cmd *cobra.Command) int32 {
+	limitSource := flagSource(cmd, "limit")
+	quantitySource := flagSource(cmd, "quantity")
+	if all, _ := cmd.Flags().GetBool("all"); all && flagSource(cmd, "all") >= max(limitSource, quantitySource) {
+		return 0
+	}
+	limit, _ := cmd.Flags().GetInt32("limit")
+	if quantitySource > limitSource {
+		limit, _ = cmd.Flags().GetInt32("quantity")
+	}
+	return limit
+}
+
 func configureListFlags(cmd *cobra.Command) BaseCommandOptions {
-	quantity, _ := cmd.Flags().GetInt32("quantity")
+	quantity := userLimit(cmd)
 	timeZone, _ := cmd.Flags().GetString("timezone")
 	format, _ := cmd.Flags().GetString("format")
 	userName, _ := cmd.Flags().GetString("username")
 	path, _ := cmd.Flags().GetString("output-file")
 	age, _ := cmd.Flags().GetInt("age")
 	expired, _ := cmd.Flags().GetBool("expired-only")
+	exclude, _ := cmd.Flags().GetStringSlice("exclude")
+	skipCurrentUser, _ := cmd.Flags().GetBool("skip-current-user")
+	credentialReport, _ := cmd.Flags().GetBool("credential-report")
+	reportFile, _ := cmd.Flags().GetString("from-report")
+	tagPolicy, _ := cmd.Flags().GetBool("tag-policy")
+	concurrency, _ := cmd.Flags().GetInt("concurrency")
+	rateLimit, _ := cmd.Flags().GetFloat64("rate-limit")
+	org, _ := cmd.Flags().GetBool("org")
+	accountsFile, _ := cmd.Flags().GetString("accounts-file")
+	orgRole, _ := cmd.Flags().GetString("org-role")
+	accountConcurrency, _ := cmd.Flags().GetInt("account-concurrency")
 
 	return BaseCommandOptions{
-		Quantity: quantity,
-		User:     userName,
-		TimeZone: timeZone,
-		Format:   format,
-		Path:     path,
-		Age:      age,
-		Expired:  expired,
+		Quantity:    quantity,
+		User:        userName,
+		TimeZone:    timeZone,
+		Format:      format,
+		Path:        path,
+		Age:         age,
+		Expired:     expired,
+		Exclude:     exclude,
+		SkipUser:    skipCurrentUser,
+		Report:      credentialReport,
+		ReportFile:  reportFile,
+		TagPolicy:   tagPolicy,
+		Concurrency: concurrency,
+		RateLimit:   rateLimit,
+		Filter:      configureFilterFlags(cmd),
+		AWS:         configureAWSFlags(cmd),
+
+		Org:                org,
+		AccountsFile:       accountsFile,
+		OrgRole:            orgRole,
+		AccountConcurrency: accountConcurrency,
+	}
+}
+
+func configureFilterFlags(cmd *cobra.Command) iam.UserFilter {
+	pathPrefix, _ := cmd.Flags().GetString("path-prefix")
+	groups, _ := cmd.Flags().GetStringSlice("group")
+	excludeGroups, _ := cmd.Flags().GetStringSlice("exclude-group")
+	tags, _ := cmd.Flags().GetStringSlice("tag")
+	excludeTags, _ := cmd.Flags().GetStringSlice("exclude-tag")
+	names, _ := cmd.Flags().GetStringSlice("name")
+	excludeNames, _ := cmd.Flags().GetStringSlice("exclude-name")
+
+	return iam.UserFilter{
+		PathPrefix:    pathPrefix,
+		Groups:        groups,
+		ExcludeGroups: excludeGroups,
+		Tags:          tags,
+		ExcludeTags:   excludeTags,
+		Names:         names,
+		ExcludeNames:  excludeNames,
+	}
+}
+
+func configureAWSFlags(cmd *cobra.Command) iam.ConfigOptions {
+	profile, _ := cmd.Flags().GetString("profile")
+	roleArn, _ := cmd.Flags().GetString("role-arn")
+	externalId, _ := cmd.Flags().GetString("external-id")
+	mfaSerial, _ := cmd.Flags().GetString("mfa-serial")
+	region, _ := cmd.Flags().GetString("region")
+	endpointURL, _ := cmd.Flags().GetString("endpoint-url")
+
+	return iam.ConfigOptions{
+		Profile:     profile,
+		RoleArn:     roleArn,
+		ExternalId:  externalId,
+		MfaSerial:   mfaSerial,
+		Region:      region,
+		EndpointURL: endpointURL,
+	}
+}
+
+// validateFormat checks --format against the formats DisplayData renders.
+func validateFormat(cmd *cobra.Command) error {
+	format, _ := cmd.Flags().GetString("format")
+	if !slices.Contains(utils.OutputFormats, format) {
+		return usageErrorf("invalid format '%s'. Valid options are: %s", format, strings.Join(utils.OutputFormats, ", "))
+	}
+	return nil
+}
+
+// validateAWSFlags checks the combinations of the credential and endpoint
+// flags.
+func validateAWSFlags(options iam.ConfigOptions) error {
+	if options.RoleArn == "" {
+		if options.ExternalId != "" {
+			return usageErrorf("--external-id needs --role-arn")
+		}
+		if options.MfaSerial != "" {
+			return usageErrorf("--mfa-serial needs --role-arn")
+		}
+	}
+	if options.EndpointURL != "" {
+		endpoint, err := url.Parse(options.EndpointURL)
+		if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+			return usageErrorf("invalid endpoint-url '%s', expected e.g. http://localhost:4566", options.EndpointURL)
+		}
 	}
+	return nil
 }
 
-func configureListCommand(cmd *cobra.Command) (iam.GetWrapperInputs, BaseCommandOptions) {
+func configureListCommand(cmd *cobra.Command) (iam.GetWrapperInputs, BaseCommandOptions, error) {
 	options := configureListFlags(cmd)
 
-	wrapper := iam.UserWrapper{
-		IamClient: iam.DeclareConfig(),
+	// A saved report is analysed offline, without AWS credentials.
+	var wrapper iam.UserWrapper
+	if options.ReportFile == "" {
+		declared, err := iam.DeclareConfig(options.AWS)
+		if err != nil {
+			return iam.GetWrapperInputs{}, options, err
+		}
+		wrapper = declared.WithRateLimit(options.RateLimit)
+	} else if options.SkipUser {
+		return iam.GetWrapperInputs{}, options, usageErrorf("--skip-current-user needs AWS access and cannot be combined with --from-report")
+	} else if options.Filter.NeedsAPI() {
+		return iam.GetWrapperInputs{}, options, usageErrorf("group and tag filters need AWS access and cannot be combined with --from-report")
+	} else if options.Org {
+		return iam.GetWrapperInputs{}, options, usageErrorf("--org cannot be combined with --from-report: a saved report covers a single account")
+	}
+
+	accounts, err := orgAccounts(options, wrapper)
+	if err != nil {
+		return iam.GetWrapperInputs{}, options, err
+	}
+
+	register, err := loadExemptions(cmd)
+	if err != nil {
+		return iam.GetWrapperInputs{}, options, err
+	}
+	// A saved report names no account, account-scoped entries do not apply.
+	if options.ReportFile == "" {
+		if err := scopeExemptions(register, wrapper, accounts); err != nil {
+			return iam.GetWrapperInputs{}, options, err
+		}
 	}
 
 	inputs := iam.GetWrapperInputs{
@@ -101,52 +307,183 @@ func configureListCommand(cmd *cobra.Command) (iam.GetWrapperInputs, BaseCommand
 		Age:      options.Age,
 		Expired:  options.Expired,
 		UserName: options.User,
+		Exclude:  options.Exclude,
+		Filter:   options.Filter,
 		Client:   wrapper,
+
+		CredentialReport: options.Report,
+		ReportFile:       options.ReportFile,
+		Concurrency:      options.Concurrency,
+		Exemptions:       register,
+		TagPolicy:        options.TagPolicy,
+
+		Accounts:           accounts,
+		AccountConcurrency: options.AccountConcurrency,
 	}
-	return inputs, options
+	return inputs, options, nil
 }
 
-func configureRotateCommand(cmd *cobra.Command) (RotateCommandOptions, BaseCommandOptions) {
+func configureRotateCommand(cmd *cobra.Command) RotateCommandOptions {
 	listOptions := configureListFlags(cmd)
 	expireOnly, _ := cmd.Flags().GetBool("expire-only")
+	removeLoginProfile, _ := cmd.Flags().GetBool("remove-login-profile")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
 	notify, _ := cmd.Flags().GetBool("notify")
 	skipConfirmation, _ := cmd.Flags().GetBool("skip-confirmation")
-	skipCurrentUser, _ := cmd.Flags().GetBool("skip-current-user")
+	allowCurrentKey, _ := cmd.Flags().GetBool("allow-current-key")
+	staged, _ := cmd.Flags().GetBool("staged")
+	stagedStateFile, _ := cmd.Flags().GetString("staged-state-file")
+	stateDir, _ := cmd.Flags().GetString("state-dir")
+	verifyKey, _ := cmd.Flags().GetBool("verify-key")
+	verifyTimeout, _ := cmd.Flags().GetDuration("verify-timeout")
+	gracePeriod, _ := cmd.Flags().GetDuration("grace-period")
+	deleteAfter, _ := cmd.Flags().GetDuration("delete-after")
+	slackWebhookURL, _ := cmd.Flags().GetString("slack-webhook-url")
+	secretSink, _ := cmd.Flags().GetString("secret-sink")
+	onePasswordVault, _ := cmd.Flags().GetString("op-vault")
+	passwordLength, _ := cmd.Flags().GetInt("password-length")
+	passphrase, _ := cmd.Flags().GetBool("passphrase")
 
 	return RotateCommandOptions{
 		BaseCommandOptions: listOptions,
 		ExpireOnly:         expireOnly,
+		RemoveLoginProfile: removeLoginProfile,
+		DryRun:             dryRun,
 		Notify:             notify,
 		SkipConfirmation:   skipConfirmation,
-		SkipCurrentUser:    skipCurrentUser,
-	}, listOptions
+		AllowCurrentKey:    allowCurrentKey,
+		Staged:             staged,
+		StagedStateFile:    stagedStateFile,
+		StateDir:           stateDir,
+		VerifyKey:          verifyKey,
+		VerifyTimeout:      verifyTimeout,
+		GracePeriod:        gracePeriod,
+		DeleteAfter:        deleteAfter,
+		SlackWebhookURL:    slackWebhookURL,
+		SecretSink:         secretSink,
+		OnePasswordVault:   onePasswordVault,
+		PasswordLength:     passwordLength,
+		Passphrase:         passphrase,
+	}
+}
+
+// orgAccounts returns the client of every account scanned with --org,
+// entered with the credentials of wrapper, and nil without it.
+func orgAccounts(options BaseCommandOptions, wrapper iam.UserWrapper) ([]iam.UserWrapper, error) {
+	if !options.Org {
+		return nil, nil
+	}
+
+	accounts, err := wrapper.DeclareOrgConfig(iam.OrgOptions{
+		AccountsFile: options.AccountsFile,
+		Role:         options.OrgRole,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range accounts {
+		accounts[i] = accounts[i].WithRateLimit(options.RateLimit)
+	}
+	return accounts, nil
+}
+
+// removeCurrentUser drops the caller's own IAM user from data when
+// --skip-current-user is set.
+func removeCurrentUser(options BaseCommandOptions, wrapper iam.UserWrapper, data []iam.UserData) ([]iam.UserData, error) {
+	if !options.SkipUser {
+		return data, nil
+	}
+
+	identity, err := wrapper.GetCallerIdentity()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't resolve the current user for --skip-current-user: %w", err)
+	}
+	return iam.RemoveCurrentUser(data, identity), nil
 }
 
 func initializeBaseCommandFlags(cmd *cobra.Command) {
 	cmd.PersistentFlags().StringP("timezone", "t", "America/Santo_Domingo", "Timezone for displaying dates")
-	cmd.PersistentFlags().StringP("format", "f", "table", "Output format (json, table, file)")
-	cmd.PersistentFlags().StringP("output-file", "o", "./output.json", "Save results to file")
+	cmd.PersistentFlags().StringP("format", "f", "table", "Output format (table, json, yaml, csv, file)")
+	cmd.PersistentFlags().StringP("output-file", "o", "", "Write the output to this file instead of stdout (file format: ./output.json)")
 	cmd.PersistentFlags().StringP("username", "u", "", "Filter by specific IAM username")
 	cmd.PersistentFlags().IntP("age", "a", 90, "Consider keys stale after N days")
 	cmd.PersistentFlags().BoolP("expired-only", "x", false, "Show only expired keys/login profiles")
 	cmd.PersistentFlags().BoolP("skip-confirmation", "s", false, "Skip confirmation prompts")
 	cmd.PersistentFlags().BoolP("skip-current-user", "c", false, "Skip current user")
+	cmd.PersistentFlags().StringSliceP("exclude", "e", nil, "IAM usernames to leave out of listing and rotation")
+	cmd.PersistentFlags().String("path-prefix", "", "Only users whose IAM path starts with this prefix, e.g. /svc/")
+	cmd.PersistentFlags().StringSlice("group", nil, "Only members of any of these IAM groups")
+	cmd.PersistentFlags().StringSlice("exclude-group", nil, "Leave out members of any of these IAM groups")
+	cmd.PersistentFlags().StringSlice("tag", nil, "Only users with every tag: key, key=value or key=glob")
+	cmd.PersistentFlags().StringSlice("exclude-tag", nil, "Leave out users with any tag: key, key=value or key=glob")
+	cmd.PersistentFlags().StringSlice("name", nil, "Only usernames matching any glob, or regex with a re: prefix")
+	cmd.PersistentFlags().StringSlice("exclude-name", nil, "Leave out usernames matching any glob, or regex with a re: prefix")
+	cmd.PersistentFlags().String("audit-log", "", "Audit log recording every IAM change (default ~/.config/gyro/audit.jsonl)")
+	cmd.PersistentFlags().String("exemptions-file", "", "Exemptions file (default ~/.config/gyro/exemptions.yaml)")
+	cmd.PersistentFlags().Int("concurrency", iam.DefaultConcurrency, "Number of users queried in parallel")
+	cmd.PersistentFlags().Float64("rate-limit", iam.DefaultRateLimit, "Maximum IAM calls per second, 0 for no limit")
+	cmd.PersistentFlags().Bool("credential-report", false, "Read users and keys from the IAM credential report instead of per-user calls")
+	cmd.PersistentFlags().Bool("tag-policy", true, "Read the gyro:* IAM user tags as per-user policies; false skips ListUserTags")
+	cmd.PersistentFlags().String("profile", "", "AWS profile from the shared config files")
+	cmd.PersistentFlags().String("role-arn", "", "IAM role to assume before calling AWS")
+	cmd.PersistentFlags().String("external-id", "", "External id passed when assuming --role-arn")
+	cmd.PersistentFlags().String("mfa-serial", "", "MFA device serial or ARN for --role-arn; the token code is prompted for")
+	cmd.PersistentFlags().String("region", "", "AWS region, overriding the profile and AWS_REGION")
+	cmd.PersistentFlags().String("endpoint-url", "", "Send AWS calls to this endpoint instead, e.g. LocalStack")
+	cmd.PersistentFlags().Bool("org", false, "Run in every account of the AWS Organization through an assumed role")
+	cmd.PersistentFlags().String("accounts-file", "", "YAML file listing the accounts used with --org instead of Organizations ListAccounts")
+	cmd.PersistentFlags().String("org-role", iam.DefaultOrgRole, "Role assumed in every account with --org")
+	cmd.PersistentFlags().Int("account-concurrency", 4, "Number of accounts processed in parallel with --org")
 
 	cmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if err := applyConfig(cmd); err != nil {
+			return fmt.Errorf("%w: %w", errUsage, err)
+		}
+
+		if err := validateLimit(cmd); err != nil {
+			return err
+		}
+
 		age, _ := cmd.Flags().GetInt("age")
 		if age < 0 {
-			return fmt.Errorf("age must be greater than 0, got %d", age)
+			return usageErrorf("age must be greater than 0, got %d", age)
 		}
 
-		format, _ := cmd.Flags().GetString("format")
-		validFormats := map[string]bool{"json": true, "table": true, "file": true}
-		if !validFormats[format] {
-			return fmt.Errorf("invalid format '%s'. Valid options are: json, table, file", format)
+		if err := validateFormat(cmd); err != nil {
+			return err
+		}
+
+		concurrency, _ := cmd.Flags().GetInt("concurrency")
+		if concurrency < 1 {
+			return usageErrorf("concurrency must be a positive number, got %d", concurrency)
+		}
+
+		accountConcurrency, _ := cmd.Flags().GetInt("account-concurrency")
+		if accountConcurrency < 1 {
+			return usageErrorf("account-concurrency must be a positive number, got %d", accountConcurrency)
+		}
+
+		orgRole, _ := cmd.Flags().GetString("org-role")
+		if orgRole == "" {
+			return usageErrorf("org-role cannot be empty")
+		}
+
+		rateLimit, _ := cmd.Flags().GetFloat64("rate-limit")
+		if rateLimit < 0 {
+			return usageErrorf("rate-limit cannot be negative, got %g", rateLimit)
 		}
 
 		timeZone, _ := cmd.Flags().GetString("timezone")
 		if timeZone == "" {
-			return fmt.Errorf("timezone cannot be empty")
+			return usageErrorf("timezone cannot be empty")
+		}
+
+		if err := configureFilterFlags(cmd).Validate(); err != nil {
+			return fmt.Errorf("%w: %w", errUsage, err)
+		}
+
+		if err := validateAWSFlags(configureAWSFlags(cmd)); err != nil {
+			return err
 		}
 
 		return nil
@@ -1,10 +1,15 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"time"
 
 	"github.com/charmbracelet/log"
+	"github.com/javiercm1410/gyro/pkg/notify"
 	iam "github.com/javiercm1410/gyro/pkg/providers/aws"
+	"github.com/javiercm1410/gyro/pkg/secrets"
 	"github.com/javiercm1410/gyro/pkg/utils"
 	"github.com/spf13/cobra"
 )
@@ -13,20 +18,38 @@ var rotateCmd = &cobra.Command{
 	Use:     "rotate",
 	Short:   "Rotate IAM Access Keys",
 	Example: "gyro rotate [users|keys] [flags]",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		if len(args) == 0 {
-			log.Fatal("No arguments provided. Valid options are: 'users' and 'keys'")
-		} else {
-			log.Fatalf("Invalid argument '%s'. Valid options are: 'users' and 'keys'", args[0])
+			return usageErrorf("no arguments provided. Valid options are: 'users' and 'keys'")
 		}
+		return usageErrorf("invalid argument '%s'. Valid options are: 'users' and 'keys'", args[0])
 	},
 }
 
-func initRotateCommand(cmd *cobra.Command) (iam.RotateWrapperInputs, BaseCommandOptions) {
-	options, baseOptions := configureRotateCommand(cmd)
+func initRotateCommand(cmd *cobra.Command) (iam.RotateWrapperInputs, RotateCommandOptions, error) {
+	options := configureRotateCommand(cmd)
 
-	wrapper := iam.UserWrapper{
-		IamClient: iam.DeclareConfig(),
+	declared, err := iam.DeclareConfig(options.AWS)
+	if err != nil {
+		return iam.RotateWrapperInputs{}, options, err
+	}
+	wrapper := declared.WithRateLimit(options.RateLimit)
+	accounts, err := orgAccounts(options.BaseCommandOptions, wrapper)
+	if err != nil {
+		return iam.RotateWrapperInputs{}, options, err
+	}
+	if !options.DryRun {
+		if wrapper, err = openRotationAudit(cmd, wrapper, accounts); err != nil {
+			return iam.RotateWrapperInputs{}, options, err
+		}
+	}
+
+	register, err := loadExemptions(cmd)
+	if err != nil {
+		return iam.RotateWrapperInputs{}, options, err
+	}
+	if err := scopeExemptions(register, wrapper, accounts); err != nil {
+		return iam.RotateWrapperInputs{}, options, err
 	}
 
 	return iam.RotateWrapperInputs{
@@ -36,13 +59,61 @@ func initRotateCommand(cmd *cobra.Command) (iam.RotateWrapperInputs, BaseCommand
 			Age:      options.Age,
 			Expired:  options.Expired,
 			UserName: options.User,
+			Exclude:  options.Exclude,
+			Filter:   options.Filter,
 			Client:   wrapper,
+
+			CredentialReport: options.Report,
+			Concurrency:      options.Concurrency,
+			Exemptions:       register,
+			TagPolicy:        options.TagPolicy,
+
+			Accounts:           accounts,
+			AccountConcurrency: options.AccountConcurrency,
+		},
+		DryRun:             options.DryRun,
+		Notify:             options.Notify,
+		ExpireOnly:         options.ExpireOnly,
+		RemoveLoginProfile: options.RemoveLoginProfile,
+		SkipConfirmation:   options.SkipConfirmation,
+		SkipCurrentUser:    options.SkipUser,
+		AllowCurrentKey:    options.AllowCurrentKey,
+		GracePeriod:        options.GracePeriod,
+		DeleteAfter:        options.DeleteAfter,
+		VerifyTimeout:      verifyTimeout(options),
+		StateDir:           runStateDir(options),
+		Password: iam.PasswordOptions{
+			Length:     options.PasswordLength,
+			Passphrase: options.Passphrase,
 		},
-		Notify:           options.Notify,
-		ExpireOnly:       options.ExpireOnly,
-		SkipConfirmation: options.SkipConfirmation,
-		SkipCurrentUser:  options.SkipCurrentUser,
-	}, baseOptions
+	}, options, nil
+}
+
+// verifyTimeout returns how long new keys are verified for, 0 when
+// --verify-key is not set.
+func verifyTimeout(options RotateCommandOptions) time.Duration {
+	if !options.VerifyKey {
+		return 0
+	}
+	return options.VerifyTimeout
+}
+
+// runClient returns the client of the account a rotation run was made in,
+// assuming --org-role there when it was part of an --org run.
+func runClient(options RotateCommandOptions, run *iam.RotationRun) (iam.UserWrapper, error) {
+	declared, err := iam.DeclareConfig(options.AWS)
+	if err != nil || run.AccountId == "" {
+		return declared, err
+	}
+	return declared.DeclareAccountConfig(iam.OrgOptions{Role: options.OrgRole}, run.AccountId)
+}
+
+// runStateDir returns the directory of the rotation run state files.
+func runStateDir(options RotateCommandOptions) string {
+	if options.StateDir == "" {
+		return iam.DefaultRunDir()
+	}
+	return options.StateDir
 }
 
 func askForConfirmation() bool {
@@ -52,31 +123,157 @@ func askForConfirmation() bool {
 	return response == "y"
 }
 
+// newSecretSink builds the sink selected with --secret-sink, or nil when
+// secrets are only displayed.
+func newSecretSink(options RotateCommandOptions) (secrets.Sink, error) {
+	switch options.SecretSink {
+	case "", secrets.SinkNone:
+		return nil, nil
+	case secrets.SinkOnePassword:
+		return secrets.NewOnePasswordSink(os.Getenv(secrets.OnePasswordTokenEnv), options.OnePasswordVault, Version)
+	default:
+		return nil, fmt.Errorf("invalid secret sink '%s'. Valid options are: %s, %s", options.SecretSink, secrets.SinkNone, secrets.SinkOnePassword)
+	}
+}
+
+// notifyRotation posts a summary of the rotation results when --notify is set.
+func notifyRotation(options RotateCommandOptions, kind string, results []iam.UserData) {
+	if !options.Notify {
+		return
+	}
+	if options.SlackWebhookURL == "" {
+		log.Warn("Notification requested but no Slack webhook configured, use --slack-webhook-url")
+		return
+	}
+
+	notifier := notify.NewSlackNotifier(options.SlackWebhookURL)
+	if err := notifier.Notify(notify.Summarize(kind, results)); err != nil {
+		log.Error("Failed to send Slack notification", "error", err)
+		return
+	}
+	log.Info("Rotation summary sent to Slack")
+}
+
+// runStagedRotation advances the staged rotation of every selected user one
+// step and persists the new stages. The users are listed with their stage
+// before confirmation.
+func runStagedRotation(inputs iam.RotateWrapperInputs, options RotateCommandOptions, userKeyData []iam.UserData) error {
+	statePath := options.StagedStateFile
+	if statePath == "" {
+		statePath = iam.DefaultStagedStatePath()
+	}
+
+	state, err := iam.LoadStagedState(statePath)
+	if err != nil {
+		return err
+	}
+
+	userNames, err := iam.UserWrapper.StagedUsers(inputs.Client, userKeyData, inputs, state)
+	if err != nil {
+		return err
+	}
+
+	var sink secrets.Sink
+	if !inputs.DryRun {
+		for _, name := range userNames {
+			stage := "not started"
+			if staged, ok := state.Users[name]; ok {
+				stage = staged.Stage
+			}
+			fmt.Printf("User %s: %s\n", name, stage)
+		}
+		if !inputs.SkipConfirmation && !askForConfirmation() {
+			fmt.Println("Operation aborted.")
+			return nil
+		}
+		if sink, err = newSecretSink(options); err != nil {
+			return err
+		}
+	}
+
+	results := iam.UserWrapper.AdvanceStagedRotation(inputs.Client, userKeyData, userNames, inputs, &state)
+
+	var saveErr error
+	if !inputs.DryRun {
+		saveErr = state.Save(statePath)
+	}
+	if sink != nil {
+		results = secrets.Store(sink, results)
+	}
+
+	utils.DisplayData(options.Format, options.Path, options.Age, results)
+	if !inputs.DryRun {
+		notifyRotation(options, "access keys (staged)", results)
+	}
+	if saveErr != nil {
+		return saveErr
+	}
+	return iam.CheckResults(results)
+}
+
 var rotateUserCmd = &cobra.Command{
 	Use:     "user",
 	Aliases: []string{"users"},
 	Short:   "Rotate credentials for a specific IAM user",
-	Run: func(cmd *cobra.Command, args []string) {
-		inputs, baseOptions := initRotateCommand(cmd)
+	RunE: func(cmd *cobra.Command, args []string) error {
+		inputs, baseOptions, err := initRotateCommand(cmd)
+		if err != nil {
+			return err
+		}
+
+		if baseOptions.PasswordLength < 1 || baseOptions.PasswordLength > 128 {
+			return usageErrorf("--password-length must be between 1 and 128, got %d", baseOptions.PasswordLength)
+		}
 
-		userPasswordData := iam.GetLoginProfiles(inputs.GetWrapperInputs)
+		userPasswordData, listErr := iam.GetLoginProfiles(inputs.GetWrapperInputs)
+		if listErr != nil && userPasswordData == nil {
+			return listErr
+		}
 
-		// if inputs.SkipCurrentUser {
-		// 	iam.RemoveCurrentUser(userPasswordData)
-		// }
+		userPasswordData, err = removeCurrentUser(baseOptions.BaseCommandOptions, inputs.Client, userPasswordData)
+		if err != nil {
+			return err
+		}
 
 		utils.DisplayData(baseOptions.Format, baseOptions.Path, baseOptions.Age, userPasswordData)
 
-		if len(userPasswordData) > 0 {
-			if !inputs.SkipConfirmation && !askForConfirmation() {
-				fmt.Println("Operation aborted.")
-				return
+		if inputs.DryRun {
+			plan, _ := iam.RotateInAccounts(userPasswordData, inputs, iam.UserWrapper.RotateLoginProfiles)
+			utils.DisplayData(baseOptions.Format, baseOptions.Path, baseOptions.Age, plan)
+			log.Info("Dry run: no changes were made")
+			if listErr != nil {
+				return listErr
 			}
-			fmt.Println("Operation confirmed.")
+			return iam.CheckResults(userPasswordData)
+		}
+
+		sink, err := newSecretSink(baseOptions)
+		if err != nil {
+			return err
+		}
+
+		if len(userPasswordData) == 0 {
+			return nil
+		}
+		if !inputs.SkipConfirmation && !askForConfirmation() {
+			fmt.Println("Operation aborted.")
+			return nil
+		}
+		fmt.Println("Operation confirmed.")
 
-			userResults := iam.UserWrapper.RotateLoginProfiles(inputs.GetWrapperInputs.Client, userPasswordData)
-			utils.DisplayData(baseOptions.Format, baseOptions.Path, baseOptions.Age, userResults)
+		userResults, rotateErr := iam.RotateInAccounts(userPasswordData, inputs, iam.UserWrapper.RotateLoginProfiles)
+		if sink != nil {
+			userResults = secrets.Store(sink, userResults)
+		}
+		utils.DisplayData(baseOptions.Format, baseOptions.Path, baseOptions.Age, userResults)
+		notifyRotation(baseOptions, "login profiles", userResults)
+		if err := errors.Join(listErr, rotateErr); err != nil {
+			return err
+		}
+		if err := iam.CheckResults(userPasswordData); err != nil {
+			return err
 		}
+		return iam.CheckResults(userResults)
 	},
 }
 
@@ -84,27 +281,185 @@ var rotateKeyCmd = &cobra.Command{
 	Use:     "key",
 	Aliases: []string{"keys"},
 	Short:   "Rotate credentials for a specific IAM key",
-	Run: func(cmd *cobra.Command, args []string) {
-		inputs, baseOptions := initRotateCommand(cmd)
+	RunE: func(cmd *cobra.Command, args []string) error {
+		inputs, baseOptions, err := initRotateCommand(cmd)
+		if err != nil {
+			return err
+		}
+
+		if inputs.CredentialReport {
+			return usageErrorf("--credential-report cannot be used to rotate keys: the report does not include access key ids")
+		}
+		if baseOptions.Org && baseOptions.Staged {
+			return usageErrorf("--org cannot be used with --staged: the staged state file tracks a single account")
+		}
+		if baseOptions.ExpireOnly && baseOptions.Staged {
+			return usageErrorf("--expire-only cannot be used with --staged: a staged rotation always creates a replacement key")
+		}
+		if baseOptions.VerifyKey && baseOptions.Staged {
+			return usageErrorf("--verify-key cannot be used with --staged: staged rotations wait for the new key to be used instead")
+		}
+		if baseOptions.VerifyKey && baseOptions.VerifyTimeout <= 0 {
+			return usageErrorf("--verify-timeout must be positive, got %s", baseOptions.VerifyTimeout)
+		}
 
-		userKeyData := iam.GetUserAccessKey(inputs.GetWrapperInputs)
+		userKeyData, listErr := iam.GetUserAccessKey(inputs.GetWrapperInputs)
+		if listErr != nil && userKeyData == nil {
+			return listErr
+		}
 
-		// if inputs.SkipCurrentUser {
-		// 	iam.RemoveCurrentUser(userKeyData)
-		// }
+		userKeyData, err = removeCurrentUser(baseOptions.BaseCommandOptions, inputs.Client, userKeyData)
+		if err != nil {
+			return err
+		}
 
 		utils.DisplayData(baseOptions.Format, baseOptions.Path, baseOptions.Age, userKeyData)
 
-		if len(userKeyData) > 0 {
-			if !inputs.SkipConfirmation && !askForConfirmation() {
-				fmt.Println("Operation aborted.")
-				return
+		if baseOptions.Staged {
+			return runStagedRotation(inputs, baseOptions, userKeyData)
+		}
+
+		if inputs.DryRun {
+			plan, _ := iam.RotateInAccounts(userKeyData, inputs, iam.UserWrapper.RotateAccessKeys)
+			utils.DisplayData(baseOptions.Format, baseOptions.Path, baseOptions.Age, plan)
+			log.Info("Dry run: no changes were made")
+			if listErr != nil {
+				return listErr
+			}
+			return iam.CheckResults(userKeyData)
+		}
+
+		sink, err := newSecretSink(baseOptions)
+		if err != nil {
+			return err
+		}
+
+		if len(userKeyData) == 0 {
+			return nil
+		}
+		if !inputs.SkipConfirmation && !askForConfirmation() {
+			fmt.Println("Operation aborted.")
+			return nil
+		}
+		fmt.Println("Operation confirmed.")
+
+		keyResults, rotateErr := iam.RotateInAccounts(userKeyData, inputs, iam.UserWrapper.RotateAccessKeys)
+		if sink != nil {
+			keyResults = secrets.Store(sink, keyResults)
+		}
+		utils.DisplayData(baseOptions.Format, baseOptions.Path, baseOptions.Age, keyResults)
+		notifyRotation(baseOptions, "access keys", keyResults)
+		if err := errors.Join(listErr, rotateErr); err != nil {
+			return err
+		}
+		if err := iam.CheckResults(userKeyData); err != nil {
+			return err
+		}
+		return iam.CheckResults(keyResults)
+	},
+}
+
+var rotateResumeCmd = &cobra.Command{
+	Use:     "resume <run-id>",
+	Short:   "Continue an interrupted access key rotation",
+	Example: "gyro rotate resume 20240611T093000-4f2a1c",
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		options := configureRotateCommand(cmd)
+		if options.DryRun {
+			return usageErrorf("--dry-run cannot be used with resume: the run's plan is already recorded")
+		}
+
+		run, err := iam.LoadRotationRun(runStateDir(options), args[0])
+		if err != nil {
+			return err
+		}
+		if !run.Pending() {
+			log.Infof("Rotation run %s has already completed", run.RunId)
+			return nil
+		}
+		if run.Verifies() && options.VerifyTimeout <= 0 {
+			return usageErrorf("--verify-timeout must be positive, got %s", options.VerifyTimeout)
+		}
+
+		declared, err := runClient(options, run)
+		if err != nil {
+			return err
+		}
+		wrapper, err := openRotationAudit(cmd, declared.WithRateLimit(options.RateLimit), nil)
+		if err != nil {
+			return err
+		}
+
+		sink, err := newSecretSink(options)
+		if err != nil {
+			return err
+		}
+
+		for _, user := range run.Users {
+			if user.Pending() {
+				fmt.Printf("User %s: %s\n", user.UserName, user.Status)
 			}
-			fmt.Println("Operation confirmed.")
+		}
+		if !options.SkipConfirmation && !askForConfirmation() {
+			fmt.Println("Operation aborted.")
+			return nil
+		}
+		fmt.Println("Operation confirmed.")
 
-			keyResults := iam.UserWrapper.RotateAccessKeys(inputs.GetWrapperInputs.Client, userKeyData, inputs.SkipConfirmation)
-			utils.DisplayData(baseOptions.Format, baseOptions.Path, baseOptions.Age, keyResults)
+		keyResults := iam.WithAccount(wrapper.ResumeRotation(run, options.VerifyTimeout), wrapper.Account)
+		if sink != nil {
+			keyResults = secrets.Store(sink, keyResults)
 		}
+		utils.DisplayData(options.Format, options.Path, options.Age, keyResults)
+		notifyRotation(options, "access keys (resumed)", keyResults)
+		return iam.CheckResults(keyResults)
+	},
+}
+
+var rotateRollbackCmd = &cobra.Command{
+	Use:     "rollback <run-id>",
+	Short:   "Undo the access key changes of a rotation run",
+	Example: "gyro rotate rollback 20240611T093000-4f2a1c --dry-run",
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		options := configureRotateCommand(cmd)
+
+		run, err := iam.LoadRotationRun(runStateDir(options), args[0])
+		if err != nil {
+			return err
+		}
+
+		declared, err := runClient(options, run)
+		if err != nil {
+			return err
+		}
+		wrapper := declared.WithRateLimit(options.RateLimit)
+
+		plan := iam.WithAccount(wrapper.RollbackRotation(run, true), wrapper.Account)
+		if len(plan) == 0 {
+			log.Infof("Rotation run %s has nothing to roll back", run.RunId)
+			return nil
+		}
+		utils.DisplayData(options.Format, options.Path, options.Age, plan)
+		if options.DryRun {
+			log.Info("Dry run: no changes were made")
+			return nil
+		}
+
+		if wrapper, err = openRotationAudit(cmd, wrapper, nil); err != nil {
+			return err
+		}
+		if !options.SkipConfirmation && !askForConfirmation() {
+			fmt.Println("Operation aborted.")
+			return nil
+		}
+		fmt.Println("Operation confirmed.")
+
+		results := iam.WithAccount(wrapper.RollbackRotation(run, false), wrapper.Account)
+		utils.DisplayData(options.Format, options.Path, options.Age, results)
+		notifyRotation(options, "access keys (rolled back)", results)
+		return iam.CheckResults(results)
 	},
 }
 
@@ -112,6 +467,25 @@ func init() {
 	RootCmd.AddCommand(rotateCmd)
 	rotateCmd.AddCommand(rotateUserCmd)
 	rotateCmd.AddCommand(rotateKeyCmd)
+	rotateCmd.AddCommand(rotateResumeCmd)
+	rotateCmd.AddCommand(rotateRollbackCmd)
 
 	initializeBaseCommandFlags(rotateCmd)
+	rotateCmd.PersistentFlags().Bool("expire-only", false, "Only deactivate stale credentials, never issue new ones")
+	rotateCmd.PersistentFlags().Bool("remove-login-profile", false, "With --expire-only, delete stale console passwords instead of forcing a reset")
+	rotateCmd.PersistentFlags().Bool("allow-current-key", false, "Allow deactivating or deleting the access key gyro is authenticated with")
+	rotateUserCmd.Flags().Int("password-length", iam.DefaultPasswordLength, "Length of generated temporary passwords, raised to the account policy minimum")
+	rotateUserCmd.Flags().Bool("passphrase", false, "Generate diceware passphrases instead of random characters")
+	rotateKeyCmd.Flags().Bool("staged", false, "Rotate in stages: create, wait for use or grace period, deactivate, delete")
+	rotateKeyCmd.Flags().Duration("grace-period", 7*24*time.Hour, "With --staged, time to wait for the new key to be used before deactivating the old one")
+	rotateKeyCmd.Flags().Duration("delete-after", 7*24*time.Hour, "With --staged, time between deactivating and deleting the old key")
+	rotateCmd.PersistentFlags().Bool("verify-key", false, "Check that a new access key authenticates with STS before retiring the old ones, deleting it if it does not")
+	rotateCmd.PersistentFlags().Duration("verify-timeout", 2*time.Minute, "With --verify-key, how long to wait for a new key to be accepted")
+	rotateKeyCmd.Flags().String("staged-state-file", "", "With --staged, file tracking each user's stage (default ~/.config/gyro/staged-rotation.json)")
+	rotateCmd.PersistentFlags().String("state-dir", "", "Directory of the rotation run state used by 'rotate resume' and 'rotate rollback' (default ~/.config/gyro/runs)")
+	rotateCmd.PersistentFlags().Bool("dry-run", false, "Show the planned changes without calling IAM")
+	rotateCmd.PersistentFlags().Bool("notify", false, "Send a rotation summary to the configured notification targets")
+	rotateCmd.PersistentFlags().String("slack-webhook-url", "", "Slack incoming webhook used by --notify")
+	rotateCmd.PersistentFlags().String("secret-sink", secrets.SinkNone, "Where to store new credentials (none, 1password)")
+	rotateCmd.PersistentFlags().String("op-vault", "", "1Password vault id or name used by --secret-sink 1password")
 }
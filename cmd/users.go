@@ -11,12 +11,28 @@ var usersCmd = &cobra.Command{
 	Short:   "Get IAM users",
 	Aliases: []string{"users", "u"},
 	Example: "gyro users",
-	Run: func(cmd *cobra.Command, args []string) {
-		inputs, options := configureListCommand(cmd)
+	RunE: func(cmd *cobra.Command, args []string) error {
+		inputs, options, err := configureListCommand(cmd)
+		if err != nil {
+			return err
+		}
 
-		userPasswordData := iam.GetLoginProfiles(inputs)
+		// With --org, the accounts that could be read are shown even when
+		// others failed.
+		userPasswordData, listErr := iam.GetLoginProfiles(inputs)
+		if listErr != nil && userPasswordData == nil {
+			return listErr
+		}
+		userPasswordData, err = removeCurrentUser(options, inputs.Client, userPasswordData)
+		if err != nil {
+			return err
+		}
 
 		utils.DisplayData(options.Format, options.Path, inputs.Age, userPasswordData)
+		if listErr != nil {
+			return listErr
+		}
+		return iam.CheckResults(userPasswordData)
 	},
 }
 
@@ -24,4 +40,5 @@ func init() {
 	RootCmd.AddCommand(usersCmd)
 
 	initializeBaseCommandFlags(usersCmd)
+	usersCmd.Flags().String("from-report", "", "Analyse a saved credential report CSV instead of calling AWS")
 }
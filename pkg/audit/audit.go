@@ -0,0 +1,285 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Outcomes of an audited call.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// ErrTampered is wrapped by the errors Verify returns for a log whose records
+// were modified, removed, reordered or inserted.
+var ErrTampered = errors.New("audit log tampered")
+
+// Record is one line of the audit log. Hash is the SHA-256 of the record with
+// an empty Hash, and PrevHash the Hash of the record before it, so changing
+// any record breaks the chain from that point on.
+type Record struct {
+	Seq         int       `json:"seq"`
+	Time        time.Time `json:"time"`
+	Caller      string    `json:"caller"`
+	AccountId   string    `json:"accountId,omitempty"`
+	Action      string    `json:"action"`
+	UserName    string    `json:"userName"`
+	AccessKeyId string    `json:"accessKeyId,omitempty"`
+	Detail      string    `json:"detail,omitempty"`
+	Outcome     string    `json:"outcome"`
+	Error       string    `json:"error,omitempty"`
+	PrevHash    string    `json:"prevHash"`
+	Hash        string    `json:"hash"`
+}
+
+// Log appends hash-chained records to a JSON Lines file. It is safe for
+// concurrent use within one process, and every append holds an exclusive
+// lock on the file so that gyro processes sharing a log keep one chain.
+type Log struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	seq      int
+	lastHash string
+	// size is the length of the file after the last record this Log read
+	// or wrote. A different size means another process appended since.
+	size int64
+	err  error
+}
+
+// DefaultPath returns ~/.config/gyro/audit.jsonl.
+func DefaultPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "audit.jsonl"
+	}
+	return filepath.Join(home, ".config", "gyro", "audit.jsonl")
+}
+
+// Open opens the audit log at path for appending, creating it when needed,
+// and continues the chain from its last record.
+func Open(path string) (*Log, error) {
+	auditLog := &Log{path: path, size: -1}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("error creating directory for %s: %w", path, err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit log %s: %w", path, err)
+	}
+	auditLog.file = file
+
+	err = auditLog.locked(auditLog.syncChain)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return auditLog, nil
+}
+
+// locked runs fn while holding the exclusive lock on the log file.
+func (auditLog *Log) locked(fn func() error) error {
+	if err := lockFile(auditLog.file); err != nil {
+		return fmt.Errorf("error locking audit log %s: %w", auditLog.path, err)
+	}
+	err := fn()
+	if unlockErr := unlockFile(auditLog.file); err == nil && unlockErr != nil {
+		err = fmt.Errorf("error unlocking audit log %s: %w", auditLog.path, unlockErr)
+	}
+	return err
+}
+
+// syncChain continues the chain from the last record on disk when another
+// process appended to the log since this Log last read or wrote it. It must
+// be called with the file locked.
+func (auditLog *Log) syncChain() error {
+	info, err := auditLog.file.Stat()
+	if err != nil {
+		return fmt.Errorf("error reading audit log %s: %w", auditLog.path, err)
+	}
+	if info.Size() == auditLog.size {
+		return nil
+	}
+
+	last, err := lastRecord(auditLog.path)
+	if err != nil {
+		return err
+	}
+	auditLog.seq, auditLog.lastHash = 0, ""
+	if last != nil {
+		auditLog.seq = last.Seq
+		auditLog.lastHash = last.Hash
+	}
+	auditLog.size = info.Size()
+	return nil
+}
+
+func lastRecord(path string) (*Record, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading audit log %s: %w", path, err)
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(raw), []byte("\n"))
+	if len(lines[len(lines)-1]) == 0 {
+		return nil, nil
+	}
+	var record Record
+	if err := json.Unmarshal(lines[len(lines)-1], &record); err != nil {
+		return nil, fmt.Errorf("error parsing the last record of audit log %s: %w", path, err)
+	}
+	return &record, nil
+}
+
+// Append chains record to the log and writes it to disk, holding the file
+// lock from reading the chain to the write. The first error is also kept
+// for Err.
+func (auditLog *Log) Append(record Record) error {
+	auditLog.mu.Lock()
+	defer auditLog.mu.Unlock()
+
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	record.Time = record.Time.UTC()
+
+	err := auditLog.locked(func() error {
+		if err := auditLog.syncChain(); err != nil {
+			return err
+		}
+		if err := auditLog.write(record); err != nil {
+			return fmt.Errorf("error writing audit log %s: %w", auditLog.path, err)
+		}
+		return nil
+	})
+	if err != nil && auditLog.err == nil {
+		auditLog.err = err
+	}
+	return err
+}
+
+// write chains record after the last one and appends it to the file. It must
+// be called with the file locked.
+func (auditLog *Log) write(record Record) error {
+	record.Seq = auditLog.seq + 1
+	record.PrevHash = auditLog.lastHash
+	record.Hash = ""
+
+	hash, err := recordHash(record)
+	if err != nil {
+		return err
+	}
+	record.Hash = hash
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err := auditLog.file.Write(line); err != nil {
+		return err
+	}
+	if err := auditLog.file.Sync(); err != nil {
+		return err
+	}
+
+	auditLog.seq = record.Seq
+	auditLog.lastHash = record.Hash
+	auditLog.size += int64(len(line))
+	return nil
+}
+
+// Err returns the first error met while appending, if any.
+func (auditLog *Log) Err() error {
+	if auditLog == nil {
+		return nil
+	}
+	auditLog.mu.Lock()
+	defer auditLog.mu.Unlock()
+	return auditLog.err
+}
+
+// Close closes the underlying file.
+func (auditLog *Log) Close() error {
+	if auditLog == nil || auditLog.file == nil {
+		return nil
+	}
+	return auditLog.file.Close()
+}
+
+// recordHash hashes the JSON encoding of record, whose Hash must be empty.
+func recordHash(record Record) (string, error) {
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Verify walks the audit log at path and checks every record against the
+// chain. It returns the number of valid records and, at the first broken
+// link, an error wrapping ErrTampered. Records removed from the end of the
+// log cannot be detected by the chain alone.
+func Verify(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("error opening audit log %s: %w", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	count, prevHash := 0, ""
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var record Record
+		decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&record); err != nil {
+			return count, fmt.Errorf("%w: line %d is not a valid record: %v", ErrTampered, line, err)
+		}
+
+		if record.Seq != count+1 {
+			return count, fmt.Errorf("%w: line %d has sequence number %d, expected %d", ErrTampered, line, record.Seq, count+1)
+		}
+		if record.PrevHash != prevHash {
+			return count, fmt.Errorf("%w: line %d does not follow the previous record", ErrTampered, line)
+		}
+
+		stored := record.Hash
+		record.Hash = ""
+		hash, err := recordHash(record)
+		if err != nil {
+			return count, err
+		}
+		if hash != stored {
+			return count, fmt.Errorf("%w: line %d was modified", ErrTampered, line)
+		}
+
+		count++
+		prevHash = stored
+	}
+	if err := scanner.Err(); err != nil {
+		return count, fmt.Errorf("error reading audit log %s: %w", path, err)
+	}
+	return count, nil
+}
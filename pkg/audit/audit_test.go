@@ -0,0 +1,180 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeLog appends records for three users to a new log and returns its
+// path.
+func writeLog(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditLog, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for _, userName := range []string{"alice", "bob", "carol"} {
+		record := Record{
+			Time:     time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			Caller:   "arn:aws:iam::000000000000:user/ops",
+			Action:   "create-key",
+			UserName: userName,
+			Outcome:  OutcomeSuccess,
+		}
+		if err := auditLog.Append(record); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	if err := auditLog.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return path
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name      string
+		tamper    func(lines [][]byte) [][]byte
+		wantCount int
+		wantErr   bool
+	}{
+		{
+			name:      "untouched log",
+			tamper:    func(lines [][]byte) [][]byte { return lines },
+			wantCount: 3,
+		},
+		{
+			name: "modified record",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[1] = bytes.Replace(lines[1], []byte(`"bob"`), []byte(`"mallory"`), 1)
+				return lines
+			},
+			wantCount: 1,
+			wantErr:   true,
+		},
+		{
+			name: "removed record",
+			tamper: func(lines [][]byte) [][]byte {
+				return append(lines[:1], lines[2:]...)
+			},
+			wantCount: 1,
+			wantErr:   true,
+		},
+		{
+			name: "reordered records",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			},
+			wantCount: 1,
+			wantErr:   true,
+		},
+		{
+			name: "inserted record",
+			tamper: func(lines [][]byte) [][]byte {
+				return append(lines[:2], append([][]byte{lines[1]}, lines[2:]...)...)
+			},
+			wantCount: 2,
+			wantErr:   true,
+		},
+		{
+			name: "unknown field",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[0] = bytes.Replace(lines[0], []byte(`{`), []byte(`{"extra":1,`), 1)
+				return lines
+			},
+			wantCount: 0,
+			wantErr:   true,
+		},
+		{
+			name: "truncated tail is not detected",
+			tamper: func(lines [][]byte) [][]byte {
+				return lines[:2]
+			},
+			wantCount: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeLog(t)
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("ReadFile: %v", err)
+			}
+			lines := test.tamper(bytes.Split(bytes.TrimSpace(raw), []byte("\n")))
+			if err := os.WriteFile(path, append(bytes.Join(lines, []byte("\n")), '\n'), 0o600); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+
+			count, err := Verify(path)
+			if count != test.wantCount {
+				t.Errorf("count = %d, want %d", count, test.wantCount)
+			}
+			if test.wantErr && !errors.Is(err, ErrTampered) {
+				t.Errorf("err = %v, want ErrTampered", err)
+			}
+			if !test.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestOpenContinuesChain(t *testing.T) {
+	path := writeLog(t)
+
+	auditLog, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := auditLog.Append(Record{Action: "delete-key", UserName: "alice", Outcome: OutcomeSuccess}); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	auditLog.Close()
+
+	if count, err := Verify(path); count != 4 || err != nil {
+		t.Errorf("Verify = %d, %v, want 4, nil", count, err)
+	}
+}
+
+func TestAppendSharedLog(t *testing.T) {
+	path := writeLog(t)
+
+	// Two Logs on one file stand in for two gyro processes; each holds its
+	// own file description, so they only stay chained through the lock.
+	var logs []*Log
+	for range 2 {
+		auditLog, err := Open(path)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		defer auditLog.Close()
+		logs = append(logs, auditLog)
+	}
+
+	var wg sync.WaitGroup
+	for i, auditLog := range logs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				if err := auditLog.Append(Record{Action: "create-key", UserName: fmt.Sprintf("user-%d", i), Outcome: OutcomeSuccess}); err != nil {
+					t.Errorf("Append: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if count, err := Verify(path); count != 43 || err != nil {
+		t.Errorf("Verify = %d, %v, want 43, nil", count, err)
+	}
+}
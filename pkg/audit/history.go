@@ -0,0 +1,106 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"time"
+)
+
+// Query selects audit records. Empty fields match every record; Since and
+// Until bound the record time, inclusive and exclusive respectively.
+type Query struct {
+	UserName    string
+	AccessKeyId string
+	Actions     []string
+	Since       time.Time
+	Until       time.Time
+}
+
+// Read returns every record of the audit log at path, oldest first. A
+// missing log has no records.
+func Read(path string) ([]Record, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit log %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("error parsing audit log %s, line %d: %w", path, line, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading audit log %s: %w", path, err)
+	}
+	return records, nil
+}
+
+// Matches reports whether record is selected by the query.
+func (query Query) Matches(record Record) bool {
+	switch {
+	case query.UserName != "" && record.UserName != query.UserName:
+		return false
+	case query.AccessKeyId != "" && record.AccessKeyId != query.AccessKeyId:
+		return false
+	case len(query.Actions) > 0 && !slices.Contains(query.Actions, record.Action):
+		return false
+	case !query.Since.IsZero() && record.Time.Before(query.Since):
+		return false
+	case !query.Until.IsZero() && !record.Time.Before(query.Until):
+		return false
+	}
+	return true
+}
+
+// Filter returns the records selected by the query, in log order.
+func Filter(records []Record, query Query) []Record {
+	var selected []Record
+	for _, record := range records {
+		if query.Matches(record) {
+			selected = append(selected, record)
+		}
+	}
+	return selected
+}
+
+// LastChanged returns, for every user, the time of the latest successful
+// record of one of actions. It is keyed by UserKey.
+func LastChanged(records []Record, actions ...string) map[string]time.Time {
+	last := map[string]time.Time{}
+	for _, record := range records {
+		if record.Outcome != OutcomeSuccess || !slices.Contains(actions, record.Action) {
+			continue
+		}
+		key := UserKey(record.AccountId, record.UserName)
+		if record.Time.After(last[key]) {
+			last[key] = record.Time
+		}
+	}
+	return last
+}
+
+// UserKey identifies a user across accounts. Users of the default account,
+// recorded without an account id, are keyed by name alone.
+func UserKey(accountId, userName string) string {
+	if accountId == "" {
+		return userName
+	}
+	return accountId + "/" + userName
+}
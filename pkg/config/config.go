@@ -0,0 +1,240 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is prepended to flag names to build the environment variables
+// gyro reads, e.g. GYRO_AGE or GYRO_OUTPUT_FILE.
+const EnvPrefix = "GYRO_"
+
+// Config holds the defaults read from the gyro configuration file. Pointer
+// fields distinguish "not set" from zero values.
+type Config struct {
+	Age        *int     `yaml:"age"`
+	TimeZone   string   `yaml:"timezone"`
+	Format     string   `yaml:"format"`
+	OutputFile string   `yaml:"output-file"`
+	Quantity   *int32   `yaml:"quantity"`
+	Limit      *int32   `yaml:"limit"`
+	All        *bool    `yaml:"all"`
+	Exclude    []string `yaml:"exclude"`
+	// CredentialReport reads listings from the IAM credential report.
+	CredentialReport *bool `yaml:"credential-report"`
+	// TagPolicy reads the gyro:* user tags as per-user policies.
+	TagPolicy      *bool          `yaml:"tag-policy"`
+	Concurrency    *int           `yaml:"concurrency"`
+	RateLimit      *float64       `yaml:"rate-limit"`
+	Filters        FiltersConfig  `yaml:"filters"`
+	ExemptionsFile string         `yaml:"exemptions-file"`
+	AuditLog       string         `yaml:"audit-log"`
+	Notify         NotifyConfig   `yaml:"notify"`
+	Rotation       RotationConfig `yaml:"rotation"`
+	Secrets        SecretsConfig  `yaml:"secrets"`
+	Org            OrgConfig      `yaml:"org"`
+	AWS            AWSConfig      `yaml:"aws"`
+}
+
+// FiltersConfig holds the default user selection filters.
+type FiltersConfig struct {
+	PathPrefix    string   `yaml:"path-prefix"`
+	Groups        []string `yaml:"groups"`
+	ExcludeGroups []string `yaml:"exclude-groups"`
+	Tags          []string `yaml:"tags"`
+	ExcludeTags   []string `yaml:"exclude-tags"`
+	Names         []string `yaml:"names"`
+	ExcludeNames  []string `yaml:"exclude-names"`
+}
+
+// NotifyConfig holds the notification targets used after a rotation.
+type NotifyConfig struct {
+	Enabled         *bool  `yaml:"enabled"`
+	SlackWebhookURL string `yaml:"slack-webhook-url"`
+}
+
+// RotationConfig holds the rotation policy defaults.
+type RotationConfig struct {
+	ExpireOnly         *bool  `yaml:"expire-only"`
+	RemoveLoginProfile *bool  `yaml:"remove-login-profile"`
+	DryRun             *bool  `yaml:"dry-run"`
+	SkipConfirmation   *bool  `yaml:"skip-confirmation"`
+	SkipCurrentUser    *bool  `yaml:"skip-current-user"`
+	Staged             *bool  `yaml:"staged"`
+	GracePeriod        string `yaml:"grace-period"`
+	DeleteAfter        string `yaml:"delete-after"`
+	StateDir           string `yaml:"state-dir"`
+	VerifyKey          *bool  `yaml:"verify-key"`
+	VerifyTimeout      string `yaml:"verify-timeout"`
+	PasswordLength     *int   `yaml:"password-length"`
+	Passphrase         *bool  `yaml:"passphrase"`
+}
+
+// OrgConfig selects the accounts scanned with --org and how they are
+// entered.
+type OrgConfig struct {
+	Enabled      *bool  `yaml:"enabled"`
+	AccountsFile string `yaml:"accounts-file"`
+	Role         string `yaml:"role"`
+	Concurrency  *int   `yaml:"concurrency"`
+}
+
+// AWSConfig selects the credentials and endpoint gyro calls AWS with.
+type AWSConfig struct {
+	Profile     string `yaml:"profile"`
+	RoleArn     string `yaml:"role-arn"`
+	ExternalId  string `yaml:"external-id"`
+	MfaSerial   string `yaml:"mfa-serial"`
+	Region      string `yaml:"region"`
+	EndpointURL string `yaml:"endpoint-url"`
+}
+
+// SecretsConfig selects where rotated credentials are stored.
+type SecretsConfig struct {
+	Sink             string `yaml:"sink"`
+	OnePasswordVault string `yaml:"op-vault"`
+}
+
+// DefaultPath returns ~/.config/gyro/config.yaml.
+func DefaultPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "gyro", "config.yaml")
+}
+
+// EnvName returns the environment variable that overrides a flag.
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Load reads the configuration file at path. A missing file is only an error
+// when the path was requested explicitly; unknown keys always are.
+func Load(path string, explicit bool) (Config, error) {
+	var cfg Config
+	if path == "" {
+		return cfg, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) && !explicit {
+			return cfg, nil
+		}
+		return cfg, fmt.Errorf("error reading config file %s: %w", path, err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(raw))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return cfg, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// FlagValues maps the settings present in the file to the flag names they
+// provide defaults for.
+func (c Config) FlagValues() map[string]string {
+	values := map[string]string{}
+
+	if c.Age != nil {
+		values["age"] = strconv.Itoa(*c.Age)
+	}
+	if c.Quantity != nil {
+		values["quantity"] = strconv.Itoa(int(*c.Quantity))
+	}
+	if c.Limit != nil {
+		values["limit"] = strconv.Itoa(int(*c.Limit))
+	}
+	setBool(values, "all", c.All)
+	setString(values, "timezone", c.TimeZone)
+	setString(values, "format", c.Format)
+	setString(values, "output-file", c.OutputFile)
+	setSlice(values, "exclude", c.Exclude)
+	setBool(values, "credential-report", c.CredentialReport)
+	setBool(values, "tag-policy", c.TagPolicy)
+	if c.Concurrency != nil {
+		values["concurrency"] = strconv.Itoa(*c.Concurrency)
+	}
+	if c.RateLimit != nil {
+		values["rate-limit"] = strconv.FormatFloat(*c.RateLimit, 'f', -1, 64)
+	}
+
+	setString(values, "path-prefix", c.Filters.PathPrefix)
+	setSlice(values, "group", c.Filters.Groups)
+	setSlice(values, "exclude-group", c.Filters.ExcludeGroups)
+	setSlice(values, "tag", c.Filters.Tags)
+	setSlice(values, "exclude-tag", c.Filters.ExcludeTags)
+	setSlice(values, "name", c.Filters.Names)
+	setSlice(values, "exclude-name", c.Filters.ExcludeNames)
+
+	setString(values, "exemptions-file", c.ExemptionsFile)
+	setString(values, "audit-log", c.AuditLog)
+
+	setBool(values, "notify", c.Notify.Enabled)
+	setString(values, "slack-webhook-url", c.Notify.SlackWebhookURL)
+
+	setBool(values, "expire-only", c.Rotation.ExpireOnly)
+	setBool(values, "remove-login-profile", c.Rotation.RemoveLoginProfile)
+	setBool(values, "dry-run", c.Rotation.DryRun)
+	setBool(values, "skip-confirmation", c.Rotation.SkipConfirmation)
+	setBool(values, "skip-current-user", c.Rotation.SkipCurrentUser)
+	setBool(values, "staged", c.Rotation.Staged)
+	setString(values, "grace-period", c.Rotation.GracePeriod)
+	setString(values, "delete-after", c.Rotation.DeleteAfter)
+	setString(values, "state-dir", c.Rotation.StateDir)
+	setBool(values, "verify-key", c.Rotation.VerifyKey)
+	setString(values, "verify-timeout", c.Rotation.VerifyTimeout)
+	if c.Rotation.PasswordLength != nil {
+		values["password-length"] = strconv.Itoa(*c.Rotation.PasswordLength)
+	}
+	setBool(values, "passphrase", c.Rotation.Passphrase)
+
+	setString(values, "secret-sink", c.Secrets.Sink)
+	setString(values, "op-vault", c.Secrets.OnePasswordVault)
+
+	setBool(values, "org", c.Org.Enabled)
+	setString(values, "accounts-file", c.Org.AccountsFile)
+	setString(values, "org-role", c.Org.Role)
+	if c.Org.Concurrency != nil {
+		values["account-concurrency"] = strconv.Itoa(*c.Org.Concurrency)
+	}
+
+	setString(values, "profile", c.AWS.Profile)
+	setString(values, "role-arn", c.AWS.RoleArn)
+	setString(values, "external-id", c.AWS.ExternalId)
+	setString(values, "mfa-serial", c.AWS.MfaSerial)
+	setString(values, "region", c.AWS.Region)
+	setString(values, "endpoint-url", c.AWS.EndpointURL)
+
+	return values
+}
+
+func setString(values map[string]string, name, value string) {
+	if value != "" {
+		values[name] = value
+	}
+}
+
+func setBool(values map[string]string, name string, value *bool) {
+	if value != nil {
+		values[name] = strconv.FormatBool(*value)
+	}
+}
+
+func setSlice(values map[string]string, name string, value []string) {
+	if len(value) > 0 {
+		values[name] = strings.Join(value, ",")
+	}
+}
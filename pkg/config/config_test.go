@@ -0,0 +1,108 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readmeConfig is the example configuration file from the README.
+const readmeConfig = `age: 60
+timezone: UTC
+format: json
+output-file: ./gyro.json
+exclude:
+  - ci-bot
+limit: 0
+credential-report: false
+tag-policy: true
+concurrency: 8
+rate-limit: 10
+exemptions-file: /etc/gyro/exemptions.yaml
+audit-log: /var/log/gyro/audit.jsonl
+filters:
+  path-prefix: /svc/
+  tags:
+    - team=payments
+  exclude-groups:
+    - break-glass
+notify:
+  enabled: true
+  slack-webhook-url: https://hooks.slack.com/services/...
+rotation:
+  expire-only: false
+  dry-run: false
+  skip-confirmation: false
+  skip-current-user: true
+  staged: false
+  grace-period: 72h
+  delete-after: 168h
+  state-dir: /var/lib/gyro/runs
+  verify-key: true
+  verify-timeout: 2m
+  password-length: 24
+  passphrase: false
+secrets:
+  sink: 1password
+  op-vault: Infrastructure
+aws:
+  profile: security
+  role-arn: arn:aws:iam::123456789012:role/KeyRotation
+  external-id: rotation
+  region: us-east-1
+org:
+  enabled: false
+  accounts-file: /etc/gyro/accounts.yaml
+  role: OrganizationAccountAccessRole
+  concurrency: 4
+`
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		return path
+	}
+	readme := write("readme.yaml", readmeConfig)
+	empty := write("empty.yaml", "")
+	misspelled := write("misspelled.yaml", "age: 30\nlimt: 5\n")
+	nested := write("nested.yaml", "rotation:\n  dryrun: true\n")
+	missing := filepath.Join(dir, "missing.yaml")
+
+	tests := []struct {
+		name     string
+		path     string
+		explicit bool
+		wantAge  int
+		wantErr  bool
+	}{
+		{name: "README example", path: readme, wantAge: 60},
+		{name: "empty file", path: empty},
+		{name: "missing default file", path: missing},
+		{name: "missing requested file", path: missing, explicit: true, wantErr: true},
+		{name: "misspelled key", path: misspelled, wantErr: true},
+		{name: "misspelled nested key", path: nested, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := Load(test.path, test.explicit)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Load() error = %v, want error %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			age := 0
+			if cfg.Age != nil {
+				age = *cfg.Age
+			}
+			if age != test.wantAge {
+				t.Errorf("age = %d, want %d", age, test.wantAge)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package exemptions
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// accountIdPattern matches an AWS account id.
+var accountIdPattern = regexp.MustCompile(`^[0-9]{12}$`)
+
+// Exemption keeps a user, or one of its access keys, out of rotation until
+// Expires. An entry without AccessKeyId covers every credential of the user,
+// and an entry without Account applies to the user of that name in every
+// account.
+type Exemption struct {
+	User        string    `yaml:"user"`
+	Account     string    `yaml:"account,omitempty"`
+	AccessKeyId string    `yaml:"access-key-id,omitempty"`
+	Reason      string    `yaml:"reason"`
+	Approver    string    `yaml:"approver"`
+	Expires     time.Time `yaml:"expires"`
+}
+
+// Register is the exemptions file:
+//
+//	exemptions:
+//	  - user: vendor-sync
+//	    account: "123456789012"
+//	    access-key-id: AKIA...
+//	    reason: Vendor integration cannot reload keys before the migration
+//	    approver: security@example.com
+//	    expires: 2026-12-31
+type Register struct {
+	Exemptions []Exemption `yaml:"exemptions"`
+	// DefaultAccount is the account of lookups that name none, i.e. the
+	// account of the default credentials. It is set by the caller, see
+	// AccountScoped.
+	DefaultAccount string `yaml:"-"`
+}
+
+// Status is an exemption as reported by `gyro exemptions check`.
+type Status struct {
+	Exemption
+	// DaysLeft is negative once the exemption has expired.
+	DaysLeft int
+	Expired  bool
+}
+
+// DefaultPath returns ~/.config/gyro/exemptions.yaml.
+func DefaultPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "gyro", "exemptions.yaml")
+}
+
+// Load reads and validates the exemptions file at path. A missing file is an
+// empty register unless the path was requested explicitly.
+func Load(path string, explicit bool) (*Register, error) {
+	register := &Register{}
+	if path == "" {
+		return register, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) && !explicit {
+			return register, nil
+		}
+		return nil, fmt.Errorf("error reading exemptions file %s: %w", path, err)
+	}
+
+	if err := yaml.Unmarshal(raw, register); err != nil {
+		return nil, fmt.Errorf("error parsing exemptions file %s: %w", path, err)
+	}
+
+	for i, exemption := range register.Exemptions {
+		if err := exemption.validate(); err != nil {
+			return nil, fmt.Errorf("exemptions file %s, entry %d: %w", path, i+1, err)
+		}
+	}
+	return register, nil
+}
+
+func (exemption Exemption) validate() error {
+	switch {
+	case exemption.User == "":
+		return errors.New("user is required")
+	case exemption.Reason == "":
+		return fmt.Errorf("exemption of %s has no reason", exemption.User)
+	case exemption.Approver == "":
+		return fmt.Errorf("exemption of %s has no approver", exemption.User)
+	case exemption.Expires.IsZero():
+		return fmt.Errorf("exemption of %s has no expiry date", exemption.User)
+	case exemption.Account != "" && !accountIdPattern.MatchString(exemption.Account):
+		return fmt.Errorf("exemption of %s: account %q is not a 12-digit account id", exemption.User, exemption.Account)
+	}
+	return nil
+}
+
+// AccountScoped reports whether some entries only apply to one account, so
+// DefaultAccount has to be known.
+func (register *Register) AccountScoped() bool {
+	if register == nil {
+		return false
+	}
+	for _, exemption := range register.Exemptions {
+		if exemption.Account != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Active reports whether the exemption still applies at now.
+func (exemption Exemption) Active(now time.Time) bool {
+	return now.Before(exemption.Expires)
+}
+
+// ForUser returns the active exemption covering every credential of user in
+// account, or nil. An empty account is DefaultAccount.
+func (register *Register) ForUser(account, user string, now time.Time) *Exemption {
+	return register.find(account, user, "", now)
+}
+
+// ForKey returns the active exemption of one access key of user in account,
+// or nil. Exemptions of the whole user are returned by ForUser instead.
+func (register *Register) ForKey(account, user, accessKeyId string, now time.Time) *Exemption {
+	if accessKeyId == "" {
+		return nil
+	}
+	return register.find(account, user, accessKeyId, now)
+}
+
+func (register *Register) find(account, user, accessKeyId string, now time.Time) *Exemption {
+	if register == nil {
+		return nil
+	}
+	if account == "" {
+		account = register.DefaultAccount
+	}
+	for i := range register.Exemptions {
+		exemption := &register.Exemptions[i]
+		if exemption.User != user || exemption.AccessKeyId != accessKeyId || !exemption.Active(now) {
+			continue
+		}
+		if exemption.Account == "" || exemption.Account == account {
+			return exemption
+		}
+	}
+	return nil
+}
+
+// Check returns the exemptions that expire within the given window of now,
+// including the ones that already expired, soonest first. A negative window
+// returns every exemption.
+func (register *Register) Check(within time.Duration, now time.Time) []Status {
+	if register == nil {
+		return nil
+	}
+
+	var statuses []Status
+	for _, exemption := range register.Exemptions {
+		left := exemption.Expires.Sub(now)
+		if within >= 0 && left > within {
+			continue
+		}
+		statuses = append(statuses, Status{
+			Exemption: exemption,
+			DaysLeft:  int(left.Hours() / 24),
+			Expired:   !exemption.Active(now),
+		})
+	}
+
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return statuses[i].Expires.Before(statuses[j].Expires)
+	})
+	return statuses
+}
@@ -0,0 +1,219 @@
+package exemptions
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRegisterFind(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	register := &Register{
+		Exemptions: []Exemption{
+			{User: "vendor-sync", Reason: "migration", Approver: "security", Expires: now.AddDate(0, 6, 0)},
+			{User: "ci", AccessKeyId: "AKIACI", Reason: "pipeline", Approver: "security", Expires: now.AddDate(0, 1, 0)},
+			{User: "legacy", Reason: "retired", Approver: "security", Expires: now.AddDate(0, -1, 0)},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		user  string
+		keyId string
+		want  bool
+	}{
+		{name: "user entry", user: "vendor-sync", want: true},
+		{name: "user entry does not match one key", user: "vendor-sync", keyId: "AKIAVENDOR"},
+		{name: "key entry", user: "ci", keyId: "AKIACI", want: true},
+		{name: "key entry does not cover the user", user: "ci"},
+		{name: "key entry of another key", user: "ci", keyId: "AKIAOTHER"},
+		{name: "expired entry", user: "legacy"},
+		{name: "unknown user", user: "alice"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got *Exemption
+			if test.keyId == "" {
+				got = register.ForUser("", test.user, now)
+			} else {
+				got = register.ForKey("", test.user, test.keyId, now)
+			}
+			if (got != nil) != test.want {
+				t.Errorf("exempted = %v, want %v", got != nil, test.want)
+			}
+		})
+	}
+
+	var empty *Register
+	if empty.ForUser("", "vendor-sync", now) != nil {
+		t.Error("nil register exempts a user")
+	}
+}
+
+func TestRegisterAccounts(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	expires := now.AddDate(0, 6, 0)
+	register := &Register{
+		Exemptions: []Exemption{
+			{User: "vendor-sync", Reason: "migration", Approver: "security", Expires: expires},
+			{User: "ci", Account: "111111111111", Reason: "pipeline", Approver: "security", Expires: expires},
+			{User: "ci", Account: "222222222222", AccessKeyId: "AKIACI", Reason: "pipeline", Approver: "security", Expires: expires},
+		},
+		DefaultAccount: "111111111111",
+	}
+
+	tests := []struct {
+		name    string
+		account string
+		user    string
+		keyId   string
+		want    bool
+	}{
+		{name: "entry without account, any account", account: "333333333333", user: "vendor-sync", want: true},
+		{name: "entry without account, default account", user: "vendor-sync", want: true},
+		{name: "account entry in its account", account: "111111111111", user: "ci", want: true},
+		{name: "account entry in another account", account: "333333333333", user: "ci"},
+		{name: "account entry in the default account", user: "ci", want: true},
+		{name: "key entry in its account", account: "222222222222", user: "ci", keyId: "AKIACI", want: true},
+		{name: "key entry in another account", account: "111111111111", user: "ci", keyId: "AKIACI"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got *Exemption
+			if test.keyId == "" {
+				got = register.ForUser(test.account, test.user, now)
+			} else {
+				got = register.ForKey(test.account, test.user, test.keyId, now)
+			}
+			if (got != nil) != test.want {
+				t.Errorf("exempted = %v, want %v", got != nil, test.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	expires := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		exemption Exemption
+		wantErr   bool
+	}{
+		{name: "complete", exemption: Exemption{User: "ci", Reason: "pipeline", Approver: "security", Expires: expires}},
+		{name: "no user", exemption: Exemption{Reason: "pipeline", Approver: "security", Expires: expires}, wantErr: true},
+		{name: "no reason", exemption: Exemption{User: "ci", Approver: "security", Expires: expires}, wantErr: true},
+		{name: "no approver", exemption: Exemption{User: "ci", Reason: "pipeline", Expires: expires}, wantErr: true},
+		{name: "no expiry", exemption: Exemption{User: "ci", Reason: "pipeline", Approver: "security"}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.exemption.validate(); (err != nil) != test.wantErr {
+				t.Errorf("validate() = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateAccount(t *testing.T) {
+	tests := []struct {
+		account string
+		wantErr bool
+	}{
+		{account: ""},
+		{account: "123456789012"},
+		{account: "prod", wantErr: true},
+		{account: "12345", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.account, func(t *testing.T) {
+			exemption := Exemption{User: "ci", Account: test.account, Reason: "pipeline", Approver: "security", Expires: time.Now()}
+			if err := exemption.validate(); (err != nil) != test.wantErr {
+				t.Errorf("validate() = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.yaml")
+	invalid := filepath.Join(dir, "invalid.yaml")
+	missing := filepath.Join(dir, "missing.yaml")
+	writeFile(t, valid, "exemptions:\n  - user: ci\n    reason: pipeline\n    approver: security\n    expires: 2026-12-31\n")
+	writeFile(t, invalid, "exemptions:\n  - user: ci\n    expires: 2026-12-31\n")
+
+	tests := []struct {
+		name      string
+		path      string
+		explicit  bool
+		wantCount int
+		wantErr   bool
+	}{
+		{name: "no path", wantCount: 0},
+		{name: "valid file", path: valid, wantCount: 1},
+		{name: "missing default file", path: missing, wantCount: 0},
+		{name: "missing requested file", path: missing, explicit: true, wantErr: true},
+		{name: "entry without reason", path: invalid, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			register, err := Load(test.path, test.explicit)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Load() error = %v, want error %v", err, test.wantErr)
+			}
+			if err == nil && len(register.Exemptions) != test.wantCount {
+				t.Errorf("got %d exemptions, want %d", len(register.Exemptions), test.wantCount)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	register := &Register{
+		Exemptions: []Exemption{
+			{User: "later", Expires: now.AddDate(0, 6, 0)},
+			{User: "soon", Expires: now.AddDate(0, 0, 10)},
+			{User: "expired", Expires: now.AddDate(0, 0, -3)},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		within time.Duration
+		want   []string
+	}{
+		{name: "every exemption", within: -1, want: []string{"expired", "soon", "later"}},
+		{name: "within 30 days", within: 30 * 24 * time.Hour, want: []string{"expired", "soon"}},
+		{name: "expired only", within: 0, want: []string{"expired"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for _, status := range register.Check(test.within, now) {
+				got = append(got, status.User)
+				if status.Expired != (status.User == "expired") {
+					t.Errorf("%s: Expired = %v", status.User, status.Expired)
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("users = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package notify
+
+import (
+	"strings"
+
+	iam "github.com/javiercm1410/gyro/pkg/providers/aws"
+)
+
+// Notifier delivers a rotation summary to an external channel.
+type Notifier interface {
+	Notify(summary Summary) error
+}
+
+// Failure describes a user whose rotation did not complete.
+type Failure struct {
+	UserName string
+	Reason   string
+}
+
+// Summary is the outcome of a rotation run. It never carries secret material:
+// only user names and access key ids are kept.
+type Summary struct {
+	Kind        string
+	Rotated     []string
+	Deactivated []string
+	Deleted     []string
+	Reactivated []string
+	Failures    []Failure
+}
+
+// Summarize builds a Summary from the results returned by RotateAccessKeys,
+// RotateLoginProfiles or RollbackRotation.
+func Summarize(kind string, results []iam.UserData) Summary {
+	summary := Summary{Kind: kind}
+
+	for _, item := range results {
+		switch result := item.(type) {
+		case iam.AccessKeyRotationResult:
+			for _, keyId := range result.DeactivatedKeys {
+				summary.Deactivated = append(summary.Deactivated, result.UserName+" ("+keyId+")")
+			}
+			if result.DeletedKeyId != "" {
+				summary.Deleted = append(summary.Deleted, result.UserName+" ("+result.DeletedKeyId+")")
+			}
+			if result.AccessKeyId != "" {
+				summary.Rotated = append(summary.Rotated, result.UserName)
+			}
+			if result.Error != "" {
+				summary.Failures = append(summary.Failures, Failure{UserName: result.UserName, Reason: result.Error})
+			}
+		case iam.StagedRotationResult:
+			if result.Error != "" {
+				summary.Failures = append(summary.Failures, Failure{UserName: result.UserName, Reason: result.Error})
+				continue
+			}
+			oldKeys := result.UserName + " (" + strings.Join(result.OldKeyIds, ", ") + ")"
+			switch result.Action {
+			case iam.ActionCreateKey:
+				summary.Rotated = append(summary.Rotated, result.UserName)
+			case iam.ActionDeactivateKey:
+				summary.Deactivated = append(summary.Deactivated, oldKeys)
+			case iam.ActionDeleteKey:
+				summary.Deleted = append(summary.Deleted, oldKeys)
+			}
+		case iam.RollbackResult:
+			if result.Error != "" {
+				summary.Failures = append(summary.Failures, Failure{UserName: result.UserName, Reason: result.AccessKeyId + ": " + result.Error})
+				continue
+			}
+			switch result.Action {
+			case iam.ActionReactivateKey:
+				summary.Reactivated = append(summary.Reactivated, result.UserName+" ("+result.AccessKeyId+")")
+			case iam.ActionDeleteKey:
+				summary.Deleted = append(summary.Deleted, result.UserName+" ("+result.AccessKeyId+")")
+			}
+		case iam.LoginProfileRotationResult:
+			if result.Error != "" {
+				summary.Failures = append(summary.Failures, Failure{UserName: result.UserName, Reason: result.Error})
+				continue
+			}
+			switch result.Action {
+			case iam.ActionRequireReset:
+				summary.Deactivated = append(summary.Deactivated, result.UserName+" (password reset required)")
+			case iam.ActionRemoveLoginProfile:
+				summary.Deleted = append(summary.Deleted, result.UserName+" (login profile)")
+			default:
+				summary.Rotated = append(summary.Rotated, result.UserName)
+			}
+		}
+	}
+
+	return summary
+}
@@ -0,0 +1,117 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxSectionText keeps section blocks below Slack's 3000 character limit.
+const maxSectionText = 2900
+
+// SlackNotifier posts rotation summaries to a Slack incoming webhook.
+type SlackNotifier struct {
+	WebhookURL string
+	Client     *http.Client
+}
+
+// NewSlackNotifier returns a notifier for the given incoming webhook URL.
+func NewSlackNotifier(webhookURL string) SlackNotifier {
+	return SlackNotifier{
+		WebhookURL: webhookURL,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackBlock struct {
+	Type   string      `json:"type"`
+	Text   *slackText  `json:"text,omitempty"`
+	Fields []slackText `json:"fields,omitempty"`
+}
+
+type slackMessage struct {
+	Text   string       `json:"text"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+// Notify sends the summary as a Block Kit message.
+func (notifier SlackNotifier) Notify(summary Summary) error {
+	payload, err := json.Marshal(buildSlackMessage(summary))
+	if err != nil {
+		return fmt.Errorf("error marshaling Slack message: %w", err)
+	}
+
+	client := notifier.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Post(notifier.WebhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("error posting to Slack webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("slack webhook returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+func buildSlackMessage(summary Summary) slackMessage {
+	title := fmt.Sprintf("gyro rotated %s", summary.Kind)
+	fallback := fmt.Sprintf("%s: %d rotated, %d deactivated, %d deleted, %d failed",
+		title, len(summary.Rotated), len(summary.Deactivated), len(summary.Deleted), len(summary.Failures))
+
+	blocks := []slackBlock{
+		{Type: "header", Text: &slackText{Type: "plain_text", Text: title}},
+		{Type: "section", Fields: []slackText{
+			{Type: "mrkdwn", Text: fmt.Sprintf("*Rotated*\n%d", len(summary.Rotated))},
+			{Type: "mrkdwn", Text: fmt.Sprintf("*Deactivated*\n%d", len(summary.Deactivated))},
+			{Type: "mrkdwn", Text: fmt.Sprintf("*Deleted*\n%d", len(summary.Deleted))},
+			{Type: "mrkdwn", Text: fmt.Sprintf("*Failed*\n%d", len(summary.Failures))},
+		}},
+	}
+
+	blocks = appendListSection(blocks, "Users rotated", summary.Rotated)
+	blocks = appendListSection(blocks, "Keys deactivated", summary.Deactivated)
+	blocks = appendListSection(blocks, "Keys deleted", summary.Deleted)
+	blocks = appendListSection(blocks, "Keys reactivated", summary.Reactivated)
+
+	failures := make([]string, 0, len(summary.Failures))
+	for _, failure := range summary.Failures {
+		failures = append(failures, fmt.Sprintf("%s: %s", failure.UserName, failure.Reason))
+	}
+	blocks = appendListSection(blocks, "Failures", failures)
+
+	return slackMessage{Text: fallback, Blocks: blocks}
+}
+
+func appendListSection(blocks []slackBlock, title string, items []string) []slackBlock {
+	if len(items) == 0 {
+		return blocks
+	}
+
+	text := fmt.Sprintf("*%s*", title)
+	for i, item := range items {
+		line := "\n• " + item
+		if len(text)+len(line) > maxSectionText {
+			text += fmt.Sprintf("\n…and %d more", len(items)-i)
+			break
+		}
+		text += line
+	}
+
+	return append(blocks, slackBlock{Type: "section", Text: &slackText{Type: "mrkdwn", Text: text}})
+}
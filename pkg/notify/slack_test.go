@@ -0,0 +1,105 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSlackNotifier(t *testing.T) {
+	many := make([]string, 200)
+	for i := range many {
+		many[i] = fmt.Sprintf("user-%03d-with-a-rather-long-name (AKIA%016d)", i, i)
+	}
+
+	tests := []struct {
+		name     string
+		summary  Summary
+		status   int
+		wantErr  string
+		wantText string
+		// wantSections are texts that must appear in some block.
+		wantSections []string
+	}{
+		{
+			name: "rotation summary",
+			summary: Summary{
+				Kind:        "access keys",
+				Rotated:     []string{"alice"},
+				Deactivated: []string{"alice (AKIAOLD)"},
+				Failures:    []Failure{{UserName: "bob", Reason: "throttled"}},
+			},
+			status:       http.StatusOK,
+			wantText:     "gyro rotated access keys: 1 rotated, 1 deactivated, 0 deleted, 1 failed",
+			wantSections: []string{"*Users rotated*\n• alice", "*Keys deactivated*\n• alice (AKIAOLD)", "*Failures*\n• bob: throttled"},
+		},
+		{
+			name:         "long lists are truncated",
+			summary:      Summary{Kind: "access keys", Deleted: many},
+			status:       http.StatusOK,
+			wantText:     "gyro rotated access keys: 0 rotated, 0 deactivated, 200 deleted, 0 failed",
+			wantSections: []string{"more"},
+		},
+		{
+			name:    "webhook error",
+			summary: Summary{Kind: "access keys"},
+			status:  http.StatusForbidden,
+			wantErr: "403 Forbidden: invalid_token",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var received slackMessage
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+					t.Errorf("got %s with Content-Type %q", r.Method, r.Header.Get("Content-Type"))
+				}
+				body, _ := io.ReadAll(r.Body)
+				if err := json.Unmarshal(body, &received); err != nil {
+					t.Errorf("payload is not JSON: %v", err)
+				}
+				w.WriteHeader(test.status)
+				if test.status != http.StatusOK {
+					io.WriteString(w, "invalid_token")
+				}
+			}))
+			defer server.Close()
+
+			err := NewSlackNotifier(server.URL).Notify(test.summary)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("err = %v, want it to contain %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Notify: %v", err)
+			}
+
+			if received.Text != test.wantText {
+				t.Errorf("text = %q, want %q", received.Text, test.wantText)
+			}
+			for _, block := range received.Blocks {
+				if block.Type == "section" && block.Text != nil && len(block.Text.Text) > 3000 {
+					t.Errorf("section of %d characters exceeds the Slack limit", len(block.Text.Text))
+				}
+			}
+			for _, want := range test.wantSections {
+				found := false
+				for _, block := range received.Blocks {
+					if block.Text != nil && strings.Contains(block.Text.Text, want) {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("no block contains %q", want)
+				}
+			}
+		})
+	}
+}
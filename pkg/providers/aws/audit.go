@@ -0,0 +1,113 @@
+package iam
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/charmbracelet/log"
+	"github.com/javiercm1410/gyro/pkg/audit"
+)
+
+// WithAudit returns a copy of the wrapper whose mutating IAM calls are
+// recorded in auditLog on behalf of caller, the ARN gyro runs as. Read calls
+// are not recorded.
+func (wrapper UserWrapper) WithAudit(auditLog *audit.Log, caller string) UserWrapper {
+	if wrapper.IamClient == nil || auditLog == nil {
+		return wrapper
+	}
+	wrapper.IamClient = &auditedClient{IamAPI: wrapper.IamClient, log: auditLog, caller: caller, accountId: wrapper.Account.AccountId}
+	return wrapper
+}
+
+// WithLastRotated sets LastRotated on access key data from the time gyro last
+// created an access key for each user, according to the audit records.
+// Password resets, deactivations and rollbacks do not count.
+func WithLastRotated(data []UserData, records []audit.Record, loc *time.Location) []UserData {
+	lastChanged := audit.LastChanged(records, ActionCreateKey)
+	for i, item := range data {
+		user, ok := item.(UserAccessKeyData)
+		if !ok {
+			continue
+		}
+		if changed, found := lastChanged[audit.UserKey(user.AccountId, user.UserName)]; found {
+			changed = changed.In(loc)
+			user.LastRotated = &changed
+			data[i] = user
+		}
+	}
+	return data
+}
+
+// auditedClient is an IamAPI that writes an audit record after every call
+// that changes credentials. Secrets, such as new passwords, are never
+// recorded.
+type auditedClient struct {
+	IamAPI
+	log       *audit.Log
+	caller    string
+	accountId string
+}
+
+func (c *auditedClient) record(action, userName, accessKeyId, detail string, err error) {
+	record := audit.Record{
+		Caller:      c.caller,
+		AccountId:   c.accountId,
+		Action:      action,
+		UserName:    userName,
+		AccessKeyId: accessKeyId,
+		Detail:      detail,
+		Outcome:     audit.OutcomeSuccess,
+	}
+	if err != nil {
+		record.Outcome = audit.OutcomeFailure
+		record.Error = err.Error()
+	}
+	if appendErr := c.log.Append(record); appendErr != nil {
+		log.Errorf("Failed to audit %s of user %s: %v", action, userName, appendErr)
+	}
+}
+
+func (c *auditedClient) CreateAccessKey(ctx context.Context, params *iam.CreateAccessKeyInput, optFns ...func(*iam.Options)) (*iam.CreateAccessKeyOutput, error) {
+	output, err := c.IamAPI.CreateAccessKey(ctx, params, optFns...)
+	keyId := ""
+	if err == nil && output.AccessKey != nil {
+		keyId = aws.ToString(output.AccessKey.AccessKeyId)
+	}
+	c.record(ActionCreateKey, aws.ToString(params.UserName), keyId, "", err)
+	return output, err
+}
+
+func (c *auditedClient) UpdateAccessKey(ctx context.Context, params *iam.UpdateAccessKeyInput, optFns ...func(*iam.Options)) (*iam.UpdateAccessKeyOutput, error) {
+	output, err := c.IamAPI.UpdateAccessKey(ctx, params, optFns...)
+	action := ActionDeactivateKey
+	if params.Status == types.StatusTypeActive {
+		action = ActionReactivateKey
+	}
+	c.record(action, aws.ToString(params.UserName), aws.ToString(params.AccessKeyId), "status "+string(params.Status), err)
+	return output, err
+}
+
+func (c *auditedClient) DeleteAccessKey(ctx context.Context, params *iam.DeleteAccessKeyInput, optFns ...func(*iam.Options)) (*iam.DeleteAccessKeyOutput, error) {
+	output, err := c.IamAPI.DeleteAccessKey(ctx, params, optFns...)
+	c.record(ActionDeleteKey, aws.ToString(params.UserName), aws.ToString(params.AccessKeyId), "", err)
+	return output, err
+}
+
+func (c *auditedClient) UpdateLoginProfile(ctx context.Context, params *iam.UpdateLoginProfileInput, optFns ...func(*iam.Options)) (*iam.UpdateLoginProfileOutput, error) {
+	output, err := c.IamAPI.UpdateLoginProfile(ctx, params, optFns...)
+	action, detail := ActionRequireReset, "password reset required"
+	if params.Password != nil {
+		action, detail = ActionResetPassword, "new temporary password, reset required"
+	}
+	c.record(action, aws.ToString(params.UserName), "", detail, err)
+	return output, err
+}
+
+func (c *auditedClient) DeleteLoginProfile(ctx context.Context, params *iam.DeleteLoginProfileInput, optFns ...func(*iam.Options)) (*iam.DeleteLoginProfileOutput, error) {
+	output, err := c.IamAPI.DeleteLoginProfile(ctx, params, optFns...)
+	c.record(ActionRemoveLoginProfile, aws.ToString(params.UserName), "", "", err)
+	return output, err
+}
@@ -0,0 +1,131 @@
+package iam
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/javiercm1410/gyro/pkg/audit"
+	"github.com/javiercm1410/gyro/pkg/providers/aws/fakeiam"
+)
+
+func TestAuditedClientRecordsActions(t *testing.T) {
+	wrapper, client := fakeWrapper(t, fakeiam.Fixture{
+		Users: []fakeiam.FixtureUser{{
+			UserName:     "alice",
+			LoginProfile: &fakeiam.FixtureLoginProfile{CreateDate: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+			AccessKeys:   []fakeiam.FixtureAccessKey{fixtureKey("AKIAOLD", "2022-01-01")},
+		}},
+	})
+	client.FailOn("DeleteAccessKey", errors.New("throttled"))
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditLog, err := audit.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	api := wrapper.WithAudit(auditLog, "arn:aws:iam::000000000000:user/ops").IamClient
+	user := aws.String("alice")
+	ctx := context.TODO()
+
+	api.CreateAccessKey(ctx, &iam.CreateAccessKeyInput{UserName: user})
+	api.UpdateAccessKey(ctx, &iam.UpdateAccessKeyInput{UserName: user, AccessKeyId: aws.String("AKIAOLD"), Status: types.StatusTypeInactive})
+	api.UpdateAccessKey(ctx, &iam.UpdateAccessKeyInput{UserName: user, AccessKeyId: aws.String("AKIAOLD"), Status: types.StatusTypeActive})
+	api.DeleteAccessKey(ctx, &iam.DeleteAccessKeyInput{UserName: user, AccessKeyId: aws.String("AKIAOLD")})
+	api.UpdateLoginProfile(ctx, &iam.UpdateLoginProfileInput{UserName: user, Password: aws.String("s3cret-Passw0rd"), PasswordResetRequired: aws.Bool(true)})
+	api.UpdateLoginProfile(ctx, &iam.UpdateLoginProfileInput{UserName: user, PasswordResetRequired: aws.Bool(true)})
+	api.DeleteLoginProfile(ctx, &iam.DeleteLoginProfileInput{UserName: user})
+	api.ListAccessKeys(ctx, &iam.ListAccessKeysInput{UserName: user})
+	auditLog.Close()
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if bytes.Contains(raw, []byte("s3cret-Passw0rd")) || bytes.Contains(raw, []byte("secret-AKIAFAKE")) {
+		t.Error("audit log contains a secret")
+	}
+
+	var got []string
+	for _, line := range bytes.Split(bytes.TrimSpace(raw), []byte("\n")) {
+		var record audit.Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("parse record: %v", err)
+		}
+		got = append(got, record.Action+" "+record.Outcome)
+	}
+	want := []string{
+		"create-key success",
+		"deactivate-key success",
+		"reactivate-key success",
+		"delete-key failure",
+		"reset-password success",
+		"require-password-reset success",
+		"remove-login-profile success",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %q, want %q", got, want)
+	}
+}
+
+func TestWithLastRotated(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 1, d, 0, 0, 0, 0, time.UTC) }
+	record := func(action string, d int, outcome string) audit.Record {
+		return audit.Record{Time: day(d), Action: action, UserName: "alice", Outcome: outcome}
+	}
+
+	tests := []struct {
+		name    string
+		records []audit.Record
+		// want is zero when LastRotated should stay unset.
+		want time.Time
+	}{
+		{name: "no records"},
+		{
+			name:    "key creation",
+			records: []audit.Record{record(ActionCreateKey, 2, audit.OutcomeSuccess)},
+			want:    day(2),
+		},
+		{
+			name: "later resets, deactivations and rollbacks do not count",
+			records: []audit.Record{
+				record(ActionCreateKey, 2, audit.OutcomeSuccess),
+				record(ActionDeactivateKey, 3, audit.OutcomeSuccess),
+				record(ActionReactivateKey, 4, audit.OutcomeSuccess),
+				record(ActionResetPassword, 5, audit.OutcomeSuccess),
+				record(ActionDeleteKey, 6, audit.OutcomeSuccess),
+			},
+			want: day(2),
+		},
+		{
+			name: "failed creation does not count",
+			records: []audit.Record{
+				record(ActionCreateKey, 2, audit.OutcomeSuccess),
+				record(ActionCreateKey, 3, audit.OutcomeFailure),
+			},
+			want: day(2),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := WithLastRotated([]UserData{UserAccessKeyData{UserName: "alice"}}, test.records, time.UTC)
+			var got time.Time
+			if lastRotated := data[0].(UserAccessKeyData).LastRotated; lastRotated != nil {
+				got = *lastRotated
+			}
+			if !got.Equal(test.want) {
+				t.Errorf("LastRotated = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -2,44 +2,260 @@ package iam
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/charmbracelet/log"
+	"github.com/javiercm1410/gyro/pkg/exemptions"
+	"github.com/javiercm1410/gyro/pkg/providers/aws/fakeiam"
 )
 
+// FakeFixtureEnv names the environment variable that switches gyro to the
+// in-memory fake IAM backend.
+const FakeFixtureEnv = "GYRO_FAKE_IAM_FIXTURE"
+
+// IamAPI is the subset of the IAM client used by gyro. *iam.Client satisfies it,
+// and so does the in-memory fake in the fakeiam package.
+type IamAPI interface {
+	ListUsers(ctx context.Context, params *iam.ListUsersInput, optFns ...func(*iam.Options)) (*iam.ListUsersOutput, error)
+	GetUser(ctx context.Context, params *iam.GetUserInput, optFns ...func(*iam.Options)) (*iam.GetUserOutput, error)
+	ListAccessKeys(ctx context.Context, params *iam.ListAccessKeysInput, optFns ...func(*iam.Options)) (*iam.ListAccessKeysOutput, error)
+	GetAccessKeyLastUsed(ctx context.Context, params *iam.GetAccessKeyLastUsedInput, optFns ...func(*iam.Options)) (*iam.GetAccessKeyLastUsedOutput, error)
+	CreateAccessKey(ctx context.Context, params *iam.CreateAccessKeyInput, optFns ...func(*iam.Options)) (*iam.CreateAccessKeyOutput, error)
+	UpdateAccessKey(ctx context.Context, params *iam.UpdateAccessKeyInput, optFns ...func(*iam.Options)) (*iam.UpdateAccessKeyOutput, error)
+	DeleteAccessKey(ctx context.Context, params *iam.DeleteAccessKeyInput, optFns ...func(*iam.Options)) (*iam.DeleteAccessKeyOutput, error)
+	GetLoginProfile(ctx context.Context, params *iam.GetLoginProfileInput, optFns ...func(*iam.Options)) (*iam.GetLoginProfileOutput, error)
+	UpdateLoginProfile(ctx context.Context, params *iam.UpdateLoginProfileInput, optFns ...func(*iam.Options)) (*iam.UpdateLoginProfileOutput, error)
+	DeleteLoginProfile(ctx context.Context, params *iam.DeleteLoginProfileInput, optFns ...func(*iam.Options)) (*iam.DeleteLoginProfileOutput, error)
+	GenerateCredentialReport(ctx context.Context, params *iam.GenerateCredentialReportInput, optFns ...func(*iam.Options)) (*iam.GenerateCredentialReportOutput, error)
+	GetCredentialReport(ctx context.Context, params *iam.GetCredentialReportInput, optFns ...func(*iam.Options)) (*iam.GetCredentialReportOutput, error)
+	GetAccountPasswordPolicy(ctx context.Context, params *iam.GetAccountPasswordPolicyInput, optFns ...func(*iam.Options)) (*iam.GetAccountPasswordPolicyOutput, error)
+	GetGroup(ctx context.Context, params *iam.GetGroupInput, optFns ...func(*iam.Options)) (*iam.GetGroupOutput, error)
+	ListUserTags(ctx context.Context, params *iam.ListUserTagsInput, optFns ...func(*iam.Options)) (*iam.ListUserTagsOutput, error)
+	ListAccountAliases(ctx context.Context, params *iam.ListAccountAliasesInput, optFns ...func(*iam.Options)) (*iam.ListAccountAliasesOutput, error)
+}
+
 type UserWrapper struct {
-	IamClient *iam.Client
+	IamClient IamAPI
+	StsClient StsAPI
+	// AccessKeyId is the access key gyro itself is authenticated with, empty
+	// when the credentials are not key based.
+	AccessKeyId string
+	// KeyStsClient returns an STS client authenticated with the given key
+	// pair, used to verify new access keys.
+	KeyStsClient func(accessKeyId, secretAccessKey string) StsAPI
+	// Account is the organization account the clients act in, see
+	// DeclareOrgConfig. It is empty for the account of the default
+	// credentials.
+	Account Account
+	// sdkConfig is the configuration the clients were built from, empty for
+	// the simulated account.
+	sdkConfig aws.Config
 }
 
 type UserData interface {
 }
 
 type GetWrapperInputs struct {
+	// MaxUsers stops listing after that many users; 0 lists every user.
 	MaxUsers int32
 	TimeZone string
 	UserName string
+	Exclude  []string
+	Filter   UserFilter
 	Client   UserWrapper
 	Age      int
 	Expired  bool
+	// CredentialReport builds the data from the IAM credential report in a
+	// single call instead of querying every user.
+	CredentialReport bool
+	// ReportFile reads a saved credential report instead of calling AWS.
+	ReportFile string
+	// Concurrency caps the number of users queried in parallel.
+	Concurrency int
+	// Exemptions keeps the listed users and keys out of rotation.
+	Exemptions *exemptions.Register
+	// TagPolicy reads the gyro:* tags of every user as its policy, see
+	// userPolicy.
+	TagPolicy bool
+	// Accounts, when set, are listed instead of the account of Client, at
+	// most AccountConcurrency of them in parallel. See DeclareOrgConfig.
+	Accounts           []UserWrapper
+	AccountConcurrency int
 }
 
 type RotateWrapperInputs struct {
 	GetWrapperInputs
-	DryRun           bool
-	Notify           bool
-	ExpireOnly       bool
-	SkipConfirmation bool
-	SkipCurrentUser  bool
+	DryRun     bool
+	Notify     bool
+	ExpireOnly bool
+	// RemoveLoginProfile deletes stale console passwords in expire-only mode
+	// instead of forcing a reset.
+	RemoveLoginProfile bool
+	SkipConfirmation   bool
+	SkipCurrentUser    bool
+	// AllowCurrentKey lets a rotation deactivate or delete the access key
+	// gyro is authenticated with, which is refused by default.
+	AllowCurrentKey bool
+	// GracePeriod and DeleteAfter drive staged rotations, see
+	// AdvanceStagedRotation.
+	GracePeriod time.Duration
+	DeleteAfter time.Duration
+	// VerifyTimeout, when set, makes key rotations check that a new access
+	// key authenticates with STS before retiring the old ones, see
+	// VerifyAccessKey.
+	VerifyTimeout time.Duration
+	// StateDir holds the state of every rotation run, see ResumeRotation.
+	// Empty disables it.
+	StateDir string
+	// Password controls the temporary passwords set on login profiles.
+	Password PasswordOptions
+}
+
+// Rotation actions, also recorded in the audit log. ActionRequireReset forces
+// a password change without issuing a new password, ActionReactivateKey sets
+// a deactivated key back to Active.
+const (
+	ActionDeactivateKey      = "deactivate-key"
+	ActionReactivateKey      = "reactivate-key"
+	ActionDeleteKey          = "delete-key"
+	ActionCreateKey          = "create-key"
+	ActionVerifyKey          = "verify-key"
+	ActionResetPassword      = "reset-password"
+	ActionRequireReset       = "require-password-reset"
+	ActionRemoveLoginProfile = "remove-login-profile"
+)
+
+// RotationPlanEntry is one change a rotation would make. Dry runs return these
+// instead of touching IAM.
+type RotationPlanEntry struct {
+	Account
+	UserName    string
+	Action      string
+	AccessKeyId string
+	Detail      string
+}
+
+// ConfigOptions selects the credentials and the endpoint gyro uses. The zero
+// value is the default credential chain.
+type ConfigOptions struct {
+	// Profile is a profile of the shared AWS config and credentials files.
+	Profile string
+	// RoleArn is assumed with the credentials of Profile, passing ExternalId
+	// when set. With MfaSerial the MFA token code is read from the terminal.
+	RoleArn    string
+	ExternalId string
+	MfaSerial  string
+	Region     string
+	// EndpointURL sends every AWS call to that endpoint instead of the AWS
+	// one, e.g. to LocalStack.
+	EndpointURL string
 }
 
-// DeclareConfig initializes the IAM client using the default AWS configuration.
-func DeclareConfig() *iam.Client {
-	sdkConfig, err := config.LoadDefaultConfig(context.TODO())
+// roleSessionName names the sessions of the roles gyro assumes, so they can
+// be told apart in CloudTrail.
+const roleSessionName = "gyro"
+
+// DeclareConfig initializes the IAM and STS clients from the AWS
+// configuration selected by options. When GYRO_FAKE_IAM_FIXTURE points to a
+// fixture file, an in-memory fake account loaded from it is used instead.
+// Missing or unusable credentials are reported as ErrAuth.
+func DeclareConfig(options ConfigOptions) (UserWrapper, error) {
+	if fixture := os.Getenv(FakeFixtureEnv); fixture != "" {
+		client, err := fakeiam.LoadFixture(fixture)
+		if err != nil {
+			return UserWrapper{}, fmt.Errorf("couldn't load fake IAM fixture: %w", err)
+		}
+		log.Warnf("Using simulated IAM account from %s", fixture)
+		return fakeUserWrapper(client), nil
+	}
+
+	sdkConfig, err := loadSDKConfig(options)
+	if err != nil {
+		return UserWrapper{}, err
+	}
+	credentials, err := sdkConfig.Credentials.Retrieve(context.TODO())
 	if err != nil {
-		log.Warn("Couldn't load default configuration. Ensure AWS account setup.")
-		log.Error(err)
-		return nil
+		return UserWrapper{}, fmt.Errorf("%w: couldn't retrieve AWS credentials: %w", ErrAuth, err)
+	}
+
+	client := newUserWrapper(sdkConfig)
+	client.AccessKeyId = credentials.AccessKeyID
+	return client, nil
+}
+
+// loadSDKConfig loads the AWS configuration selected by options.
+func loadSDKConfig(options ConfigOptions) (aws.Config, error) {
+	var loadOptions []func(*config.LoadOptions) error
+	if options.Profile != "" {
+		loadOptions = append(loadOptions, config.WithSharedConfigProfile(options.Profile))
+	}
+	if options.Region != "" {
+		loadOptions = append(loadOptions, config.WithRegion(options.Region))
+	}
+	if options.EndpointURL != "" {
+		loadOptions = append(loadOptions, config.WithBaseEndpoint(options.EndpointURL))
+	}
+
+	sdkConfig, err := config.LoadDefaultConfig(context.TODO(), loadOptions...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("%w: couldn't load AWS configuration: %w", ErrAuth, err)
+	}
+
+	if sdkConfig.Credentials == nil {
+		return aws.Config{}, fmt.Errorf("%w: no AWS credentials configured", ErrAuth)
+	}
+
+	if options.RoleArn != "" {
+		sdkConfig.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(sdkConfig), options.RoleArn, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = roleSessionName
+			if options.ExternalId != "" {
+				o.ExternalID = aws.String(options.ExternalId)
+			}
+			if options.MfaSerial != "" {
+				o.SerialNumber = aws.String(options.MfaSerial)
+				o.TokenProvider = stscreds.StdinTokenProvider
+			}
+		}))
+	}
+	return sdkConfig, nil
+}
+
+// newUserWrapper builds the clients of one account from its configuration.
+// IAM retries are handled by WithRateLimit, so the SDK's own retryer is
+// disabled on the IAM client to keep attempts and backoff in one place; STS
+// keeps the default retryer.
+func newUserWrapper(sdkConfig aws.Config) UserWrapper {
+	return UserWrapper{
+		sdkConfig: sdkConfig,
+		IamClient: iam.NewFromConfig(sdkConfig, func(options *iam.Options) {
+			options.Retryer = aws.NopRetryer{}
+		}),
+		StsClient: sts.NewFromConfig(sdkConfig),
+		KeyStsClient: func(accessKeyId, secretAccessKey string) StsAPI {
+			return sts.NewFromConfig(sdkConfig, func(options *sts.Options) {
+				options.Credentials = aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+					return aws.Credentials{AccessKeyID: accessKeyId, SecretAccessKey: secretAccessKey, Source: "gyro"}, nil
+				})
+			})
+		},
+	}
+}
+
+// fakeUserWrapper wraps a simulated account.
+func fakeUserWrapper(client *fakeiam.Client) UserWrapper {
+	return UserWrapper{
+		IamClient:   client,
+		StsClient:   client,
+		AccessKeyId: client.CallerAccessKeyId(),
+		KeyStsClient: func(accessKeyId, secretAccessKey string) StsAPI {
+			return client.AsKey(accessKeyId, secretAccessKey)
+		},
 	}
-	return iam.NewFromConfig(sdkConfig)
 }
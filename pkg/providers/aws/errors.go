@@ -0,0 +1,128 @@
+package iam
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/smithy-go"
+)
+
+// Error kinds returned by the provider. Errors wrap one of them, so callers
+// test with errors.Is while the message keeps the AWS detail.
+var (
+	// ErrAuth means gyro has no usable AWS credentials or is not allowed to
+	// make the call.
+	ErrAuth = errors.New("authentication failed")
+	// ErrUserNotFound means the requested IAM user does not exist.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrNoLoginProfile means the IAM user has no console password.
+	ErrNoLoginProfile = errors.New("user has no login profile")
+	// ErrThrottled means IAM kept throttling after all retries.
+	ErrThrottled = errors.New("throttled by AWS")
+	// ErrPartialFailure means some users could not be read or rotated; the
+	// others were processed.
+	ErrPartialFailure = errors.New("partial failure")
+)
+
+// authErrorCodes are the AWS error codes for missing, invalid or
+// insufficient credentials.
+var authErrorCodes = map[string]bool{
+	"AccessDenied":                true,
+	"AccessDeniedException":       true,
+	"ExpiredToken":                true,
+	"ExpiredTokenException":       true,
+	"IncompleteSignature":         true,
+	"InvalidAccessKeyId":          true,
+	"InvalidClientTokenId":        true,
+	"InvalidIdentityToken":        true,
+	"MissingAuthenticationToken":  true,
+	"RequestExpired":              true,
+	"SignatureDoesNotMatch":       true,
+	"UnrecognizedClientException": true,
+}
+
+// isAccessDenied reports whether AWS refused the call for lack of
+// permissions, as opposed to missing or invalid credentials.
+func isAccessDenied(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.ErrorCode() == "AccessDenied" || apiErr.ErrorCode() == "AccessDeniedException"
+}
+
+// classifyError wraps err with the matching error kind. notFound is the kind
+// used for NoSuchEntity, which depends on what was looked up; nil leaves it
+// unclassified.
+func classifyError(err error, notFound error) error {
+	if err == nil {
+		return nil
+	}
+
+	var noSuchEntity *types.NoSuchEntityException
+	if notFound != nil && errors.As(err, &noSuchEntity) {
+		return fmt.Errorf("%w: %w", notFound, err)
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && authErrorCodes[apiErr.ErrorCode()] {
+		return fmt.Errorf("%w: %w", ErrAuth, err)
+	}
+
+	if retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err) == aws.TrueTernary {
+		return fmt.Errorf("%w: %w", ErrThrottled, err)
+	}
+
+	return err
+}
+
+// PartialFailure reports how many of the processed users failed. It wraps
+// ErrPartialFailure.
+type PartialFailure struct {
+	Failed int
+	Total  int
+}
+
+func (e *PartialFailure) Error() string {
+	return fmt.Sprintf("%d of %d users failed", e.Failed, e.Total)
+}
+
+func (e *PartialFailure) Unwrap() error {
+	return ErrPartialFailure
+}
+
+// CheckResults returns a *PartialFailure when any listing or rotation result
+// carries an error, and nil otherwise.
+func CheckResults(results []UserData) error {
+	failed := 0
+	for _, item := range results {
+		if resultError(item) != "" {
+			failed++
+		}
+	}
+	if failed == 0 {
+		return nil
+	}
+	return &PartialFailure{Failed: failed, Total: len(results)}
+}
+
+func resultError(item UserData) string {
+	switch result := item.(type) {
+	case UserAccessKeyData:
+		return result.Error
+	case UserLoginData:
+		return result.Error
+	case AccessKeyRotationResult:
+		return result.Error
+	case LoginProfileRotationResult:
+		return result.Error
+	case StagedRotationResult:
+		return result.Error
+	case RollbackResult:
+		return result.Error
+	}
+	return ""
+}
@@ -0,0 +1,105 @@
+package iam
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/javiercm1410/gyro/pkg/providers/aws/fakeiam"
+)
+
+// fakeWrapper returns a client of a simulated account built from fixture.
+func fakeWrapper(t *testing.T, fixture fakeiam.Fixture) (UserWrapper, *fakeiam.Client) {
+	t.Helper()
+	client, err := fakeiam.FromFixture(fixture)
+	if err != nil {
+		t.Fatalf("FromFixture: %v", err)
+	}
+	return fakeUserWrapper(client), client
+}
+
+// writeFixture saves fixture to a temporary file and points
+// GYRO_FAKE_IAM_FIXTURE at it.
+func writeFixture(t *testing.T, fixture fakeiam.Fixture) string {
+	t.Helper()
+	raw, err := json.Marshal(fixture)
+	if err != nil {
+		t.Fatalf("marshal fixture: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	if err := os.WriteFile(path, raw, 0o600); err != nil {
+		t.Fatalf("write fixture: %v", err)
+	}
+	t.Setenv(FakeFixtureEnv, path)
+	return path
+}
+
+// fixtureKey is an active access key created at the given date.
+func fixtureKey(id string, created string) fakeiam.FixtureAccessKey {
+	createDate, err := time.Parse(time.DateOnly, created)
+	if err != nil {
+		panic(err)
+	}
+	return fakeiam.FixtureAccessKey{AccessKeyId: id, Status: "Active", CreateDate: createDate}
+}
+
+func TestDeclareConfigLoadsFixture(t *testing.T) {
+	writeFixture(t, fakeiam.Fixture{Users: []fakeiam.FixtureUser{
+		{UserName: "alice", AccessKeys: []fakeiam.FixtureAccessKey{fixtureKey("AKIAOLD", "2022-01-01")}},
+	}})
+
+	wrapper, err := DeclareConfig(ConfigOptions{})
+	if err != nil {
+		t.Fatalf("DeclareConfig: %v", err)
+	}
+	client, ok := wrapper.IamClient.(*fakeiam.Client)
+	if !ok {
+		t.Fatalf("DeclareConfig did not return the fake client")
+	}
+	if keys := client.AccessKeys("alice"); len(keys) != 1 || keys[0].AccessKeyId != "AKIAOLD" {
+		t.Errorf("keys of alice = %+v, want AKIAOLD from the fixture", keys)
+	}
+}
+
+func TestListAccessKeysFromFake(t *testing.T) {
+	today := time.Now().Format(time.DateOnly)
+	wrapper, _ := fakeWrapper(t, fakeiam.Fixture{
+		Users: []fakeiam.FixtureUser{
+			{UserName: "alice", AccessKeys: []fakeiam.FixtureAccessKey{fixtureKey("AKIAOLD", "2022-01-01"), fixtureKey("AKIANEW", today)}},
+			{UserName: "bob", AccessKeys: []fakeiam.FixtureAccessKey{fixtureKey("AKIABOB", today)}},
+		},
+	})
+
+	tests := []struct {
+		name        string
+		user        string
+		expiredOnly bool
+		wantKeys    int
+		wantExpired int
+	}{
+		{name: "every key", user: "alice", wantKeys: 2, wantExpired: 1},
+		{name: "expired only keeps a user with an expired key", user: "alice", expiredOnly: true, wantKeys: 2, wantExpired: 1},
+		{name: "fresh keys", user: "bob", wantKeys: 1},
+		{name: "expired only drops a user without expired keys", user: "bob", expiredOnly: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := wrapper.ListAccessKeys(test.user, "UTC", test.expiredOnly, 90)
+			if err != nil {
+				t.Fatalf("ListAccessKeys: %v", err)
+			}
+			expired := 0
+			for _, key := range data.Keys {
+				if key.IsExpired {
+					expired++
+				}
+			}
+			if len(data.Keys) != test.wantKeys || expired != test.wantExpired {
+				t.Errorf("got %d keys, %d expired, want %d, %d", len(data.Keys), expired, test.wantKeys, test.wantExpired)
+			}
+		})
+	}
+}
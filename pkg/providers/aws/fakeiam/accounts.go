@@ -0,0 +1,62 @@
+package fakeiam
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+// Account is one account of a simulated organization.
+type Account struct {
+	Id     string
+	Name   string
+	Client *Client
+}
+
+// SetAccount sets the id of the simulated account and its IAM alias, empty
+// for none.
+func (c *Client) SetAccount(accountId, alias string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.accountId = accountId
+	c.accountAlias = alias
+}
+
+// ListAccountAliases implements the IAM ListAccountAliases call.
+func (c *Client) ListAccountAliases(_ context.Context, _ *iam.ListAccountAliasesInput, _ ...func(*iam.Options)) (*iam.ListAccountAliasesOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("ListAccountAliases"); err != nil {
+		return nil, err
+	}
+
+	output := &iam.ListAccountAliasesOutput{}
+	if c.accountAlias != "" {
+		output.AccountAliases = []string{c.accountAlias}
+	}
+	return output, nil
+}
+
+// LoadAccounts builds a simulated organization from the accounts of a JSON
+// fixture file. Every account starts from the users of the fixture.
+func LoadAccounts(path string) ([]Account, error) {
+	fixture, err := readFixture(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(fixture.Accounts) == 0 {
+		return nil, fmt.Errorf("fixture %s defines no accounts", path)
+	}
+
+	accounts := make([]Account, 0, len(fixture.Accounts))
+	for _, account := range fixture.Accounts {
+		client, err := FromFixture(fixture)
+		if err != nil {
+			return nil, err
+		}
+		client.SetAccount(account.Id, account.Alias)
+		accounts = append(accounts, Account{Id: account.Id, Name: account.Name, Client: client})
+	}
+	return accounts, nil
+}
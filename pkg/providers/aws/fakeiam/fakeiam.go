@@ -0,0 +1,661 @@
+// Package fakeiam provides an in-memory IAM backend that implements the calls
+// gyro makes against AWS, including STS GetCallerIdentity. It keeps users,
+// login profiles, access keys and last-used data in memory, pages results
+// like IAM does and lets callers inject errors per operation.
+package fakeiam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// DefaultPageSize matches the default MaxItems used by IAM list calls.
+const DefaultPageSize = 100
+
+// FakeAccountId is the account simulated principals belong to, unless the
+// client was built for another account of a fixture, see LoadAccounts.
+const FakeAccountId = "000000000000"
+
+// maxKeysPerUser is the IAM quota of access keys per user.
+const maxKeysPerUser = 2
+
+// User describes a simulated IAM user.
+type User struct {
+	UserName         string
+	Path             string
+	CreateDate       time.Time
+	PasswordLastUsed *time.Time
+	// Tags are returned by ListUserTags and Groups by GetGroup.
+	Tags   map[string]string
+	Groups []string
+}
+
+// AccessKey describes a simulated access key.
+type AccessKey struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	Status          types.StatusType
+	CreateDate      time.Time
+	LastUsedDate    *time.Time
+	LastUsedService string
+	LastUsedRegion  string
+}
+
+// LoginProfile describes a simulated console password.
+type LoginProfile struct {
+	Password              string
+	CreateDate            time.Time
+	PasswordResetRequired bool
+}
+
+type userRecord struct {
+	user         User
+	loginProfile *LoginProfile
+	keys         []*AccessKey
+	// passwords holds previous passwords, newest last, for the reuse rule.
+	passwords []string
+}
+
+// Client is an in-memory IAM backend. The zero value is not usable, use New.
+type Client struct {
+	mu       sync.Mutex
+	users    map[string]*userRecord
+	errors   map[string][]error
+	calls    map[string]int
+	sequence int
+
+	callerUserName    string
+	callerAccessKeyId string
+
+	accountId    string
+	accountAlias string
+
+	passwordPolicy *types.PasswordPolicy
+	groups         map[string]bool
+
+	// PageSize caps the number of items returned per list call when the
+	// request does not set a lower MaxItems.
+	PageSize int32
+	// Now returns the current time; it defaults to time.Now.
+	Now func() time.Time
+	// KeyPropagationDelay is how long a new access key is rejected by STS,
+	// simulating IAM's eventual consistency.
+	KeyPropagationDelay time.Duration
+}
+
+// New returns an empty fake IAM account.
+func New() *Client {
+	return &Client{
+		users:     map[string]*userRecord{},
+		groups:    map[string]bool{},
+		errors:    map[string][]error{},
+		calls:     map[string]int{},
+		PageSize:  DefaultPageSize,
+		Now:       time.Now,
+		accountId: FakeAccountId,
+	}
+}
+
+// AddUser registers a user. Adding an existing user replaces its metadata but
+// keeps its credentials.
+func (c *Client) AddUser(user User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if user.Path == "" {
+		user.Path = "/"
+	}
+	if user.CreateDate.IsZero() {
+		user.CreateDate = c.Now()
+	}
+	for _, group := range user.Groups {
+		c.groups[group] = true
+	}
+
+	if record, ok := c.users[user.UserName]; ok {
+		record.user = user
+		return
+	}
+	c.users[user.UserName] = &userRecord{user: user}
+}
+
+// AddLoginProfile gives an existing user a console password.
+func (c *Client) AddLoginProfile(userName string, profile LoginProfile) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	record, err := c.lookup(userName)
+	if err != nil {
+		return err
+	}
+	if profile.CreateDate.IsZero() {
+		profile.CreateDate = c.Now()
+	}
+	record.loginProfile = &profile
+	return nil
+}
+
+// AddAccessKey attaches an access key to an existing user. Missing ids,
+// secrets, status and dates are filled in.
+func (c *Client) AddAccessKey(userName string, key AccessKey) (AccessKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	record, err := c.lookup(userName)
+	if err != nil {
+		return AccessKey{}, err
+	}
+	if key.AccessKeyId == "" {
+		key.AccessKeyId = c.nextKeyId()
+	}
+	if key.SecretAccessKey == "" {
+		key.SecretAccessKey = "secret-" + key.AccessKeyId
+	}
+	if key.Status == "" {
+		key.Status = types.StatusTypeActive
+	}
+	if key.CreateDate.IsZero() {
+		key.CreateDate = c.Now()
+	}
+	record.keys = append(record.keys, &key)
+	return key, nil
+}
+
+// SetLastUsed records usage for an access key.
+func (c *Client) SetLastUsed(accessKeyId string, when time.Time, service, region string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key, _ := c.findKey(accessKeyId)
+	if key == nil {
+		return noSuchEntity("The Access Key with id %s cannot be found.", accessKeyId)
+	}
+	key.LastUsedDate = aws.Time(when)
+	key.LastUsedService = service
+	key.LastUsedRegion = region
+	return nil
+}
+
+// FailOn makes the next calls to operation (for example "ListAccessKeys")
+// return the given errors, one per call, before behaving normally again.
+func (c *Client) FailOn(operation string, errs ...error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errors[operation] = append(c.errors[operation], errs...)
+}
+
+// Calls reports how many times operation has been invoked.
+func (c *Client) Calls(operation string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls[operation]
+}
+
+// AccessKeys returns a snapshot of the keys held by a user.
+func (c *Client) AccessKeys(userName string) []AccessKey {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	record, ok := c.users[userName]
+	if !ok {
+		return nil
+	}
+	keys := make([]AccessKey, 0, len(record.keys))
+	for _, key := range record.keys {
+		keys = append(keys, *key)
+	}
+	return keys
+}
+
+// LoginProfileOf returns a snapshot of a user's login profile, if any.
+func (c *Client) LoginProfileOf(userName string) (LoginProfile, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	record, ok := c.users[userName]
+	if !ok || record.loginProfile == nil {
+		return LoginProfile{}, false
+	}
+	return *record.loginProfile, true
+}
+
+// begin counts the call and pops an injected error, if one is queued. The
+// caller must hold c.mu.
+func (c *Client) begin(operation string) error {
+	c.calls[operation]++
+	queued := c.errors[operation]
+	if len(queued) == 0 {
+		return nil
+	}
+	c.errors[operation] = queued[1:]
+	return queued[0]
+}
+
+func (c *Client) lookup(userName string) (*userRecord, error) {
+	record, ok := c.users[userName]
+	if !ok {
+		return nil, noSuchEntity("The user with name %s cannot be found.", userName)
+	}
+	return record, nil
+}
+
+func (c *Client) findKey(accessKeyId string) (*AccessKey, *userRecord) {
+	for _, record := range c.users {
+		for _, key := range record.keys {
+			if key.AccessKeyId == accessKeyId {
+				return key, record
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (c *Client) nextKeyId() string {
+	c.sequence++
+	return fmt.Sprintf("AKIAFAKE%012d", c.sequence)
+}
+
+func (c *Client) sortedUserNames() []string {
+	names := make([]string, 0, len(c.users))
+	for name := range c.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// page slices n items according to the marker and MaxItems of a list call and
+// returns the bounds plus the marker of the next page, if any.
+func (c *Client) page(n int, marker *string, maxItems *int32) (int, int, *string, error) {
+	start := 0
+	if marker != nil {
+		parsed, err := strconv.Atoi(*marker)
+		if err != nil || parsed < 0 || parsed > n {
+			return 0, 0, nil, &types.InvalidInputException{Message: aws.String("Invalid marker")}
+		}
+		start = parsed
+	}
+
+	size := c.PageSize
+	if size <= 0 {
+		size = DefaultPageSize
+	}
+	if maxItems != nil && *maxItems > 0 && *maxItems < size {
+		size = *maxItems
+	}
+
+	end := start + int(size)
+	if end >= n {
+		return start, n, nil, nil
+	}
+	return start, end, aws.String(strconv.Itoa(end)), nil
+}
+
+func noSuchEntity(format string, args ...any) error {
+	return &types.NoSuchEntityException{Message: aws.String(fmt.Sprintf(format, args...))}
+}
+
+func (c *Client) toIamUser(user User) types.User {
+	return types.User{
+		UserName:         aws.String(user.UserName),
+		UserId:           aws.String("AIDAFAKE" + user.UserName),
+		Arn:              aws.String("arn:aws:iam::" + c.accountId + ":user" + user.Path + user.UserName),
+		Path:             aws.String(user.Path),
+		CreateDate:       aws.Time(user.CreateDate),
+		PasswordLastUsed: user.PasswordLastUsed,
+	}
+}
+
+// ListUsers implements the IAM ListUsers call.
+func (c *Client) ListUsers(_ context.Context, params *iam.ListUsersInput, _ ...func(*iam.Options)) (*iam.ListUsersOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("ListUsers"); err != nil {
+		return nil, err
+	}
+
+	var users []types.User
+	for _, name := range c.sortedUserNames() {
+		user := c.users[name].user
+		if params.PathPrefix != nil && !strings.HasPrefix(user.Path, *params.PathPrefix) {
+			continue
+		}
+		users = append(users, c.toIamUser(user))
+	}
+
+	start, end, next, err := c.page(len(users), params.Marker, params.MaxItems)
+	if err != nil {
+		return nil, err
+	}
+	return &iam.ListUsersOutput{
+		Users:       users[start:end],
+		IsTruncated: next != nil,
+		Marker:      next,
+	}, nil
+}
+
+// GetUser implements the IAM GetUser call.
+func (c *Client) GetUser(_ context.Context, params *iam.GetUserInput, _ ...func(*iam.Options)) (*iam.GetUserOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("GetUser"); err != nil {
+		return nil, err
+	}
+
+	record, err := c.lookup(aws.ToString(params.UserName))
+	if err != nil {
+		return nil, err
+	}
+	user := c.toIamUser(record.user)
+	return &iam.GetUserOutput{User: &user}, nil
+}
+
+// ListAccessKeys implements the IAM ListAccessKeys call.
+func (c *Client) ListAccessKeys(_ context.Context, params *iam.ListAccessKeysInput, _ ...func(*iam.Options)) (*iam.ListAccessKeysOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("ListAccessKeys"); err != nil {
+		return nil, err
+	}
+
+	record, err := c.lookup(aws.ToString(params.UserName))
+	if err != nil {
+		return nil, err
+	}
+
+	start, end, next, err := c.page(len(record.keys), params.Marker, params.MaxItems)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := make([]types.AccessKeyMetadata, 0, end-start)
+	for _, key := range record.keys[start:end] {
+		metadata = append(metadata, types.AccessKeyMetadata{
+			AccessKeyId: aws.String(key.AccessKeyId),
+			CreateDate:  aws.Time(key.CreateDate),
+			Status:      key.Status,
+			UserName:    aws.String(record.user.UserName),
+		})
+	}
+	return &iam.ListAccessKeysOutput{
+		AccessKeyMetadata: metadata,
+		IsTruncated:       next != nil,
+		Marker:            next,
+	}, nil
+}
+
+// GetAccessKeyLastUsed implements the IAM GetAccessKeyLastUsed call.
+func (c *Client) GetAccessKeyLastUsed(_ context.Context, params *iam.GetAccessKeyLastUsedInput, _ ...func(*iam.Options)) (*iam.GetAccessKeyLastUsedOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("GetAccessKeyLastUsed"); err != nil {
+		return nil, err
+	}
+
+	key, record := c.findKey(aws.ToString(params.AccessKeyId))
+	if key == nil {
+		return nil, noSuchEntity("The Access Key with id %s cannot be found.", aws.ToString(params.AccessKeyId))
+	}
+
+	lastUsed := &types.AccessKeyLastUsed{
+		Region:      aws.String("N/A"),
+		ServiceName: aws.String("N/A"),
+	}
+	if key.LastUsedDate != nil {
+		lastUsed.LastUsedDate = key.LastUsedDate
+		lastUsed.ServiceName = aws.String(key.LastUsedService)
+		lastUsed.Region = aws.String(key.LastUsedRegion)
+	}
+	return &iam.GetAccessKeyLastUsedOutput{
+		AccessKeyLastUsed: lastUsed,
+		UserName:          aws.String(record.user.UserName),
+	}, nil
+}
+
+// CreateAccessKey implements the IAM CreateAccessKey call.
+func (c *Client) CreateAccessKey(_ context.Context, params *iam.CreateAccessKeyInput, _ ...func(*iam.Options)) (*iam.CreateAccessKeyOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("CreateAccessKey"); err != nil {
+		return nil, err
+	}
+
+	record, err := c.lookup(aws.ToString(params.UserName))
+	if err != nil {
+		return nil, err
+	}
+	if len(record.keys) >= maxKeysPerUser {
+		return nil, &types.LimitExceededException{Message: aws.String("Cannot exceed quota for AccessKeysPerUser: 2")}
+	}
+
+	key := &AccessKey{
+		AccessKeyId: c.nextKeyId(),
+		Status:      types.StatusTypeActive,
+		CreateDate:  c.Now(),
+	}
+	key.SecretAccessKey = "secret-" + key.AccessKeyId
+	record.keys = append(record.keys, key)
+
+	return &iam.CreateAccessKeyOutput{
+		AccessKey: &types.AccessKey{
+			AccessKeyId:     aws.String(key.AccessKeyId),
+			SecretAccessKey: aws.String(key.SecretAccessKey),
+			Status:          key.Status,
+			CreateDate:      aws.Time(key.CreateDate),
+			UserName:        aws.String(record.user.UserName),
+		},
+	}, nil
+}
+
+// UpdateAccessKey implements the IAM UpdateAccessKey call.
+func (c *Client) UpdateAccessKey(_ context.Context, params *iam.UpdateAccessKeyInput, _ ...func(*iam.Options)) (*iam.UpdateAccessKeyOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("UpdateAccessKey"); err != nil {
+		return nil, err
+	}
+
+	key, err := c.userKey(aws.ToString(params.UserName), aws.ToString(params.AccessKeyId))
+	if err != nil {
+		return nil, err
+	}
+	key.Status = params.Status
+	return &iam.UpdateAccessKeyOutput{}, nil
+}
+
+// DeleteAccessKey implements the IAM DeleteAccessKey call.
+func (c *Client) DeleteAccessKey(_ context.Context, params *iam.DeleteAccessKeyInput, _ ...func(*iam.Options)) (*iam.DeleteAccessKeyOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("DeleteAccessKey"); err != nil {
+		return nil, err
+	}
+
+	record, err := c.lookup(aws.ToString(params.UserName))
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range record.keys {
+		if key.AccessKeyId == aws.ToString(params.AccessKeyId) {
+			record.keys = append(record.keys[:i], record.keys[i+1:]...)
+			return &iam.DeleteAccessKeyOutput{}, nil
+		}
+	}
+	return nil, noSuchEntity("The Access Key with id %s cannot be found.", aws.ToString(params.AccessKeyId))
+}
+
+func (c *Client) userKey(userName, accessKeyId string) (*AccessKey, error) {
+	record, err := c.lookup(userName)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range record.keys {
+		if key.AccessKeyId == accessKeyId {
+			return key, nil
+		}
+	}
+	return nil, noSuchEntity("The Access Key with id %s cannot be found.", accessKeyId)
+}
+
+// GetLoginProfile implements the IAM GetLoginProfile call.
+func (c *Client) GetLoginProfile(_ context.Context, params *iam.GetLoginProfileInput, _ ...func(*iam.Options)) (*iam.GetLoginProfileOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("GetLoginProfile"); err != nil {
+		return nil, err
+	}
+
+	record, err := c.lookup(aws.ToString(params.UserName))
+	if err != nil {
+		return nil, err
+	}
+	if record.loginProfile == nil {
+		return nil, noSuchEntity("Login Profile for User %s cannot be found.", record.user.UserName)
+	}
+	return &iam.GetLoginProfileOutput{
+		LoginProfile: &types.LoginProfile{
+			UserName:              aws.String(record.user.UserName),
+			CreateDate:            aws.Time(record.loginProfile.CreateDate),
+			PasswordResetRequired: record.loginProfile.PasswordResetRequired,
+		},
+	}, nil
+}
+
+// UpdateLoginProfile implements the IAM UpdateLoginProfile call.
+func (c *Client) UpdateLoginProfile(_ context.Context, params *iam.UpdateLoginProfileInput, _ ...func(*iam.Options)) (*iam.UpdateLoginProfileOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("UpdateLoginProfile"); err != nil {
+		return nil, err
+	}
+
+	record, err := c.lookup(aws.ToString(params.UserName))
+	if err != nil {
+		return nil, err
+	}
+	if record.loginProfile == nil {
+		return nil, noSuchEntity("Login Profile for User %s cannot be found.", record.user.UserName)
+	}
+	if params.Password != nil {
+		if err := c.checkPassword(record, *params.Password); err != nil {
+			return nil, err
+		}
+		if record.loginProfile.Password != "" {
+			record.passwords = append(record.passwords, record.loginProfile.Password)
+		}
+		record.loginProfile.Password = *params.Password
+		record.loginProfile.CreateDate = c.Now()
+	}
+	if params.PasswordResetRequired != nil {
+		record.loginProfile.PasswordResetRequired = *params.PasswordResetRequired
+	}
+	return &iam.UpdateLoginProfileOutput{}, nil
+}
+
+// DeleteLoginProfile implements the IAM DeleteLoginProfile call.
+func (c *Client) DeleteLoginProfile(_ context.Context, params *iam.DeleteLoginProfileInput, _ ...func(*iam.Options)) (*iam.DeleteLoginProfileOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("DeleteLoginProfile"); err != nil {
+		return nil, err
+	}
+
+	record, err := c.lookup(aws.ToString(params.UserName))
+	if err != nil {
+		return nil, err
+	}
+	if record.loginProfile == nil {
+		return nil, noSuchEntity("Login Profile for User %s cannot be found.", record.user.UserName)
+	}
+	record.loginProfile = nil
+	return &iam.DeleteLoginProfileOutput{}, nil
+}
+
+// SetCallerIdentity sets the principal returned by GetCallerIdentity and the
+// access key it is authenticated with.
+func (c *Client) SetCallerIdentity(userName, accessKeyId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.callerUserName = userName
+	c.callerAccessKeyId = accessKeyId
+}
+
+// CallerAccessKeyId returns the access key set with SetCallerIdentity.
+func (c *Client) CallerAccessKeyId() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.callerAccessKeyId
+}
+
+// GetCallerIdentity implements the STS GetCallerIdentity call.
+func (c *Client) GetCallerIdentity(_ context.Context, _ *sts.GetCallerIdentityInput, _ ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("GetCallerIdentity"); err != nil {
+		return nil, err
+	}
+
+	arn := "arn:aws:sts::" + c.accountId + ":assumed-role/fake/gyro"
+	if c.callerUserName != "" {
+		path := "/"
+		if record, ok := c.users[c.callerUserName]; ok {
+			path = record.user.Path
+		}
+		arn = "arn:aws:iam::" + c.accountId + ":user" + path + c.callerUserName
+	}
+
+	return &sts.GetCallerIdentityOutput{
+		Account: aws.String(c.accountId),
+		Arn:     aws.String(arn),
+		UserId:  aws.String("AIDAFAKE" + c.callerUserName),
+	}, nil
+}
+
+// KeyCaller is an STS client authenticated with one access key pair of the
+// fake account, see Client.AsKey.
+type KeyCaller struct {
+	client          *Client
+	accessKeyId     string
+	secretAccessKey string
+}
+
+// AsKey returns an STS client that calls GetCallerIdentity with the given key
+// pair, as a client configured with those static credentials would.
+func (c *Client) AsKey(accessKeyId, secretAccessKey string) *KeyCaller {
+	return &KeyCaller{client: c, accessKeyId: accessKeyId, secretAccessKey: secretAccessKey}
+}
+
+// GetCallerIdentity implements the STS GetCallerIdentity call. It fails like
+// STS does for unknown, inactive or mismatched keys, and for keys younger
+// than the client's KeyPropagationDelay.
+func (k *KeyCaller) GetCallerIdentity(_ context.Context, _ *sts.GetCallerIdentityInput, _ ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+	c := k.client
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("GetCallerIdentity"); err != nil {
+		return nil, err
+	}
+
+	key, record := c.findKey(k.accessKeyId)
+	if key == nil || key.SecretAccessKey != k.secretAccessKey || key.Status != types.StatusTypeActive ||
+		c.Now().Before(key.CreateDate.Add(c.KeyPropagationDelay)) {
+		return nil, errors.New("InvalidClientTokenId: The security token included in the request is invalid.")
+	}
+
+	return &sts.GetCallerIdentityOutput{
+		Account: aws.String(c.accountId),
+		Arn:     aws.String("arn:aws:iam::" + c.accountId + ":user" + record.user.Path + record.user.UserName),
+		UserId:  aws.String("AIDAFAKE" + record.user.UserName),
+	}, nil
+}
@@ -0,0 +1,213 @@
+package fakeiam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+// newClient returns a fake with the given users and page size.
+func newClient(pageSize int32, users ...string) *Client {
+	client := New()
+	client.PageSize = pageSize
+	for _, name := range users {
+		client.AddUser(User{UserName: name})
+	}
+	return client
+}
+
+// listAllUsers pages through ListUsers and returns the names of every page.
+func listAllUsers(t *testing.T, client *Client, input *iam.ListUsersInput) [][]string {
+	t.Helper()
+	var pages [][]string
+	for {
+		result, err := client.ListUsers(context.TODO(), input)
+		if err != nil {
+			t.Fatalf("ListUsers: %v", err)
+		}
+		var names []string
+		for _, user := range result.Users {
+			names = append(names, aws.ToString(user.UserName))
+		}
+		pages = append(pages, names)
+		if !result.IsTruncated {
+			return pages
+		}
+		input.Marker = result.Marker
+	}
+}
+
+func TestListUsersPaging(t *testing.T) {
+	tests := []struct {
+		name     string
+		pageSize int32
+		input    iam.ListUsersInput
+		want     [][]string
+	}{
+		{name: "single page", pageSize: 10, want: [][]string{{"alice", "bob", "carol"}}},
+		{name: "page size", pageSize: 2, want: [][]string{{"alice", "bob"}, {"carol"}}},
+		{name: "max items below page size", pageSize: 10, input: iam.ListUsersInput{MaxItems: aws.Int32(1)}, want: [][]string{{"alice"}, {"bob"}, {"carol"}}},
+		{name: "path prefix", pageSize: 10, input: iam.ListUsersInput{PathPrefix: aws.String("/svc/")}, want: [][]string{{"carol"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newClient(test.pageSize, "bob", "alice")
+			client.AddUser(User{UserName: "carol", Path: "/svc/"})
+			if got := listAllUsers(t, client, &test.input); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("pages = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAccessKeyQuota(t *testing.T) {
+	client := newClient(0, "alice")
+	for i := 0; i < maxKeysPerUser; i++ {
+		if _, err := client.CreateAccessKey(context.TODO(), &iam.CreateAccessKeyInput{UserName: aws.String("alice")}); err != nil {
+			t.Fatalf("CreateAccessKey %d: %v", i+1, err)
+		}
+	}
+
+	_, err := client.CreateAccessKey(context.TODO(), &iam.CreateAccessKeyInput{UserName: aws.String("alice")})
+	var limit *types.LimitExceededException
+	if !errors.As(err, &limit) {
+		t.Fatalf("third CreateAccessKey err = %v, want LimitExceededException", err)
+	}
+
+	keys := client.AccessKeys("alice")
+	if len(keys) != maxKeysPerUser {
+		t.Fatalf("got %d keys, want %d", len(keys), maxKeysPerUser)
+	}
+	if keys[0].AccessKeyId == keys[1].AccessKeyId {
+		t.Errorf("created keys share the id %s", keys[0].AccessKeyId)
+	}
+}
+
+func TestKeyLifecycle(t *testing.T) {
+	client := newClient(0, "alice")
+	key, err := client.AddAccessKey("alice", AccessKey{AccessKeyId: "AKIAOLD"})
+	if err != nil {
+		t.Fatalf("AddAccessKey: %v", err)
+	}
+	if key.Status != types.StatusTypeActive {
+		t.Errorf("added key status = %s, want Active", key.Status)
+	}
+
+	if _, err := client.UpdateAccessKey(context.TODO(), &iam.UpdateAccessKeyInput{
+		UserName: aws.String("alice"), AccessKeyId: aws.String("AKIAOLD"), Status: types.StatusTypeInactive,
+	}); err != nil {
+		t.Fatalf("UpdateAccessKey: %v", err)
+	}
+	if status := client.AccessKeys("alice")[0].Status; status != types.StatusTypeInactive {
+		t.Errorf("status after update = %s, want Inactive", status)
+	}
+
+	if _, err := client.DeleteAccessKey(context.TODO(), &iam.DeleteAccessKeyInput{
+		UserName: aws.String("alice"), AccessKeyId: aws.String("AKIAOLD"),
+	}); err != nil {
+		t.Fatalf("DeleteAccessKey: %v", err)
+	}
+	if keys := client.AccessKeys("alice"); len(keys) != 0 {
+		t.Errorf("keys after delete = %v, want none", keys)
+	}
+
+	_, err = client.DeleteAccessKey(context.TODO(), &iam.DeleteAccessKeyInput{
+		UserName: aws.String("alice"), AccessKeyId: aws.String("AKIAOLD"),
+	})
+	var missing *types.NoSuchEntityException
+	if !errors.As(err, &missing) {
+		t.Errorf("second DeleteAccessKey err = %v, want NoSuchEntityException", err)
+	}
+}
+
+func TestFailOn(t *testing.T) {
+	client := newClient(0, "alice")
+	first, second := errors.New("throttled"), errors.New("service failure")
+	client.FailOn("GetUser", first, second)
+
+	input := &iam.GetUserInput{UserName: aws.String("alice")}
+	for _, want := range []error{first, second, nil} {
+		if _, err := client.GetUser(context.TODO(), input); err != want {
+			t.Errorf("GetUser err = %v, want %v", err, want)
+		}
+	}
+	if calls := client.Calls("GetUser"); calls != 3 {
+		t.Errorf("GetUser calls = %d, want 3", calls)
+	}
+	if calls := client.Calls("ListUsers"); calls != 0 {
+		t.Errorf("ListUsers calls = %d, want 0", calls)
+	}
+}
+
+func TestUpdateLoginProfile(t *testing.T) {
+	client := newClient(0, "alice", "bob")
+	created := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := client.AddLoginProfile("alice", LoginProfile{CreateDate: created}); err != nil {
+		t.Fatalf("AddLoginProfile: %v", err)
+	}
+
+	if _, err := client.UpdateLoginProfile(context.TODO(), &iam.UpdateLoginProfileInput{
+		UserName: aws.String("alice"), Password: aws.String("new-password"), PasswordResetRequired: aws.Bool(true),
+	}); err != nil {
+		t.Fatalf("UpdateLoginProfile: %v", err)
+	}
+	profile, ok := client.LoginProfileOf("alice")
+	if !ok || profile.Password != "new-password" || !profile.PasswordResetRequired || !profile.CreateDate.After(created) {
+		t.Errorf("profile = %+v, want the new password, a reset and a new create date", profile)
+	}
+
+	_, err := client.UpdateLoginProfile(context.TODO(), &iam.UpdateLoginProfileInput{UserName: aws.String("bob")})
+	var missing *types.NoSuchEntityException
+	if !errors.As(err, &missing) {
+		t.Errorf("UpdateLoginProfile without profile err = %v, want NoSuchEntityException", err)
+	}
+}
+
+func TestLoadFixture(t *testing.T) {
+	created := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	fixture := Fixture{
+		PageSize: 1,
+		Users: []FixtureUser{{
+			UserName:     "alice",
+			LoginProfile: &FixtureLoginProfile{CreateDate: created},
+			AccessKeys:   []FixtureAccessKey{{AccessKeyId: "AKIAOLD", Status: "Inactive", CreateDate: created}},
+		}},
+	}
+	raw, err := json.Marshal(fixture)
+	if err != nil {
+		t.Fatalf("marshal fixture: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	if err := os.WriteFile(path, raw, 0o600); err != nil {
+		t.Fatalf("write fixture: %v", err)
+	}
+
+	client, err := LoadFixture(path)
+	if err != nil {
+		t.Fatalf("LoadFixture: %v", err)
+	}
+	if client.PageSize != 1 {
+		t.Errorf("PageSize = %d, want 1", client.PageSize)
+	}
+	keys := client.AccessKeys("alice")
+	if len(keys) != 1 || keys[0].AccessKeyId != "AKIAOLD" || keys[0].Status != types.StatusTypeInactive || !keys[0].CreateDate.Equal(created) {
+		t.Errorf("keys = %+v, want the inactive AKIAOLD", keys)
+	}
+	if _, ok := client.LoginProfileOf("alice"); !ok {
+		t.Error("alice has no login profile")
+	}
+
+	if _, err := LoadFixture(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("LoadFixture of a missing file succeeded")
+	}
+}
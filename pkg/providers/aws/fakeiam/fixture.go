@@ -0,0 +1,188 @@
+package fakeiam
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+// Fixture is the JSON layout accepted by LoadFixture. It describes a whole
+// simulated account:
+//
+//	{
+//	  "pageSize": 50,
+//	  "keyPropagationDelay": "5s",
+//	  "accounts": [{"id": "111111111111", "name": "Production", "alias": "acme-prod"}],
+//	  "caller": {"userName": "alice", "accessKeyId": "AKIA..."},
+//	  "passwordPolicy": {"minimumLength": 14, "requireSymbols": true, "reusePrevention": 5},
+//	  "users": [
+//	    {
+//	      "userName": "alice",
+//	      "path": "/svc/",
+//	      "tags": {"team": "payments"},
+//	      "groups": ["deployers"],
+//	      "passwordLastUsed": "2024-01-02T15:04:05Z",
+//	      "loginProfile": {"createDate": "2023-06-01T00:00:00Z"},
+//	      "accessKeys": [
+//	        {"accessKeyId": "AKIA...", "status": "Active", "createDate": "2023-01-01T00:00:00Z",
+//	         "lastUsedDate": "2024-01-01T00:00:00Z", "lastUsedService": "s3"}
+//	      ]
+//	    }
+//	  ]
+//	}
+type Fixture struct {
+	PageSize int32 `json:"pageSize"`
+	// KeyPropagationDelay is a duration such as "5s", see
+	// Client.KeyPropagationDelay.
+	KeyPropagationDelay string                 `json:"keyPropagationDelay"`
+	Caller              *FixtureCaller         `json:"caller"`
+	PasswordPolicy      *FixturePasswordPolicy `json:"passwordPolicy"`
+	Users               []FixtureUser          `json:"users"`
+	// Accounts are the organization returned by LoadAccounts.
+	Accounts []FixtureAccount `json:"accounts"`
+}
+
+// FixtureAccount is one account of the simulated organization.
+type FixtureAccount struct {
+	Id    string `json:"id"`
+	Name  string `json:"name"`
+	Alias string `json:"alias"`
+}
+
+// FixtureCaller is the principal gyro is authenticated as.
+type FixtureCaller struct {
+	UserName    string `json:"userName"`
+	AccessKeyId string `json:"accessKeyId"`
+}
+
+// FixturePasswordPolicy is the account password policy. Without it the
+// account has no custom policy.
+type FixturePasswordPolicy struct {
+	MinimumLength    int32 `json:"minimumLength"`
+	RequireLowercase bool  `json:"requireLowercase"`
+	RequireUppercase bool  `json:"requireUppercase"`
+	RequireNumbers   bool  `json:"requireNumbers"`
+	RequireSymbols   bool  `json:"requireSymbols"`
+	ReusePrevention  int32 `json:"reusePrevention"`
+}
+
+type FixtureUser struct {
+	UserName         string               `json:"userName"`
+	Path             string               `json:"path"`
+	Tags             map[string]string    `json:"tags"`
+	Groups           []string             `json:"groups"`
+	CreateDate       time.Time            `json:"createDate"`
+	PasswordLastUsed *time.Time           `json:"passwordLastUsed"`
+	LoginProfile     *FixtureLoginProfile `json:"loginProfile"`
+	AccessKeys       []FixtureAccessKey   `json:"accessKeys"`
+}
+
+type FixtureLoginProfile struct {
+	CreateDate            time.Time `json:"createDate"`
+	PasswordResetRequired bool      `json:"passwordResetRequired"`
+}
+
+type FixtureAccessKey struct {
+	AccessKeyId     string     `json:"accessKeyId"`
+	Status          string     `json:"status"`
+	CreateDate      time.Time  `json:"createDate"`
+	LastUsedDate    *time.Time `json:"lastUsedDate"`
+	LastUsedService string     `json:"lastUsedService"`
+	LastUsedRegion  string     `json:"lastUsedRegion"`
+}
+
+// LoadFixture builds a fake account from a JSON fixture file.
+func LoadFixture(path string) (*Client, error) {
+	fixture, err := readFixture(path)
+	if err != nil {
+		return nil, err
+	}
+	return FromFixture(fixture)
+}
+
+func readFixture(path string) (Fixture, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return Fixture{}, fmt.Errorf("error reading fixture %s: %w", path, err)
+	}
+
+	var fixture Fixture
+	if err := json.Unmarshal(raw, &fixture); err != nil {
+		return Fixture{}, fmt.Errorf("error parsing fixture %s: %w", path, err)
+	}
+	return fixture, nil
+}
+
+// FromFixture builds a fake account from an in-memory fixture.
+func FromFixture(fixture Fixture) (*Client, error) {
+	client := New()
+	if fixture.PageSize > 0 {
+		client.PageSize = fixture.PageSize
+	}
+	if fixture.KeyPropagationDelay != "" {
+		delay, err := time.ParseDuration(fixture.KeyPropagationDelay)
+		if err != nil {
+			return nil, fmt.Errorf("invalid keyPropagationDelay: %w", err)
+		}
+		client.KeyPropagationDelay = delay
+	}
+
+	for _, user := range fixture.Users {
+		client.AddUser(User{
+			UserName:         user.UserName,
+			Path:             user.Path,
+			CreateDate:       user.CreateDate,
+			PasswordLastUsed: user.PasswordLastUsed,
+			Tags:             user.Tags,
+			Groups:           user.Groups,
+		})
+
+		if user.LoginProfile != nil {
+			if err := client.AddLoginProfile(user.UserName, LoginProfile{
+				CreateDate:            user.LoginProfile.CreateDate,
+				PasswordResetRequired: user.LoginProfile.PasswordResetRequired,
+			}); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, key := range user.AccessKeys {
+			if _, err := client.AddAccessKey(user.UserName, AccessKey{
+				AccessKeyId:     key.AccessKeyId,
+				Status:          types.StatusType(key.Status),
+				CreateDate:      key.CreateDate,
+				LastUsedDate:    key.LastUsedDate,
+				LastUsedService: key.LastUsedService,
+				LastUsedRegion:  key.LastUsedRegion,
+			}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if policy := fixture.PasswordPolicy; policy != nil {
+		iamPolicy := &types.PasswordPolicy{
+			RequireLowercaseCharacters: policy.RequireLowercase,
+			RequireUppercaseCharacters: policy.RequireUppercase,
+			RequireNumbers:             policy.RequireNumbers,
+			RequireSymbols:             policy.RequireSymbols,
+		}
+		if policy.MinimumLength > 0 {
+			iamPolicy.MinimumPasswordLength = aws.Int32(policy.MinimumLength)
+		}
+		if policy.ReusePrevention > 0 {
+			iamPolicy.PasswordReusePrevention = aws.Int32(policy.ReusePrevention)
+		}
+		client.SetPasswordPolicy(iamPolicy)
+	}
+
+	if fixture.Caller != nil {
+		client.SetCallerIdentity(fixture.Caller.UserName, fixture.Caller.AccessKeyId)
+	}
+
+	return client, nil
+}
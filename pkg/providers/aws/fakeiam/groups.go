@@ -0,0 +1,92 @@
+package fakeiam
+
+import (
+	"context"
+	"slices"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+// AddGroup registers an empty group. Groups listed in User.Groups are
+// registered by AddUser.
+func (c *Client) AddGroup(groupName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.groups[groupName] = true
+}
+
+// GetGroup implements the IAM GetGroup call, paging through the members.
+func (c *Client) GetGroup(_ context.Context, params *iam.GetGroupInput, _ ...func(*iam.Options)) (*iam.GetGroupOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("GetGroup"); err != nil {
+		return nil, err
+	}
+
+	groupName := aws.ToString(params.GroupName)
+	if !c.groups[groupName] {
+		return nil, noSuchEntity("The group with name %s cannot be found.", groupName)
+	}
+
+	var members []types.User
+	for _, name := range c.sortedUserNames() {
+		user := c.users[name].user
+		if slices.Contains(user.Groups, groupName) {
+			members = append(members, c.toIamUser(user))
+		}
+	}
+
+	start, end, next, err := c.page(len(members), params.Marker, params.MaxItems)
+	if err != nil {
+		return nil, err
+	}
+	return &iam.GetGroupOutput{
+		Group: &types.Group{
+			GroupName: aws.String(groupName),
+			GroupId:   aws.String("AGPAFAKE" + groupName),
+			Arn:       aws.String("arn:aws:iam::" + c.accountId + ":group/" + groupName),
+			Path:      aws.String("/"),
+		},
+		Users:       members[start:end],
+		IsTruncated: next != nil,
+		Marker:      next,
+	}, nil
+}
+
+// ListUserTags implements the IAM ListUserTags call.
+func (c *Client) ListUserTags(_ context.Context, params *iam.ListUserTagsInput, _ ...func(*iam.Options)) (*iam.ListUserTagsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("ListUserTags"); err != nil {
+		return nil, err
+	}
+
+	record, err := c.lookup(aws.ToString(params.UserName))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(record.user.Tags))
+	for key := range record.user.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tags := make([]types.Tag, 0, len(keys))
+	for _, key := range keys {
+		tags = append(tags, types.Tag{Key: aws.String(key), Value: aws.String(record.user.Tags[key])})
+	}
+
+	start, end, next, err := c.page(len(tags), params.Marker, params.MaxItems)
+	if err != nil {
+		return nil, err
+	}
+	return &iam.ListUserTagsOutput{
+		Tags:        tags[start:end],
+		IsTruncated: next != nil,
+		Marker:      next,
+	}, nil
+}
@@ -0,0 +1,75 @@
+package fakeiam
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+// SetPasswordPolicy sets the account password policy. A nil policy removes
+// it, so GetAccountPasswordPolicy fails with NoSuchEntity as in IAM.
+func (c *Client) SetPasswordPolicy(policy *types.PasswordPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.passwordPolicy = policy
+}
+
+// GetAccountPasswordPolicy implements the IAM GetAccountPasswordPolicy call.
+func (c *Client) GetAccountPasswordPolicy(_ context.Context, _ *iam.GetAccountPasswordPolicyInput, _ ...func(*iam.Options)) (*iam.GetAccountPasswordPolicyOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("GetAccountPasswordPolicy"); err != nil {
+		return nil, err
+	}
+
+	if c.passwordPolicy == nil {
+		return nil, noSuchEntity("The Password Policy with domain name %s cannot be found.", c.accountId)
+	}
+	policy := *c.passwordPolicy
+	return &iam.GetAccountPasswordPolicyOutput{PasswordPolicy: &policy}, nil
+}
+
+// checkPassword applies the account password policy to a new password the
+// way UpdateLoginProfile does.
+func (c *Client) checkPassword(record *userRecord, password string) error {
+	policy := c.passwordPolicy
+	if policy == nil {
+		return nil
+	}
+
+	violation := func(reason string) error {
+		return &types.PasswordPolicyViolationException{Message: aws.String("Password does not conform to the account password policy: " + reason)}
+	}
+
+	if policy.MinimumPasswordLength != nil && len(password) < int(*policy.MinimumPasswordLength) {
+		return violation("too short")
+	}
+	if policy.RequireLowercaseCharacters && !strings.ContainsAny(password, "abcdefghijklmnopqrstuvwxyz") {
+		return violation("missing a lowercase letter")
+	}
+	if policy.RequireUppercaseCharacters && !strings.ContainsAny(password, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") {
+		return violation("missing an uppercase letter")
+	}
+	if policy.RequireNumbers && !strings.ContainsAny(password, "0123456789") {
+		return violation("missing a number")
+	}
+	if policy.RequireSymbols && !strings.ContainsAny(password, "!@#$%^&*()_+-=[]{}|'") {
+		return violation("missing a symbol")
+	}
+
+	if policy.PasswordReusePrevention != nil {
+		history := append(append([]string{}, record.passwords...), record.loginProfile.Password)
+		if n := int(*policy.PasswordReusePrevention); len(history) > n {
+			history = history[len(history)-n:]
+		}
+		for _, previous := range history {
+			if previous == password {
+				return violation("password was used recently")
+			}
+		}
+	}
+	return nil
+}
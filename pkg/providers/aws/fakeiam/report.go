@@ -0,0 +1,130 @@
+package fakeiam
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+var credentialReportHeader = []string{
+	"user", "arn", "user_creation_time",
+	"password_enabled", "password_last_used", "password_last_changed", "password_next_rotation",
+	"mfa_active",
+	"access_key_1_active", "access_key_1_last_rotated", "access_key_1_last_used_date", "access_key_1_last_used_region", "access_key_1_last_used_service",
+	"access_key_2_active", "access_key_2_last_rotated", "access_key_2_last_used_date", "access_key_2_last_used_region", "access_key_2_last_used_service",
+	"cert_1_active", "cert_1_last_rotated", "cert_2_active", "cert_2_last_rotated",
+}
+
+// GenerateCredentialReport implements the IAM GenerateCredentialReport call.
+// The simulated report is built on demand, so it is always complete.
+func (c *Client) GenerateCredentialReport(_ context.Context, _ *iam.GenerateCredentialReportInput, _ ...func(*iam.Options)) (*iam.GenerateCredentialReportOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("GenerateCredentialReport"); err != nil {
+		return nil, err
+	}
+
+	return &iam.GenerateCredentialReportOutput{
+		State:       types.ReportStateTypeComplete,
+		Description: aws.String("No report exists. Starting a new report generation task"),
+	}, nil
+}
+
+// GetCredentialReport implements the IAM GetCredentialReport call. The report
+// lists the root account first and then every user, without MFA devices.
+func (c *Client) GetCredentialReport(_ context.Context, _ *iam.GetCredentialReportInput, _ ...func(*iam.Options)) (*iam.GetCredentialReportOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("GetCredentialReport"); err != nil {
+		return nil, err
+	}
+
+	now := c.Now()
+	var content bytes.Buffer
+	writer := csv.NewWriter(&content)
+	_ = writer.Write(credentialReportHeader)
+
+	rootRow := []string{
+		"<root_account>", "arn:aws:iam::" + c.accountId + ":root", reportTime(now),
+		"not_supported", "no_information", "not_supported", "not_supported",
+		"false",
+	}
+	rootRow = append(rootRow, reportKeyColumns(nil)...)
+	rootRow = append(rootRow, reportCertColumns()...)
+	_ = writer.Write(rootRow)
+
+	for _, name := range c.sortedUserNames() {
+		record := c.users[name]
+		user := c.toIamUser(record.user)
+
+		passwordEnabled, passwordLastChanged := "false", "N/A"
+		if record.loginProfile != nil {
+			passwordEnabled = "true"
+			passwordLastChanged = reportTime(record.loginProfile.CreateDate)
+		}
+		passwordLastUsed := "N/A"
+		if record.user.PasswordLastUsed != nil {
+			passwordLastUsed = reportTime(*record.user.PasswordLastUsed)
+		} else if record.loginProfile != nil {
+			passwordLastUsed = "no_information"
+		}
+
+		row := []string{
+			name, aws.ToString(user.Arn), reportTime(record.user.CreateDate),
+			passwordEnabled, passwordLastUsed, passwordLastChanged, "N/A",
+			"false",
+		}
+		row = append(row, reportKeyColumns(record.keys)...)
+		row = append(row, reportCertColumns()...)
+		_ = writer.Write(row)
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return nil, err
+	}
+
+	return &iam.GetCredentialReportOutput{
+		Content:       content.Bytes(),
+		GeneratedTime: aws.Time(now),
+		ReportFormat:  types.ReportFormatTypeTextCsv,
+	}, nil
+}
+
+func reportKeyColumns(keys []*AccessKey) []string {
+	var columns []string
+	for i := 0; i < maxKeysPerUser; i++ {
+		if i >= len(keys) {
+			columns = append(columns, "false", "N/A", "N/A", "N/A", "N/A")
+			continue
+		}
+
+		key := keys[i]
+		lastUsed, region, service := "N/A", "N/A", "N/A"
+		if key.LastUsedDate != nil {
+			lastUsed = reportTime(*key.LastUsedDate)
+			region = key.LastUsedRegion
+			service = key.LastUsedService
+		}
+		columns = append(columns,
+			strconv.FormatBool(key.Status == types.StatusTypeActive),
+			reportTime(key.CreateDate),
+			lastUsed, region, service,
+		)
+	}
+	return columns
+}
+
+func reportCertColumns() []string {
+	return []string{"false", "N/A", "false", "N/A"}
+}
+
+func reportTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05+00:00")
+}
@@ -0,0 +1,285 @@
+package iam
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/charmbracelet/log"
+)
+
+// UserFilter selects users by path, group, tag and name. A user is kept when
+// it matches every include filter that is set and none of the exclude
+// filters:
+//
+//   - Groups / ExcludeGroups: member of any of the groups.
+//   - Tags / ExcludeTags: "key" (tag exists), "key=value" or "key=glob";
+//     every include tag must match, any exclude tag drops the user.
+//   - Names / ExcludeNames: user name globs, or regular expressions with a
+//     "re:" prefix; any of them matches.
+type UserFilter struct {
+	PathPrefix    string
+	Groups        []string
+	ExcludeGroups []string
+	Tags          []string
+	ExcludeTags   []string
+	Names         []string
+	ExcludeNames  []string
+}
+
+// NeedsAPI reports whether the filter looks up groups or tags, which a saved
+// credential report cannot answer.
+func (filter UserFilter) NeedsAPI() bool {
+	return len(filter.Groups) > 0 || len(filter.ExcludeGroups) > 0 ||
+		len(filter.Tags) > 0 || len(filter.ExcludeTags) > 0
+}
+
+// Validate checks the tag specs and name patterns of the filter.
+func (filter UserFilter) Validate() error {
+	for _, tags := range [][]string{filter.Tags, filter.ExcludeTags} {
+		if _, err := parseTagMatchers(tags); err != nil {
+			return err
+		}
+	}
+	for _, names := range [][]string{filter.Names, filter.ExcludeNames} {
+		if _, err := compileNamePatterns(names); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasIncludes reports whether the filter narrows the selection down, as
+// opposed to only excluding users.
+func (filter UserFilter) hasIncludes() bool {
+	return filter.PathPrefix != "" || len(filter.Groups) > 0 || len(filter.Tags) > 0 || len(filter.Names) > 0
+}
+
+// isSet reports whether any part of the filter is set.
+func (filter UserFilter) isSet() bool {
+	return filter.hasIncludes() || filter.NeedsAPI() || len(filter.ExcludeNames) > 0
+}
+
+// keepNamedUser applies the filter and the --exclude list to the user named
+// with --username, so that naming a user narrows the selection instead of
+// bypassing it. A user left out is logged.
+func (input GetWrapperInputs) keepNamedUser(user types.User) (bool, error) {
+	selector, err := newUserSelector(input)
+	if err != nil {
+		return false, err
+	}
+	kept, err := selector.keep(user)
+	if err != nil {
+		return false, err
+	}
+	if !kept {
+		log.Warnf("User %s does not match the user filters, skipping", aws.ToString(user.UserName))
+	}
+	return kept, nil
+}
+
+type tagMatcher struct {
+	key   string
+	value *regexp.Regexp
+}
+
+// userSelector is a compiled UserFilter plus the --exclude list.
+type userSelector struct {
+	wrapper       UserWrapper
+	pathPrefix    string
+	exclude       map[string]bool
+	groups        map[string]bool
+	excludeGroups map[string]bool
+	tags          []tagMatcher
+	excludeTags   []tagMatcher
+	names         []*regexp.Regexp
+	excludeNames  []*regexp.Regexp
+}
+
+// newUserSelector compiles the patterns of input.Filter and resolves its
+// groups to member names.
+func newUserSelector(input GetWrapperInputs) (*userSelector, error) {
+	filter := input.Filter
+	selector := &userSelector{
+		wrapper:    input.Client,
+		pathPrefix: filter.PathPrefix,
+		exclude:    make(map[string]bool, len(input.Exclude)),
+	}
+	for _, name := range input.Exclude {
+		selector.exclude[name] = true
+	}
+
+	var err error
+	if selector.groups, err = input.Client.groupMembers(filter.Groups); err != nil {
+		return nil, err
+	}
+	if selector.excludeGroups, err = input.Client.groupMembers(filter.ExcludeGroups); err != nil {
+		return nil, err
+	}
+	if selector.tags, err = parseTagMatchers(filter.Tags); err != nil {
+		return nil, err
+	}
+	if selector.excludeTags, err = parseTagMatchers(filter.ExcludeTags); err != nil {
+		return nil, err
+	}
+	if selector.names, err = compileNamePatterns(filter.Names); err != nil {
+		return nil, err
+	}
+	if selector.excludeNames, err = compileNamePatterns(filter.ExcludeNames); err != nil {
+		return nil, err
+	}
+	return selector, nil
+}
+
+// keep reports whether user passes the selection. Tags are only fetched when
+// a tag filter is set and the cheaper checks passed.
+func (selector *userSelector) keep(user types.User) (bool, error) {
+	name := aws.ToString(user.UserName)
+
+	if selector.exclude[name] {
+		log.Debugf("Excluding user %s", name)
+		return false, nil
+	}
+	if selector.pathPrefix != "" && !strings.HasPrefix(aws.ToString(user.Path), selector.pathPrefix) {
+		return false, nil
+	}
+	if selector.groups != nil && !selector.groups[name] {
+		return false, nil
+	}
+	if selector.excludeGroups[name] {
+		return false, nil
+	}
+	if len(selector.names) > 0 && !matchesAny(selector.names, name) {
+		return false, nil
+	}
+	if matchesAny(selector.excludeNames, name) {
+		return false, nil
+	}
+
+	if len(selector.tags) == 0 && len(selector.excludeTags) == 0 {
+		return true, nil
+	}
+	tags, err := selector.wrapper.userTags(name)
+	if err != nil {
+		return false, err
+	}
+	for _, matcher := range selector.tags {
+		if !matcher.matches(tags) {
+			return false, nil
+		}
+	}
+	for _, matcher := range selector.excludeTags {
+		if matcher.matches(tags) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// groupMembers returns the names of the users in any of the groups, or nil
+// when no group is given.
+func (wrapper UserWrapper) groupMembers(groups []string) (map[string]bool, error) {
+	if len(groups) == 0 {
+		return nil, nil
+	}
+
+	members := map[string]bool{}
+	for _, group := range groups {
+		input := &iam.GetGroupInput{GroupName: aws.String(group)}
+		for {
+			result, err := wrapper.IamClient.GetGroup(context.TODO(), input)
+			if err != nil {
+				return nil, fmt.Errorf("group %s: %w", group, classifyError(err, nil))
+			}
+			for _, user := range result.Users {
+				members[aws.ToString(user.UserName)] = true
+			}
+			if !result.IsTruncated || result.Marker == nil {
+				break
+			}
+			input.Marker = result.Marker
+		}
+	}
+	return members, nil
+}
+
+// userTags returns every tag of a user.
+func (wrapper UserWrapper) userTags(userName string) (map[string]string, error) {
+	tags := map[string]string{}
+	input := &iam.ListUserTagsInput{UserName: aws.String(userName)}
+	for {
+		result, err := wrapper.IamClient.ListUserTags(context.TODO(), input)
+		if err != nil {
+			return nil, fmt.Errorf("tags of user %s: %w", userName, classifyError(err, nil))
+		}
+		for _, tag := range result.Tags {
+			tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+		if !result.IsTruncated || result.Marker == nil {
+			return tags, nil
+		}
+		input.Marker = result.Marker
+	}
+}
+
+func parseTagMatchers(specs []string) ([]tagMatcher, error) {
+	var matchers []tagMatcher
+	for _, spec := range specs {
+		key, value, hasValue := strings.Cut(spec, "=")
+		if key == "" {
+			return nil, fmt.Errorf("invalid tag filter %q, expected key, key=value or key=glob", spec)
+		}
+		matcher := tagMatcher{key: key}
+		if hasValue {
+			matcher.value = globPattern(value)
+		}
+		matchers = append(matchers, matcher)
+	}
+	return matchers, nil
+}
+
+func (matcher tagMatcher) matches(tags map[string]string) bool {
+	value, ok := tags[matcher.key]
+	if !ok {
+		return false
+	}
+	return matcher.value == nil || matcher.value.MatchString(value)
+}
+
+func compileNamePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		if expr, ok := strings.CutPrefix(pattern, "re:"); ok {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid name pattern %q: %w", pattern, err)
+			}
+			compiled = append(compiled, re)
+			continue
+		}
+		compiled = append(compiled, globPattern(pattern))
+	}
+	return compiled, nil
+}
+
+// globPattern turns a glob where * matches any run of characters and ? a
+// single one into an anchored regular expression.
+func globPattern(glob string) *regexp.Regexp {
+	expr := regexp.QuoteMeta(glob)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	return regexp.MustCompile("^" + expr + "$")
+}
+
+func matchesAny(patterns []*regexp.Regexp, value string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package iam
+
+import (
+	"testing"
+	"time"
+
+	"github.com/javiercm1410/gyro/pkg/providers/aws/fakeiam"
+)
+
+func TestNamedUserFilters(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   UserFilter
+		exclude  []string
+		wantUser bool
+	}{
+		{name: "no filters", wantUser: true},
+		{name: "matching group", filter: UserFilter{Groups: []string{"deploy"}}, wantUser: true},
+		{name: "other group", filter: UserFilter{Groups: []string{"admins"}}},
+		{name: "matching tag", filter: UserFilter{Tags: []string{"team=platform"}}, wantUser: true},
+		{name: "other tag", filter: UserFilter{Tags: []string{"team=data"}}},
+		{name: "matching path", filter: UserFilter{PathPrefix: "/svc/"}, wantUser: true},
+		{name: "other path", filter: UserFilter{PathPrefix: "/humans/"}},
+		{name: "excluded name", filter: UserFilter{ExcludeNames: []string{"ci*"}}},
+		{name: "excluded user", exclude: []string{"ci"}},
+	}
+
+	fixture := fakeiam.Fixture{
+		Users: []fakeiam.FixtureUser{{
+			UserName:     "ci",
+			Path:         "/svc/",
+			Tags:         map[string]string{"team": "platform"},
+			Groups:       []string{"deploy"},
+			LoginProfile: &fakeiam.FixtureLoginProfile{CreateDate: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+			AccessKeys:   []fakeiam.FixtureAccessKey{fixtureKey("AKIACI", "2022-01-01")},
+		}, {
+			UserName: "ops",
+			Groups:   []string{"admins"},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wrapper, _ := fakeWrapper(t, fixture)
+			input := GetWrapperInputs{Age: 90, UserName: "ci", Filter: test.filter, Exclude: test.exclude, Client: wrapper}
+
+			keys, err := GetUserAccessKey(input)
+			if err != nil {
+				t.Fatalf("GetUserAccessKey: %v", err)
+			}
+			if got := len(keys) == 1; got != test.wantUser {
+				t.Errorf("access keys listed = %v, want %v", got, test.wantUser)
+			}
+
+			profiles, err := GetLoginProfiles(input)
+			if err != nil {
+				t.Fatalf("GetLoginProfiles: %v", err)
+			}
+			if got := len(profiles) == 1; got != test.wantUser {
+				t.Errorf("login profiles listed = %v, want %v", got, test.wantUser)
+			}
+		})
+	}
+}
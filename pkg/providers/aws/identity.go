@@ -0,0 +1,120 @@
+package iam
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/charmbracelet/log"
+)
+
+// StsAPI is the subset of the STS client used by gyro.
+type StsAPI interface {
+	GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error)
+}
+
+// CallerIdentity describes the principal gyro runs as.
+type CallerIdentity struct {
+	Account string
+	Arn     string
+	// UserName is only set when the caller is an IAM user.
+	UserName    string
+	AccessKeyId string
+}
+
+// GetCallerIdentity resolves the calling principal through STS.
+func (wrapper UserWrapper) GetCallerIdentity() (CallerIdentity, error) {
+	if wrapper.StsClient == nil {
+		return CallerIdentity{}, fmt.Errorf("no STS client configured")
+	}
+
+	result, err := wrapper.StsClient.GetCallerIdentity(context.TODO(), &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return CallerIdentity{}, classifyError(err, nil)
+	}
+
+	arn := aws.ToString(result.Arn)
+	return CallerIdentity{
+		Account:     aws.ToString(result.Account),
+		Arn:         arn,
+		UserName:    userNameFromArn(arn),
+		AccessKeyId: wrapper.AccessKeyId,
+	}, nil
+}
+
+// userNameFromArn extracts the user name from arn:aws:iam::<account>:user/<path>/<name>.
+// Other principals, such as assumed roles, yield an empty name.
+func userNameFromArn(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[2] != "iam" || !strings.HasPrefix(parts[5], "user/") {
+		return ""
+	}
+	resource := parts[5]
+	return resource[strings.LastIndex(resource, "/")+1:]
+}
+
+// RemoveCurrentUser drops the caller's own IAM user from listing or rotation data.
+func RemoveCurrentUser(data []UserData, identity CallerIdentity) []UserData {
+	if identity.UserName == "" {
+		return data
+	}
+
+	var kept []UserData
+	for _, item := range data {
+		var userName string
+		switch user := item.(type) {
+		case UserAccessKeyData:
+			userName = user.UserName
+		case UserLoginData:
+			userName = user.UserName
+		}
+
+		// Rows of other accounts of the organization are never the caller.
+		account := AccountOf(item).AccountId
+		if userName == identity.UserName && (account == "" || account == identity.Account) {
+			log.Infof("Skipping current user %s", identity.UserName)
+			continue
+		}
+		kept = append(kept, item)
+	}
+	return kept
+}
+
+// Backoff between the attempts of VerifyAccessKey.
+const (
+	verifyInitialDelay = time.Second
+	verifyMaxDelay     = 10 * time.Second
+)
+
+// VerifyAccessKey calls GetCallerIdentity with a new access key pair until it
+// succeeds or timeout expires. IAM is eventually consistent, so a key can be
+// rejected for a few seconds after it is created.
+func (wrapper UserWrapper) VerifyAccessKey(accessKeyId, secretAccessKey string, timeout time.Duration) error {
+	if wrapper.KeyStsClient == nil {
+		return fmt.Errorf("no STS client configured to verify access keys")
+	}
+	client := wrapper.KeyStsClient(accessKeyId, secretAccessKey)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), timeout)
+	defer cancel()
+
+	delay := verifyInitialDelay
+	for attempt := 1; ; attempt++ {
+		_, err := client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+		if err == nil {
+			log.Infof("Access key %s verified with STS after %d attempt(s)", accessKeyId, attempt)
+			return nil
+		}
+		log.Debugf("Access key %s not usable yet (attempt %d): %v", accessKeyId, attempt, err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("access key %s was not accepted by STS within %s: %w", accessKeyId, timeout, err)
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, verifyMaxDelay)
+	}
+}
@@ -0,0 +1,48 @@
+package iam
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUserNameFromArn(t *testing.T) {
+	tests := []struct {
+		arn  string
+		want string
+	}{
+		{arn: "arn:aws:iam::111111111111:user/alice", want: "alice"},
+		{arn: "arn:aws:iam::111111111111:user/svc/deploy/ci", want: "ci"},
+		{arn: "arn:aws:sts::111111111111:assumed-role/admin/session"},
+		{arn: "arn:aws:iam::111111111111:root"},
+		{arn: "not an arn"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.arn, func(t *testing.T) {
+			if got := userNameFromArn(test.arn); got != test.want {
+				t.Errorf("userNameFromArn = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRemoveCurrentUser(t *testing.T) {
+	data := []UserData{UserAccessKeyData{UserName: "ops"}, UserLoginData{UserName: "ops"}, UserAccessKeyData{UserName: "ci"}}
+
+	tests := []struct {
+		name     string
+		identity CallerIdentity
+		want     []UserData
+	}{
+		{name: "caller is an IAM user", identity: CallerIdentity{UserName: "ops"}, want: []UserData{UserAccessKeyData{UserName: "ci"}}},
+		{name: "caller is a role", identity: CallerIdentity{Arn: "arn:aws:sts::111111111111:assumed-role/admin/session"}, want: data},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := RemoveCurrentUser(data, test.identity); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("RemoveCurrentUser = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
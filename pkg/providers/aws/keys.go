@@ -2,9 +2,10 @@ package iam
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"slices"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,6 +13,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/iam"
 	"github.com/aws/aws-sdk-go-v2/service/iam/types"
 	"github.com/charmbracelet/log"
+	"github.com/javiercm1410/gyro/pkg/exemptions"
 )
 
 type AccessKeyData struct {
@@ -22,17 +24,32 @@ type AccessKeyData struct {
 	LastUsedService string
 	MatchesCriteria bool
 	IsExpired       bool
+	// Exemption is the exemptions file entry keeping this key out of
+	// rotation.
+	Exemption *exemptions.Exemption
 }
 
 type AccessKeyRotationResult struct {
+	Account
 	UserName        string
 	AccessKeyId     string
 	SecretAccessKey string
+	DeactivatedKeys []string
+	DeletedKeyId    string
+	Error           string
 }
 
 type UserAccessKeyData struct {
+	Account
 	UserName string
 	Keys     []AccessKeyData
+	// Policy is the user's rotation policy, from its gyro:* tags.
+	Policy UserPolicy
+	// LastRotated is when gyro last created an access key for the user, from
+	// the audit log.
+	LastRotated *time.Time
+	// Error is set when the user's keys could not be listed.
+	Error string
 }
 
 // ListAccessKeys fetches access keys for a specific user.
@@ -51,21 +68,30 @@ func (wrapper UserWrapper) ListAccessKeys(userName, timeZone string, expired boo
 		UserName: aws.String(userName),
 	}
 
-	result, err := wrapper.IamClient.ListAccessKeys(context.TODO(), input)
-	if err != nil {
-		log.Errorf("Couldn't list access keys for user %s. Error: %v", userName, err)
-		return UserAccessKeyData{}, err
+	var metadata []types.AccessKeyMetadata
+	for {
+		result, err := wrapper.IamClient.ListAccessKeys(context.TODO(), input)
+		if err != nil {
+			log.Errorf("Couldn't list access keys for user %s. Error: %v", userName, err)
+			return UserAccessKeyData{}, err
+		}
+		metadata = append(metadata, result.AccessKeyMetadata...)
+
+		if !result.IsTruncated || result.Marker == nil {
+			break
+		}
+		input.Marker = result.Marker
 	}
 
-	if len(result.AccessKeyMetadata) == 0 {
+	if len(metadata) == 0 {
 		return UserAccessKeyData{}, nil
 	}
 
-	for _, key := range result.AccessKeyMetadata {
+	for _, key := range metadata {
 		keyData, err := wrapper.getAccessKeyDetails(key, loc, expired, stale)
 		if err != nil {
 			log.Errorf("Couldn't fetch access key details for user %s. Error: %v", userName, err)
-			continue
+			return UserAccessKeyData{}, err
 		}
 		keys = append(keys, keyData)
 		if keyData.MatchesCriteria {
@@ -91,7 +117,7 @@ func (wrapper UserWrapper) getAccessKeyDetails(key types.AccessKeyMetadata, loc
 
 	lastUsed, err := wrapper.IamClient.GetAccessKeyLastUsed(context.TODO(), accessKeyInput)
 	if err != nil {
-		return AccessKeyData{}, errors.New("couldn't get last used data for access key")
+		return AccessKeyData{}, fmt.Errorf("couldn't get last used data for access key %s: %w", *key.AccessKeyId, err)
 	}
 
 	keyData := AccessKeyData{
@@ -107,6 +133,14 @@ func (wrapper UserWrapper) getAccessKeyDetails(key types.AccessKeyMetadata, loc
 		keyData.LastUsedService = "n/a"
 	}
 
+	applyKeyCriteria(&keyData, expired, stale)
+
+	return keyData, nil
+}
+
+// applyKeyCriteria flags keys older than stale days as expired and, when only
+// expired keys are requested, hides the others.
+func applyKeyCriteria(keyData *AccessKeyData, expired bool, stale int) {
 	keyData.MatchesCriteria = true
 	if time.Since(keyData.CreateDate).Hours() > float64(stale*24) {
 		keyData.IsExpired = true
@@ -117,13 +151,164 @@ func (wrapper UserWrapper) getAccessKeyDetails(key types.AccessKeyMetadata, loc
 			keyData.MatchesCriteria = false
 		}
 	}
+}
 
-	return keyData, nil
+// accessKeyPlan holds the decisions taken for one user before any IAM call is
+// made, so dry runs and real rotations share the same selection logic.
+type accessKeyPlan struct {
+	deactivate []AccessKeyData
+	delete     *AccessKeyData
+	create     bool
+	// verify checks the new key with STS before the expired keys are
+	// deactivated.
+	verify bool
 }
 
-// RotateAccessKeys rotates the access keys for the provided users.
-func (wrapper UserWrapper) RotateAccessKeys(keys []UserData, skipConfirmation bool) []UserData {
+// planAccessKeyRotation selects the expired active keys to deactivate, the
+// oldest key to delete when the user already holds two keys, and whether a new
+// key is created. In expire-only mode keys are only deactivated. With
+// verification a user holding two keys is only rotated when its oldest key is
+// already inactive, so no working key is lost before the new one is
+// verified. Unless
+// AllowCurrentKey is set, the key in protectedKeyId is never deactivated or
+// deleted, and neither are exempted keys; users whose stale keys are all
+// exempted get no new key.
+func planAccessKeyRotation(user UserAccessKeyData, inputs RotateWrapperInputs, protectedKeyId string) accessKeyPlan {
+	plan := accessKeyPlan{create: !inputs.ExpireOnly}
+	exempted := 0
+	isProtected := func(key AccessKeyData) bool {
+		return !inputs.AllowCurrentKey && protectedKeyId != "" && *key.Id == protectedKeyId
+	}
+
+	for _, key := range user.Keys {
+		if key.IsExpired && key.KeyStatus == types.StatusTypeActive {
+			if isProtected(key) {
+				log.Warnf("Not deactivating access key %s of user %s: gyro is authenticated with it", *key.Id, user.UserName)
+				continue
+			}
+			if key.Exemption != nil {
+				log.Infof("Not deactivating access key %s of user %s: %s (%s)", *key.Id, user.UserName, key.ExemptionStatus(), key.Exemption.Reason)
+				exempted++
+				continue
+			}
+			plan.deactivate = append(plan.deactivate, key)
+		}
+	}
+
+	if exempted > 0 && len(plan.deactivate) == 0 {
+		// The exempted keys stay in use, a replacement would be left idle.
+		plan.create = false
+		return plan
+	}
+
+	if inputs.ExpireOnly {
+		return plan
+	}
+
+	if len(user.Keys) >= 2 {
+		// Find oldest key
+		oldestKey := user.Keys[0]
+		for _, k := range user.Keys {
+			if k.CreateDate.Before(oldestKey.CreateDate) {
+				oldestKey = k
+			}
+		}
+		if isProtected(oldestKey) {
+			// IAM allows two keys per user, so no replacement can be created
+			// without deleting the key currently in use.
+			log.Warnf("Not rotating user %s: the oldest access key %s is the one gyro is authenticated with", user.UserName, *oldestKey.Id)
+			plan.create = false
+			return plan
+		}
+		if oldestKey.Exemption != nil {
+			log.Infof("Not rotating user %s: the oldest access key %s is %s", user.UserName, *oldestKey.Id, oldestKey.ExemptionStatus())
+			plan.create = false
+			return plan
+		}
+		if inputs.VerifyTimeout > 0 && oldestKey.KeyStatus == types.StatusTypeActive {
+			// Inactive keys count towards the quota too, so the only room for
+			// the new key is made by deleting a working one before the new
+			// key is verified.
+			log.Warnf("Not rotating user %s with --verify-key: it has two access keys and the active key %s would be deleted before the new key is verified; delete or deactivate a key first, or rotate without --verify-key", user.UserName, *oldestKey.Id)
+			return accessKeyPlan{}
+		}
+		plan.delete = &oldestKey
+	}
+
+	plan.verify = inputs.VerifyTimeout > 0
+	return plan
+}
+
+// planEntries renders a plan as the rows shown by a dry run, in the order
+// the steps run, see steps.
+func (plan accessKeyPlan) planEntries(userName string) []UserData {
+	var entries []UserData
+	for _, step := range plan.steps() {
+		entry := RotationPlanEntry{UserName: userName, Action: step.action}
+		switch step.action {
+		case ActionDeactivateKey:
+			entry.AccessKeyId = *step.key.Id
+			entry.Detail = fmt.Sprintf("expired, created %s", step.key.CreateDate.Format(time.DateOnly))
+		case ActionDeleteKey:
+			entry.AccessKeyId = *step.key.Id
+			entry.Detail = fmt.Sprintf("oldest of 2 keys, created %s", step.key.CreateDate.Format(time.DateOnly))
+		case ActionCreateKey:
+			entry.Detail = "new access key"
+		case ActionVerifyKey:
+			entry.Detail = "new key accepted by STS, else deleted"
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+type plannedStep struct {
+	action string
+	key    *AccessKeyData
+}
+
+// steps orders the plan. Expired keys are deactivated first, then the oldest
+// key is deleted to make room and the new key is created. With verify the
+// oldest key, which is then inactive, is deleted when IAM needs room, the new
+// key is created and verified, and the expired keys are only deactivated once
+// it works.
+func (plan accessKeyPlan) steps() []plannedStep {
+	var deactivate, replace []plannedStep
+	for _, key := range plan.deactivate {
+		deactivate = append(deactivate, plannedStep{action: ActionDeactivateKey, key: &key})
+	}
+	if plan.delete != nil {
+		replace = append(replace, plannedStep{action: ActionDeleteKey, key: plan.delete})
+	}
+	if plan.create {
+		replace = append(replace, plannedStep{action: ActionCreateKey})
+		if plan.verify {
+			replace = append(replace, plannedStep{action: ActionVerifyKey})
+		}
+	}
+
+	if plan.verify && plan.create {
+		return append(replace, deactivate...)
+	}
+	return append(deactivate, replace...)
+}
+
+// RotateAccessKeys rotates the access keys for the provided users. Users whose
+// rotation fails are reported with the Error field set. With DryRun set no
+// IAM call is made and the planned actions are returned instead. Otherwise,
+// when StateDir is set, the plan and the progress of every user are saved as
+// a run that ResumeRotation can continue after an interruption.
+func (wrapper UserWrapper) RotateAccessKeys(keys []UserData, inputs RotateWrapperInputs) []UserData {
 	var results []UserData
+	var run *RotationRun
+	if !inputs.DryRun && inputs.StateDir != "" {
+		var err error
+		if run, err = newRotationRun(inputs.StateDir, wrapper.Account.AccountId); err != nil {
+			log.Errorf("Rotation state is not saved: %v", err)
+		}
+	}
+
+	var planned []RunUser
 	for _, keyData := range keys {
 		user, ok := keyData.(UserAccessKeyData)
 		if !ok {
@@ -131,150 +316,193 @@ func (wrapper UserWrapper) RotateAccessKeys(keys []UserData, skipConfirmation bo
 			continue
 		}
 
-		// Check for expired active keys and prompt for deactivation
-		for _, key := range user.Keys {
-			if key.IsExpired && key.KeyStatus == types.StatusTypeActive {
-				shouldDeactivate := skipConfirmation
-				if !skipConfirmation {
-					fmt.Printf("User %s has an expired active access key (%s). Do you want to deactivate it? (y/n): ", user.UserName, *key.Id)
-					var response string
-					fmt.Scanln(&response)
-					if response == "y" {
-						shouldDeactivate = true
-					}
-				}
-
-				if shouldDeactivate {
-					updateInput := &iam.UpdateAccessKeyInput{
-						UserName:    aws.String(user.UserName),
-						AccessKeyId: key.Id,
-						Status:      types.StatusTypeInactive,
-					}
-					_, err := wrapper.IamClient.UpdateAccessKey(context.TODO(), updateInput)
-					if err != nil {
-						log.Errorf("Failed to deactivate access key %s: %v", *key.Id, err)
-					} else {
-						log.Infof("Successfully deactivated access key %s", *key.Id)
-					}
-				}
-			}
+		if user.Error != "" {
+			log.Warnf("Skipping user %s, its access keys could not be listed: %s", user.UserName, user.Error)
+			continue
 		}
 
-		if len(user.Keys) >= 2 {
-			// Find oldest key
-			oldestKey := user.Keys[0]
-			for _, k := range user.Keys {
-				if k.CreateDate.Before(oldestKey.CreateDate) {
-					oldestKey = k
-				}
-			}
-
-			if !skipConfirmation {
-				fmt.Printf("User %s has 2 access keys. Do you want to delete the oldest key (%s created on %s)? (y/n): ", user.UserName, *oldestKey.Id, oldestKey.CreateDate)
-				var response string
-				fmt.Scanln(&response)
-				if response != "y" {
-					log.Warnf("Skipping rotation for user %s as they have 2 keys", user.UserName)
-					continue
-				}
-			}
+		if user.UserName == RootAccountName {
+			log.Warn("Skipping the root account, its access keys are not rotated by gyro")
+			continue
+		}
 
-			// Delete key
-			deleteInput := &iam.DeleteAccessKeyInput{
-				UserName:    aws.String(user.UserName),
-				AccessKeyId: oldestKey.Id,
-			}
-			_, err := wrapper.IamClient.DeleteAccessKey(context.TODO(), deleteInput)
-			if err != nil {
-				log.Errorf("Failed to delete access key %s for user %s: %v", *oldestKey.Id, user.UserName, err)
-				continue
-			}
-			log.Infof("Successfully deleted access key %s for user %s", *oldestKey.Id, user.UserName)
+		if user.Policy.Exempt {
+			log.Infof("Skipping user %s: %s", user.UserName, user.Policy.ExemptionStatus())
+			continue
 		}
 
-		// Create new key
-		createInput := &iam.CreateAccessKeyInput{
-			UserName: aws.String(user.UserName),
+		plan := planAccessKeyRotation(user, inputs, wrapper.AccessKeyId)
+		if len(plan.deactivate) == 0 && plan.delete == nil && !plan.create {
+			log.Debugf("Nothing to expire for user %s", user.UserName)
+			continue
 		}
-		createOutput, err := wrapper.IamClient.CreateAccessKey(context.TODO(), createInput)
-		if err != nil {
-			log.Errorf("Failed to create access key for user %s: %v", user.UserName, err)
+
+		if inputs.DryRun {
+			results = append(results, plan.planEntries(user.UserName)...)
 			continue
 		}
+		planned = append(planned, runUserFromPlan(user, plan))
+	}
 
-		log.Infof("Successfully rotated access key for user: %s", user.UserName)
-		log.Infof("Access Key ID: %s", *createOutput.AccessKey.AccessKeyId)
-		log.Infof("Secret Access Key: %s", *createOutput.AccessKey.SecretAccessKey)
+	if inputs.DryRun || len(planned) == 0 {
+		return results
+	}
 
-		results = append(results, AccessKeyRotationResult{
-			UserName:        user.UserName,
-			AccessKeyId:     *createOutput.AccessKey.AccessKeyId,
-			SecretAccessKey: *createOutput.AccessKey.SecretAccessKey,
-		})
+	if run == nil {
+		run = &RotationRun{}
+	} else {
+		log.Infof("Rotation run %s, state saved to %s", run.RunId, run.Path())
+	}
+	run.Users = planned
+	run.save()
+
+	for i := range run.Users {
+		user := &run.Users[i]
+		if !inputs.SkipConfirmation {
+			if declined := confirmRunUser(user); declined != "" {
+				log.Warnf("Not rotating user %s as planned: %s", user.UserName, declined)
+			}
+		}
+		if !slices.ContainsFunc(user.Steps, func(step RunStep) bool { return step.Status != StepSkipped }) {
+			user.Status = RunCompleted
+			run.save()
+			continue
+		}
+		results = append(results, wrapper.executeRunUser(run, user, inputs.VerifyTimeout))
 	}
 
+	if run.path != "" && run.Pending() {
+		log.Warnf("Some users were not rotated, retry them with: gyro rotate resume %s", run.RunId)
+	}
 	return results
 }
 
-func GetUserAccessKey(input GetWrapperInputs) []UserData {
+// confirmRunUser prompts for every step of the user's rotation and marks the
+// declined ones skipped. Declining the deletion of the oldest key leaves no
+// room for a new one, so it skips the creation too. It returns what was
+// declined, or "" when every step was confirmed.
+func confirmRunUser(user *RunUser) string {
+	var declined []string
+	for i := range user.Steps {
+		step := &user.Steps[i]
+		var response string
+		switch step.Action {
+		case ActionDeactivateKey:
+			fmt.Printf("User %s has an expired active access key (%s). Do you want to deactivate it? (y/n): ", user.UserName, step.AccessKeyId)
+		case ActionDeleteKey:
+			fmt.Printf("User %s has 2 access keys. Do you want to delete the oldest key (%s)? (y/n): ", user.UserName, step.AccessKeyId)
+		default:
+			continue
+		}
+		fmt.Scanln(&response)
+		if response == "y" {
+			continue
+		}
+		step.Status = StepSkipped
+		if step.Action == ActionDeactivateKey {
+			declined = append(declined, fmt.Sprintf("deactivating access key %s was declined", step.AccessKeyId))
+			continue
+		}
+		for j := i + 1; j < len(user.Steps); j++ {
+			user.Steps[j].Status = StepSkipped
+		}
+		declined = append(declined, fmt.Sprintf("deleting the oldest access key %s was declined, leaving no room for a new key", step.AccessKeyId))
+		break
+	}
+	return strings.Join(declined, "; ")
+}
+
+// userAccessKeys lists the access keys of a user, judging their age by the
+// user's tag policy and marking the exempted ones.
+func (input GetWrapperInputs) userAccessKeys(userName string) (UserAccessKeyData, error) {
+	policy, err := input.userPolicy(userName)
+	if err != nil {
+		return UserAccessKeyData{}, err
+	}
+	keyData, err := input.Client.ListAccessKeys(userName, input.TimeZone, input.Expired, policy.MaxAge)
+	if err != nil {
+		return UserAccessKeyData{}, err
+	}
+	keyData.Policy = policy
+	input.exemptKeys(&keyData)
+	return keyData, nil
+}
+
+// GetUserAccessKey lists the access keys of the selected users. Users whose
+// keys could not be listed are returned with Error set. With a single
+// --username, a missing user is returned as ErrUserNotFound.
+func GetUserAccessKey(input GetWrapperInputs) ([]UserData, error) {
 	var usersData []types.User
 	var err error
 
+	if len(input.Accounts) > 0 {
+		return input.forEachAccount(GetUserAccessKey)
+	}
+
+	if input.CredentialReport || input.ReportFile != "" {
+		rows, err := input.credentialReportRows()
+		if err != nil {
+			return nil, err
+		}
+		return ReportAccessKeys(rows, input)
+	}
+
 	if input.UserName != "" {
-		usersData = []types.User{{UserName: aws.String(input.UserName)}}
-	} else {
-		usersData, err = input.Client.ListUsers(input.MaxUsers)
+		// Without filters the user is not looked up, ListAccessKeys reports
+		// a missing one.
+		if input.Filter.isSet() || len(input.Exclude) > 0 {
+			selectedUser, err := input.Client.IamClient.GetUser(context.TODO(), &iam.GetUserInput{UserName: aws.String(input.UserName)})
+			if err != nil {
+				return nil, fmt.Errorf("user %s: %w", input.UserName, classifyError(err, ErrUserNotFound))
+			}
+			if kept, err := input.keepNamedUser(*selectedUser.User); err != nil || !kept {
+				return nil, err
+			}
+		}
+		keyData, err := input.userAccessKeys(input.UserName)
 		if err != nil {
-			log.Fatalf("Failed to get users: %v", err)
+			return nil, fmt.Errorf("user %s: %w", input.UserName, classifyError(err, ErrUserNotFound))
 		}
+		if keyData.Keys == nil {
+			return nil, nil
+		}
+		return []UserData{keyData}, nil
+	}
+
+	selector, err := newUserSelector(input)
+	if err != nil {
+		return nil, err
+	}
+	usersData, err = input.Client.ListUsers(input.MaxUsers, input.Filter.PathPrefix, selector.keep)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
 	}
 
 	var (
 		userKeyData []UserData
 		mu          sync.Mutex
-		wg          sync.WaitGroup
-		errors      []error
 	)
 
-	wg.Add(len(usersData))
-
-	for _, user := range usersData {
-		go func(user types.User) {
-			defer wg.Done()
-			keyData, err := input.Client.ListAccessKeys(*user.UserName, input.TimeZone, input.Expired, input.Age)
-			if err != nil {
-				log.Errorf("Couldn't list access keys for user %s: %v", *user.UserName, err)
-				mu.Lock()
-				errors = append(errors, err)
-				mu.Unlock()
-				return
+	ForEachUser(usersData, input.Concurrency, func(user types.User) {
+		keyData, err := input.userAccessKeys(*user.UserName)
+		if err != nil {
+			// Report the failure in the output instead of dropping the user.
+			keyData = UserAccessKeyData{
+				UserName: *user.UserName,
+				Error:    classifyError(err, ErrUserNotFound).Error(),
 			}
+		}
 
+		if keyData.Keys != nil || keyData.Error != "" {
 			mu.Lock()
-			if keyData.Keys != nil {
-				if input.Expired {
-					userKeyData = append(userKeyData, keyData)
-				} else {
-					userKeyData = append(userKeyData, keyData)
-				}
-			}
+			userKeyData = append(userKeyData, keyData)
 			mu.Unlock()
-		}(user)
-	}
-
-	wg.Wait()
-	if len(errors) > 0 {
-		for _, err := range errors {
-			log.Errorf("Failed to get user: %v", err)
 		}
-	}
+	})
 
-	//This work if commented, we can leave like that but let's see why
-	// if input.UserName != "" {
 	sort.Slice(userKeyData, func(i, j int) bool {
 		return userKeyData[j].(UserAccessKeyData).UserName > userKeyData[i].(UserAccessKeyData).UserName
 	})
-	// }
 
-	return userKeyData
+	return userKeyData, nil
 }
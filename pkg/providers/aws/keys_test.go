@@ -0,0 +1,214 @@
+package iam
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/javiercm1410/gyro/pkg/exemptions"
+	"github.com/javiercm1410/gyro/pkg/providers/aws/fakeiam"
+)
+
+// planKey is an active access key of a planner test.
+func planKey(id, created string, expired bool) AccessKeyData {
+	createDate, err := time.Parse(time.DateOnly, created)
+	if err != nil {
+		panic(err)
+	}
+	return AccessKeyData{Id: aws.String(id), CreateDate: createDate, KeyStatus: types.StatusTypeActive, IsExpired: expired}
+}
+
+// stepNames renders the steps of a plan as "action key" strings.
+func stepNames(plan accessKeyPlan) []string {
+	var names []string
+	for _, step := range plan.steps() {
+		name := step.action
+		if step.key != nil {
+			name += " " + *step.key.Id
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestPlanAccessKeyRotation(t *testing.T) {
+	exempted := planKey("AKIAEXEMPT", "2022-01-01", true)
+	exempted.Exemption = &exemptions.Exemption{User: "alice", AccessKeyId: "AKIAEXEMPT", Reason: "legacy job"}
+
+	tests := []struct {
+		name      string
+		keys      []AccessKeyData
+		inputs    RotateWrapperInputs
+		protected string
+		want      []string
+	}{
+		{
+			name: "one expired key",
+			keys: []AccessKeyData{planKey("AKIAOLD", "2022-01-01", true)},
+			want: []string{"deactivate-key AKIAOLD", "create-key"},
+		},
+		{
+			name: "two keys, the oldest is deleted to make room",
+			keys: []AccessKeyData{planKey("AKIANEW", "2026-01-01", false), planKey("AKIAOLD", "2022-01-01", true)},
+			want: []string{"deactivate-key AKIAOLD", "delete-key AKIAOLD", "create-key"},
+		},
+		{
+			name: "two expired keys",
+			keys: []AccessKeyData{planKey("AKIAOLD", "2022-01-01", true), planKey("AKIAMID", "2023-01-01", true)},
+			want: []string{"deactivate-key AKIAOLD", "deactivate-key AKIAMID", "delete-key AKIAOLD", "create-key"},
+		},
+		{
+			name:   "verify before deactivating",
+			keys:   []AccessKeyData{planKey("AKIAOLD", "2022-01-01", true)},
+			inputs: RotateWrapperInputs{VerifyTimeout: time.Minute},
+			want:   []string{"create-key", "verify-key", "deactivate-key AKIAOLD"},
+		},
+		{
+			name:   "verify with two active keys is refused",
+			keys:   []AccessKeyData{planKey("AKIANEW", "2026-01-01", false), planKey("AKIAOLD", "2022-01-01", true)},
+			inputs: RotateWrapperInputs{VerifyTimeout: time.Minute},
+			want:   nil,
+		},
+		{
+			name: "verify deletes an inactive oldest key",
+			keys: []AccessKeyData{
+				{Id: aws.String("AKIAOFF"), CreateDate: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), KeyStatus: types.StatusTypeInactive, IsExpired: true},
+				planKey("AKIAOLD", "2022-01-01", true),
+			},
+			inputs: RotateWrapperInputs{VerifyTimeout: time.Minute},
+			want:   []string{"delete-key AKIAOFF", "create-key", "verify-key", "deactivate-key AKIAOLD"},
+		},
+		{
+			name:   "expire only",
+			keys:   []AccessKeyData{planKey("AKIAOLD", "2022-01-01", true), planKey("AKIANEW", "2026-01-01", false)},
+			inputs: RotateWrapperInputs{ExpireOnly: true},
+			want:   []string{"deactivate-key AKIAOLD"},
+		},
+		{
+			name: "inactive expired key is not deactivated again",
+			keys: []AccessKeyData{
+				{Id: aws.String("AKIAOFF"), CreateDate: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), KeyStatus: types.StatusTypeInactive, IsExpired: true},
+			},
+			want: []string{"create-key"},
+		},
+		{
+			name:      "current key is kept",
+			keys:      []AccessKeyData{planKey("AKIACALLER", "2022-01-01", true)},
+			protected: "AKIACALLER",
+			want:      []string{"create-key"},
+		},
+		{
+			name:      "current key is the oldest of two",
+			keys:      []AccessKeyData{planKey("AKIACALLER", "2022-01-01", true), planKey("AKIAMID", "2023-01-01", true)},
+			protected: "AKIACALLER",
+			want:      []string{"deactivate-key AKIAMID"},
+		},
+		{
+			name:      "current key with --allow-current-key",
+			keys:      []AccessKeyData{planKey("AKIACALLER", "2022-01-01", true)},
+			inputs:    RotateWrapperInputs{AllowCurrentKey: true},
+			protected: "AKIACALLER",
+			want:      []string{"deactivate-key AKIACALLER", "create-key"},
+		},
+		{
+			name: "exempted key gets no replacement",
+			keys: []AccessKeyData{exempted},
+			want: nil,
+		},
+		{
+			name: "exempted oldest key of two",
+			keys: []AccessKeyData{exempted, planKey("AKIAMID", "2023-01-01", true)},
+			want: []string{"deactivate-key AKIAMID"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			user := UserAccessKeyData{UserName: "alice", Keys: test.keys}
+			plan := planAccessKeyRotation(user, test.inputs, test.protected)
+			if got := stepNames(plan); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("steps = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRotateAccessKeysDryRun(t *testing.T) {
+	wrapper, client := fakeWrapper(t, fakeiam.Fixture{
+		Users: []fakeiam.FixtureUser{
+			{UserName: "alice", AccessKeys: []fakeiam.FixtureAccessKey{fixtureKey("AKIAOLD", "2022-01-01"), fixtureKey("AKIAMID", "2023-01-01")}},
+		},
+	})
+
+	inputs := RotateWrapperInputs{
+		GetWrapperInputs: GetWrapperInputs{Age: 90, TimeZone: "UTC", Client: wrapper},
+		DryRun:           true,
+	}
+	keys, err := GetUserAccessKey(inputs.GetWrapperInputs)
+	if err != nil {
+		t.Fatalf("GetUserAccessKey: %v", err)
+	}
+	results := wrapper.RotateAccessKeys(keys, inputs)
+	if len(results) != 4 {
+		t.Errorf("got %d plan entries, want 4", len(results))
+	}
+	for _, operation := range []string{"CreateAccessKey", "UpdateAccessKey", "DeleteAccessKey"} {
+		if calls := client.Calls(operation); calls != 0 {
+			t.Errorf("dry run made %d %s calls", calls, operation)
+		}
+	}
+	if keys := client.AccessKeys("alice"); len(keys) != 2 || keys[0].Status != types.StatusTypeActive || keys[1].Status != types.StatusTypeActive {
+		t.Errorf("keys after dry run = %+v, want both unchanged", keys)
+	}
+}
+
+func TestRotateAccessKeysProtectsCallerKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		allow       bool
+		wantCaller  types.StatusType
+		wantCreated int
+	}{
+		{name: "protected by default", wantCaller: types.StatusTypeActive, wantCreated: 2},
+		{name: "with --allow-current-key", allow: true, wantCaller: types.StatusTypeInactive, wantCreated: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wrapper, client := fakeWrapper(t, fakeiam.Fixture{
+				Caller: &fakeiam.FixtureCaller{UserName: "ops", AccessKeyId: "AKIACALLER"},
+				Users: []fakeiam.FixtureUser{
+					{UserName: "ops", AccessKeys: []fakeiam.FixtureAccessKey{fixtureKey("AKIACALLER", "2022-01-01")}},
+					{UserName: "ci", AccessKeys: []fakeiam.FixtureAccessKey{fixtureKey("AKIACI", "2022-01-01")}},
+				},
+			})
+
+			inputs := RotateWrapperInputs{
+				GetWrapperInputs: GetWrapperInputs{Age: 90, TimeZone: "UTC", Client: wrapper},
+				SkipConfirmation: true,
+				AllowCurrentKey:  test.allow,
+			}
+			keys, err := GetUserAccessKey(inputs.GetWrapperInputs)
+			if err != nil {
+				t.Fatalf("GetUserAccessKey: %v", err)
+			}
+			if err := CheckResults(wrapper.RotateAccessKeys(keys, inputs)); err != nil {
+				t.Fatalf("RotateAccessKeys: %v", err)
+			}
+
+			for _, key := range client.AccessKeys("ops") {
+				if key.AccessKeyId == "AKIACALLER" && key.Status != test.wantCaller {
+					t.Errorf("caller key status = %s, want %s", key.Status, test.wantCaller)
+				}
+			}
+			if status := client.AccessKeys("ci")[0].Status; status != types.StatusTypeInactive {
+				t.Errorf("expired key of ci status = %s, want Inactive", status)
+			}
+			if calls := client.Calls("CreateAccessKey"); calls != test.wantCreated {
+				t.Errorf("CreateAccessKey calls = %d, want %d", calls, test.wantCreated)
+			}
+		})
+	}
+}
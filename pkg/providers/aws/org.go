@@ -0,0 +1,404 @@
+package iam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	orgtypes "github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/charmbracelet/log"
+	"github.com/javiercm1410/gyro/pkg/providers/aws/fakeiam"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultOrgRole is the role AWS Organizations creates in the accounts it
+// vends, and the one assumed by default.
+const DefaultOrgRole = "OrganizationAccountAccessRole"
+
+// Account identifies the account a row was read from. It is only set when
+// several accounts are scanned, so single-account output is unchanged.
+type Account struct {
+	AccountId    string `json:",omitempty"`
+	AccountAlias string `json:",omitempty"`
+	// name is the Organizations name of the account, used when it has no
+	// IAM alias.
+	name string
+}
+
+// OrgOptions selects the accounts of a multi-account run and how gyro gets
+// into them.
+type OrgOptions struct {
+	// AccountsFile lists the accounts instead of Organizations ListAccounts.
+	AccountsFile string
+	// Role is the name of the role assumed in every account.
+	Role string
+}
+
+// accountsFile is the layout of OrgOptions.AccountsFile.
+type accountsFile struct {
+	Accounts []struct {
+		Id   string `yaml:"id"`
+		Name string `yaml:"name"`
+	} `yaml:"accounts"`
+}
+
+// DeclareOrgConfig returns a client for every active account of the
+// organization, or of the accounts file, acting through a role assumed with
+// the credentials of wrapper. The roles are assumed on first use, so an
+// account gyro cannot get into fails on its own in ForEachAccount. With
+// GYRO_FAKE_IAM_FIXTURE the accounts of the fixture are simulated instead.
+func (wrapper UserWrapper) DeclareOrgConfig(options OrgOptions) ([]UserWrapper, error) {
+	return wrapper.declareAccounts(options, nil)
+}
+
+// DeclareAccountConfig returns the client of one account, as
+// DeclareOrgConfig would, without listing the organization.
+func (wrapper UserWrapper) DeclareAccountConfig(options OrgOptions, accountId string) (UserWrapper, error) {
+	options.AccountsFile = ""
+	clients, err := wrapper.declareAccounts(options, []Account{{AccountId: accountId}})
+	if err != nil {
+		return UserWrapper{}, err
+	}
+	return clients[0], nil
+}
+
+// declareAccounts builds the clients of accounts, or of the accounts listed
+// by options when it is nil.
+func (wrapper UserWrapper) declareAccounts(options OrgOptions, accounts []Account) ([]UserWrapper, error) {
+	if options.Role == "" {
+		options.Role = DefaultOrgRole
+	}
+
+	var err error
+	if accounts == nil && options.AccountsFile != "" {
+		if accounts, err = readAccountsFile(options.AccountsFile); err != nil {
+			return nil, err
+		}
+	}
+
+	identity, err := wrapper.GetCallerIdentity()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get caller identity: %w", err)
+	}
+
+	var clients []UserWrapper
+	if fixture := os.Getenv(FakeFixtureEnv); fixture != "" {
+		clients, err = fakeOrgAccounts(fixture, accounts)
+	} else {
+		clients, err = wrapper.assumeAccounts(options, accounts, identity)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The role session in the caller's own account still acts on the key
+	// gyro is authenticated with, so rotation must keep protecting it there.
+	for i := range clients {
+		if clients[i].Account.AccountId == identity.Account {
+			clients[i].AccessKeyId = wrapper.AccessKeyId
+		}
+	}
+	return clients, nil
+}
+
+// assumeAccounts builds the clients of accounts, or of every account of the
+// organization when it is nil, through the role of options.
+func (wrapper UserWrapper) assumeAccounts(options OrgOptions, accounts []Account, identity CallerIdentity) ([]UserWrapper, error) {
+	sdkConfig := wrapper.sdkConfig
+	if sdkConfig.Credentials == nil {
+		return nil, fmt.Errorf("%w: no AWS credentials configured", ErrAuth)
+	}
+
+	if accounts == nil {
+		var err error
+		if accounts, err = listOrgAccounts(organizations.NewFromConfig(sdkConfig)); err != nil {
+			return nil, err
+		}
+	}
+	partition := strings.Split(identity.Arn, ":")[1]
+
+	stsClient := sts.NewFromConfig(sdkConfig)
+	clients := make([]UserWrapper, 0, len(accounts))
+	for _, account := range accounts {
+		roleArn := fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, account.AccountId, options.Role)
+		accountConfig := sdkConfig.Copy()
+		accountConfig.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(stsClient, roleArn, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = roleSessionName
+		}))
+
+		client := newUserWrapper(accountConfig)
+		client.Account = account
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// listOrgAccounts returns the active accounts of the organization.
+func listOrgAccounts(client *organizations.Client) ([]Account, error) {
+	var accounts []Account
+	paginator := organizations.NewListAccountsPaginator(client, &organizations.ListAccountsInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, fmt.Errorf("couldn't list organization accounts: %w", classifyError(err, nil))
+		}
+		for _, account := range page.Accounts {
+			if account.Status != orgtypes.AccountStatusActive {
+				continue
+			}
+			accounts = append(accounts, Account{AccountId: aws.ToString(account.Id), name: aws.ToString(account.Name)})
+		}
+	}
+	if len(accounts) == 0 {
+		return nil, fmt.Errorf("the organization has no active accounts")
+	}
+	return accounts, nil
+}
+
+// readAccountsFile reads the accounts of a YAML file in the form
+//
+//	accounts:
+//	  - id: "123456789012"
+//	    name: production
+func readAccountsFile(path string) ([]Account, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading accounts file %s: %w", path, err)
+	}
+
+	var file accountsFile
+	if err := yaml.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("error parsing accounts file %s: %w", path, err)
+	}
+	if len(file.Accounts) == 0 {
+		return nil, fmt.Errorf("accounts file %s lists no accounts", path)
+	}
+
+	accounts := make([]Account, 0, len(file.Accounts))
+	for i, account := range file.Accounts {
+		if account.Id == "" {
+			return nil, fmt.Errorf("accounts file %s: account %d has no id", path, i+1)
+		}
+		accounts = append(accounts, Account{AccountId: account.Id, name: account.Name})
+	}
+	return accounts, nil
+}
+
+// fakeOrgAccounts simulates the accounts of a fixture, keeping only the
+// listed ones unless listed is nil.
+func fakeOrgAccounts(fixture string, listed []Account) ([]UserWrapper, error) {
+	fakeAccounts, err := fakeiam.LoadAccounts(fixture)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load fake IAM fixture: %w", err)
+	}
+	log.Warnf("Using simulated organization from %s", fixture)
+
+	clients := make([]UserWrapper, 0, len(fakeAccounts))
+	for _, fakeAccount := range fakeAccounts {
+		if listed != nil && !slices.ContainsFunc(listed, func(account Account) bool {
+			return account.AccountId == fakeAccount.Id
+		}) {
+			continue
+		}
+		client := fakeUserWrapper(fakeAccount.Client)
+		// Assumed roles are not authenticated with an access key, see
+		// declareAccounts for the caller's own account.
+		client.AccessKeyId = ""
+		client.Account = Account{AccountId: fakeAccount.Id, name: fakeAccount.Name}
+		clients = append(clients, client)
+	}
+	if len(clients) == 0 {
+		return nil, fmt.Errorf("none of the listed accounts is in fixture %s", fixture)
+	}
+	return clients, nil
+}
+
+// resolveAlias sets the alias of the account from its IAM account alias, or
+// from its Organizations name when it has none. It is the first call made in
+// the account, so it also reports when the role cannot be assumed.
+func (wrapper *UserWrapper) resolveAlias() error {
+	if wrapper.Account.AccountAlias != "" {
+		return nil
+	}
+	output, err := wrapper.IamClient.ListAccountAliases(context.TODO(), &iam.ListAccountAliasesInput{})
+	if err != nil {
+		return classifyError(err, nil)
+	}
+	wrapper.Account.AccountAlias = wrapper.Account.name
+	if len(output.AccountAliases) > 0 {
+		wrapper.Account.AccountAlias = output.AccountAliases[0]
+	}
+	return nil
+}
+
+// ForEachAccount runs fn in every account, at most concurrency at a time,
+// and returns the rows of all of them tagged with their account, in account
+// order. The accounts where fn fails are logged and skipped; the returned
+// error wraps ErrPartialFailure, or joins every error when all accounts
+// failed.
+func ForEachAccount(accounts []UserWrapper, concurrency int, fn func(client UserWrapper) ([]UserData, error)) ([]UserData, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([][]UserData, len(accounts))
+	errs := make([]error, len(accounts))
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range accounts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			account := &accounts[i]
+			err := account.resolveAlias()
+			if err == nil {
+				results[i], err = fn(*account)
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("account %s: %w", account.Account.AccountId, err)
+				return
+			}
+			results[i] = WithAccount(results[i], account.Account)
+		}(i)
+	}
+	wg.Wait()
+
+	var data []UserData
+	var failures []error
+	for i := range accounts {
+		if errs[i] != nil {
+			log.Error("Failed to process account", "error", errs[i])
+			failures = append(failures, errs[i])
+			continue
+		}
+		data = append(data, results[i]...)
+	}
+
+	switch {
+	case len(failures) == 0:
+		return data, nil
+	case len(failures) == len(accounts):
+		return nil, errors.Join(failures...)
+	default:
+		return data, fmt.Errorf("%w: %d of %d accounts failed", ErrPartialFailure, len(failures), len(accounts))
+	}
+}
+
+// WithAccount tags every row of data with account.
+func WithAccount(data []UserData, account Account) []UserData {
+	for i, item := range data {
+		switch row := item.(type) {
+		case UserAccessKeyData:
+			row.Account = account
+			data[i] = row
+		case UserLoginData:
+			row.Account = account
+			data[i] = row
+		case AccessKeyRotationResult:
+			row.Account = account
+			data[i] = row
+		case LoginProfileRotationResult:
+			row.Account = account
+			data[i] = row
+		case StagedRotationResult:
+			row.Account = account
+			data[i] = row
+		case RollbackResult:
+			row.Account = account
+			data[i] = row
+		case RotationPlanEntry:
+			row.Account = account
+			data[i] = row
+		}
+	}
+	return data
+}
+
+// AccountOf returns the account a row was tagged with by WithAccount.
+func AccountOf(item UserData) Account {
+	switch row := item.(type) {
+	case UserAccessKeyData:
+		return row.Account
+	case UserLoginData:
+		return row.Account
+	case AccessKeyRotationResult:
+		return row.Account
+	case LoginProfileRotationResult:
+		return row.Account
+	case StagedRotationResult:
+		return row.Account
+	case RollbackResult:
+		return row.Account
+	case RotationPlanEntry:
+		return row.Account
+	}
+	return Account{}
+}
+
+// forEachAccount runs get in every account of input.Accounts. A user named
+// with UserName only has to exist in one of them.
+func (input GetWrapperInputs) forEachAccount(get func(GetWrapperInputs) ([]UserData, error)) ([]UserData, error) {
+	data, err := ForEachAccount(input.Accounts, input.AccountConcurrency, func(client UserWrapper) ([]UserData, error) {
+		accountInput := input
+		accountInput.Accounts = nil
+		accountInput.Client = client
+		data, err := get(accountInput)
+		if input.UserName != "" && errors.Is(err, ErrUserNotFound) {
+			return nil, nil
+		}
+		return data, err
+	})
+	if err == nil && input.UserName != "" && len(data) == 0 {
+		return nil, fmt.Errorf("user %s: %w in any account", input.UserName, ErrUserNotFound)
+	}
+	return data, err
+}
+
+// RotateInAccounts runs rotate with the client of the account every row was
+// read from, or with inputs.Client when inputs.Accounts is empty. Accounts
+// are rotated one at a time unless no confirmation is asked, so prompts do
+// not interleave.
+func RotateInAccounts(data []UserData, inputs RotateWrapperInputs, rotate func(UserWrapper, []UserData, RotateWrapperInputs) []UserData) ([]UserData, error) {
+	if len(inputs.Accounts) == 0 {
+		return rotate(inputs.Client, data, inputs), nil
+	}
+
+	concurrency := 1
+	if inputs.SkipConfirmation || inputs.DryRun {
+		concurrency = inputs.AccountConcurrency
+	}
+
+	var accounts []UserWrapper
+	for _, account := range inputs.Accounts {
+		if slices.ContainsFunc(data, func(item UserData) bool {
+			return AccountOf(item).AccountId == account.Account.AccountId
+		}) {
+			accounts = append(accounts, account)
+		}
+	}
+
+	return ForEachAccount(accounts, concurrency, func(client UserWrapper) ([]UserData, error) {
+		var rows []UserData
+		for _, item := range data {
+			if AccountOf(item).AccountId == client.Account.AccountId {
+				rows = append(rows, item)
+			}
+		}
+		accountInputs := inputs
+		accountInputs.Accounts = nil
+		accountInputs.Client = client
+		return rotate(client, rows, accountInputs), nil
+	})
+}
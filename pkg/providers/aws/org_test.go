@@ -0,0 +1,63 @@
+package iam
+
+import (
+	"testing"
+
+	"github.com/javiercm1410/gyro/pkg/providers/aws/fakeiam"
+)
+
+func TestOrgRotationProtectsCallerKey(t *testing.T) {
+	fixture := fakeiam.Fixture{
+		Caller: &fakeiam.FixtureCaller{UserName: "ops", AccessKeyId: "AKIACALLER"},
+		Accounts: []fakeiam.FixtureAccount{
+			{Id: fakeiam.FakeAccountId, Name: "management"},
+			{Id: "111111111111", Name: "prod"},
+		},
+		Users: []fakeiam.FixtureUser{
+			{UserName: "ops", AccessKeys: []fakeiam.FixtureAccessKey{fixtureKey("AKIACALLER", "2022-01-01")}},
+		},
+	}
+	writeFixture(t, fixture)
+	base, _ := fakeWrapper(t, fixture)
+
+	accounts, err := base.DeclareOrgConfig(OrgOptions{})
+	if err != nil {
+		t.Fatalf("DeclareOrgConfig: %v", err)
+	}
+	for _, account := range accounts {
+		want := ""
+		if account.Account.AccountId == fakeiam.FakeAccountId {
+			want = "AKIACALLER"
+		}
+		if account.AccessKeyId != want {
+			t.Errorf("account %s: AccessKeyId = %q, want %q", account.Account.AccountId, account.AccessKeyId, want)
+		}
+	}
+
+	inputs := RotateWrapperInputs{
+		GetWrapperInputs: GetWrapperInputs{Age: 90, Concurrency: 1, Client: base, Accounts: accounts, AccountConcurrency: 2},
+		DryRun:           true,
+	}
+	data, err := GetUserAccessKey(inputs.GetWrapperInputs)
+	if err != nil {
+		t.Fatalf("GetUserAccessKey: %v", err)
+	}
+	plan, err := RotateInAccounts(data, inputs, UserWrapper.RotateAccessKeys)
+	if err != nil {
+		t.Fatalf("RotateInAccounts: %v", err)
+	}
+
+	deactivated := map[string]bool{}
+	for _, item := range plan {
+		entry := item.(RotationPlanEntry)
+		if entry.Action == ActionDeactivateKey || entry.Action == ActionDeleteKey {
+			deactivated[entry.AccountId] = true
+		}
+	}
+	if deactivated[fakeiam.FakeAccountId] {
+		t.Errorf("the caller's key is retired in its own account: %+v", plan)
+	}
+	if !deactivated["111111111111"] {
+		t.Errorf("the expired key of another account is not retired: %+v", plan)
+	}
+}
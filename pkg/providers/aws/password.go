@@ -0,0 +1,242 @@
+package iam
+
+import (
+	"context"
+	"crypto/rand"
+	_ "embed"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+// DefaultPasswordLength is the length of generated passwords when
+// --password-length is not set.
+const DefaultPasswordLength = 20
+
+const (
+	// maxPasswordLength is the longest password IAM accepts.
+	maxPasswordLength = 128
+	// passphraseWords is the minimum number of words in a passphrase.
+	passphraseWords = 6
+	// maxPasswordAttempts bounds the retries when IAM rejects a generated
+	// password, e.g. because of the reuse rules.
+	maxPasswordAttempts = 3
+)
+
+// Character classes of IAM password policies. The symbols are the ones IAM
+// accepts for RequireSymbols.
+const (
+	lowercaseChars = "abcdefghijklmnopqrstuvwxyz"
+	uppercaseChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	numberChars    = "0123456789"
+	symbolChars    = "!@#$%^&*()_+-=[]{}|'"
+)
+
+// wordListData is the EFF large word list, licensed under CC BY 3.0 US; see
+// the License section of the README.
+//
+//go:embed eff_large_wordlist.txt
+var wordListData string
+
+var wordList = strings.Fields(wordListData)
+
+// PasswordPolicy is the part of the account password policy that constrains
+// generated passwords.
+type PasswordPolicy struct {
+	MinimumLength    int
+	RequireLowercase bool
+	RequireUppercase bool
+	RequireNumbers   bool
+	RequireSymbols   bool
+	// ReusePrevention is the number of previous passwords IAM refuses.
+	ReusePrevention int
+}
+
+// PasswordOptions selects how temporary console passwords are generated.
+type PasswordOptions struct {
+	// Length is the password length in characters, raised to the policy
+	// minimum when lower. Zero means DefaultPasswordLength.
+	Length int
+	// Passphrase generates diceware words instead of random characters.
+	Passphrase bool
+}
+
+// defaultPasswordPolicy mirrors the IAM default policy applied when the
+// account has none: at least 8 characters mixing three character classes.
+// Generated passwords use all four classes, which satisfies it.
+var defaultPasswordPolicy = PasswordPolicy{
+	MinimumLength:    8,
+	RequireLowercase: true,
+	RequireUppercase: true,
+	RequireNumbers:   true,
+	RequireSymbols:   true,
+}
+
+// GetPasswordPolicy reads the account password policy, falling back to the
+// IAM default when the account has no custom policy.
+func (wrapper UserWrapper) GetPasswordPolicy() (PasswordPolicy, error) {
+	output, err := wrapper.IamClient.GetAccountPasswordPolicy(context.TODO(), &iam.GetAccountPasswordPolicyInput{})
+	if err != nil {
+		var notFound *types.NoSuchEntityException
+		if errors.As(err, &notFound) {
+			return defaultPasswordPolicy, nil
+		}
+		return PasswordPolicy{}, fmt.Errorf("error getting account password policy: %w", err)
+	}
+
+	policy := output.PasswordPolicy
+	result := PasswordPolicy{
+		RequireLowercase: policy.RequireLowercaseCharacters,
+		RequireUppercase: policy.RequireUppercaseCharacters,
+		RequireNumbers:   policy.RequireNumbers,
+		RequireSymbols:   policy.RequireSymbols,
+	}
+	if policy.MinimumPasswordLength != nil {
+		result.MinimumLength = int(*policy.MinimumPasswordLength)
+	}
+	if policy.PasswordReusePrevention != nil {
+		result.ReusePrevention = int(*policy.PasswordReusePrevention)
+	}
+	return result, nil
+}
+
+// GeneratePassword returns a password from crypto/rand that satisfies policy.
+func GeneratePassword(policy PasswordPolicy, options PasswordOptions) (string, error) {
+	length := options.Length
+	if length == 0 {
+		length = DefaultPasswordLength
+	}
+	if length < policy.MinimumLength {
+		length = policy.MinimumLength
+	}
+	if length > maxPasswordLength {
+		return "", fmt.Errorf("password length %d exceeds the IAM maximum of %d", length, maxPasswordLength)
+	}
+
+	if options.Passphrase {
+		return generatePassphrase(policy, length)
+	}
+	return generateRandomPassword(policy, length)
+}
+
+// generateRandomPassword draws one character of every required class and
+// fills the rest from all classes, then shuffles the result.
+func generateRandomPassword(policy PasswordPolicy, length int) (string, error) {
+	required := requiredClasses(policy)
+	if length < len(required) {
+		length = len(required)
+	}
+
+	password := make([]byte, 0, length)
+	for _, class := range required {
+		char, err := randomChar(class)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, char)
+	}
+
+	all := lowercaseChars + uppercaseChars + numberChars + symbolChars
+	for len(password) < length {
+		char, err := randomChar(all)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, char)
+	}
+
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := randomInt(i + 1)
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j] = password[j], password[i]
+	}
+	return string(password), nil
+}
+
+// generatePassphrase joins random words from the embedded EFF large word list
+// with hyphens until it has passphraseWords words and reaches length. One
+// word is capitalised and a digit and symbol are appended when the policy
+// requires them.
+func generatePassphrase(policy PasswordPolicy, length int) (string, error) {
+	var words []string
+	size := 0
+	for len(words) < passphraseWords || size < length {
+		i, err := randomInt(len(wordList))
+		if err != nil {
+			return "", err
+		}
+		words = append(words, wordList[i])
+		size += len(wordList[i]) + 1
+	}
+
+	if policy.RequireUppercase {
+		i, err := randomInt(len(words))
+		if err != nil {
+			return "", err
+		}
+		runes := []rune(words[i])
+		runes[0] = unicode.ToUpper(runes[0])
+		words[i] = string(runes)
+	}
+
+	passphrase := strings.Join(words, "-")
+	if policy.RequireNumbers {
+		char, err := randomChar(numberChars)
+		if err != nil {
+			return "", err
+		}
+		passphrase += string(char)
+	}
+	if policy.RequireSymbols && !strings.ContainsAny(passphrase, symbolChars) {
+		char, err := randomChar(symbolChars)
+		if err != nil {
+			return "", err
+		}
+		passphrase += string(char)
+	}
+
+	if len(passphrase) > maxPasswordLength {
+		return "", fmt.Errorf("passphrase of %d characters exceeds the IAM maximum of %d", len(passphrase), maxPasswordLength)
+	}
+	return passphrase, nil
+}
+
+func requiredClasses(policy PasswordPolicy) []string {
+	var classes []string
+	if policy.RequireLowercase {
+		classes = append(classes, lowercaseChars)
+	}
+	if policy.RequireUppercase {
+		classes = append(classes, uppercaseChars)
+	}
+	if policy.RequireNumbers {
+		classes = append(classes, numberChars)
+	}
+	if policy.RequireSymbols {
+		classes = append(classes, symbolChars)
+	}
+	return classes
+}
+
+func randomChar(chars string) (byte, error) {
+	i, err := randomInt(len(chars))
+	if err != nil {
+		return 0, err
+	}
+	return chars[i], nil
+}
+
+func randomInt(n int) (int, error) {
+	value, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, fmt.Errorf("error reading random data: %w", err)
+	}
+	return int(value.Int64()), nil
+}
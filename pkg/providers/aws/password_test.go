@@ -0,0 +1,88 @@
+package iam
+
+import (
+	"strings"
+	"testing"
+)
+
+// policyViolation returns why password does not satisfy policy, or "".
+func policyViolation(policy PasswordPolicy, password string) string {
+	switch {
+	case len(password) < policy.MinimumLength:
+		return "too short"
+	case len(password) > maxPasswordLength:
+		return "too long"
+	case policy.RequireLowercase && !strings.ContainsAny(password, lowercaseChars):
+		return "no lowercase letter"
+	case policy.RequireUppercase && !strings.ContainsAny(password, uppercaseChars):
+		return "no uppercase letter"
+	case policy.RequireNumbers && !strings.ContainsAny(password, numberChars):
+		return "no number"
+	case policy.RequireSymbols && !strings.ContainsAny(password, symbolChars):
+		return "no symbol"
+	}
+	return ""
+}
+
+func TestGeneratePasswordMeetsPolicy(t *testing.T) {
+	strict := PasswordPolicy{MinimumLength: 32, RequireLowercase: true, RequireUppercase: true, RequireNumbers: true, RequireSymbols: true}
+
+	tests := []struct {
+		name    string
+		policy  PasswordPolicy
+		options PasswordOptions
+		wantLen int
+	}{
+		{name: "IAM default policy", policy: defaultPasswordPolicy, wantLen: DefaultPasswordLength},
+		{name: "strict policy raises the length", policy: strict, wantLen: 32},
+		{name: "requested length", policy: defaultPasswordPolicy, options: PasswordOptions{Length: 64}, wantLen: 64},
+		{name: "no policy", wantLen: DefaultPasswordLength},
+		{name: "numbers only", policy: PasswordPolicy{MinimumLength: 6, RequireNumbers: true}, wantLen: DefaultPasswordLength},
+		{name: "passphrase, default policy", policy: defaultPasswordPolicy, options: PasswordOptions{Passphrase: true}},
+		{name: "passphrase, strict policy", policy: strict, options: PasswordOptions{Passphrase: true}},
+		{name: "passphrase, lowercase only", policy: PasswordPolicy{RequireLowercase: true}, options: PasswordOptions{Passphrase: true}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Generation is random, check several draws.
+			for i := 0; i < 50; i++ {
+				password, err := GeneratePassword(test.policy, test.options)
+				if err != nil {
+					t.Fatalf("GeneratePassword: %v", err)
+				}
+				if violation := policyViolation(test.policy, password); violation != "" {
+					t.Fatalf("%q does not satisfy the policy: %s", password, violation)
+				}
+				if test.wantLen > 0 && len(password) != test.wantLen {
+					t.Fatalf("len(%q) = %d, want %d", password, len(password), test.wantLen)
+				}
+				if test.options.Passphrase {
+					if words := strings.Split(password, "-"); len(words) < passphraseWords {
+						t.Fatalf("%q has %d words, want at least %d", password, len(words), passphraseWords)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGeneratePasswordRejectsTooLong(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  PasswordPolicy
+		options PasswordOptions
+	}{
+		{name: "requested length", options: PasswordOptions{Length: maxPasswordLength + 1}},
+		{name: "policy minimum", policy: PasswordPolicy{MinimumLength: maxPasswordLength + 1}},
+		{name: "passphrase", options: PasswordOptions{Length: maxPasswordLength + 1, Passphrase: true}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if password, err := GeneratePassword(test.policy, test.options); err == nil {
+				t.Errorf("GeneratePassword = %q, want an error", password)
+			}
+		})
+	}
+}
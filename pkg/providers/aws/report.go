@@ -0,0 +1,370 @@
+package iam
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/charmbracelet/log"
+)
+
+// RootAccountName is the user name the credential report uses for the
+// account root user.
+const RootAccountName = "<root_account>"
+
+const (
+	reportPollInterval = 2 * time.Second
+	reportPollAttempts = 30
+)
+
+// CredentialReportRow is one line of the IAM credential report. Dates that
+// the report marks as N/A, not_supported or no_information are zero.
+type CredentialReportRow struct {
+	User                 string
+	Arn                  string
+	UserCreationTime     time.Time
+	PasswordEnabled      bool
+	PasswordLastUsed     time.Time
+	PasswordLastChanged  time.Time
+	PasswordNextRotation time.Time
+	MfaActive            bool
+	AccessKeys           [2]CredentialReportKey
+}
+
+// CredentialReportKey holds the access_key_N_* columns of a report row.
+type CredentialReportKey struct {
+	Active          bool
+	LastRotated     time.Time
+	LastUsedDate    time.Time
+	LastUsedService string
+}
+
+// Present reports whether the row describes an existing key.
+func (key CredentialReportKey) Present() bool {
+	return !key.LastRotated.IsZero()
+}
+
+// GetCredentialReport generates the IAM credential report, waits until it is
+// ready and parses it.
+func (wrapper UserWrapper) GetCredentialReport() ([]CredentialReportRow, error) {
+	for attempt := 0; ; attempt++ {
+		generated, err := wrapper.IamClient.GenerateCredentialReport(context.TODO(), &iam.GenerateCredentialReportInput{})
+		if err != nil {
+			return nil, fmt.Errorf("error generating credential report: %w", err)
+		}
+		if generated.State == types.ReportStateTypeComplete {
+			break
+		}
+		if attempt >= reportPollAttempts {
+			return nil, fmt.Errorf("credential report still %s after %d attempts", generated.State, attempt)
+		}
+		log.Debugf("Credential report is %s, waiting", generated.State)
+		time.Sleep(reportPollInterval)
+	}
+
+	report, err := wrapper.IamClient.GetCredentialReport(context.TODO(), &iam.GetCredentialReportInput{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting credential report: %w", err)
+	}
+
+	return ParseCredentialReport(bytes.NewReader(report.Content))
+}
+
+// ParseCredentialReport reads a credential report CSV as produced by
+// GetCredentialReport or downloaded from the IAM console.
+func ParseCredentialReport(r io.Reader) ([]CredentialReportRow, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading credential report header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	if _, ok := columns["user"]; !ok {
+		return nil, fmt.Errorf("credential report has no 'user' column")
+	}
+
+	var rows []CredentialReportRow
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading credential report line %d: %w", line, err)
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		row := CredentialReportRow{
+			User:                 field("user"),
+			Arn:                  field("arn"),
+			UserCreationTime:     parseReportTime(field("user_creation_time")),
+			PasswordEnabled:      field("password_enabled") == "true",
+			PasswordLastUsed:     parseReportTime(field("password_last_used")),
+			PasswordLastChanged:  parseReportTime(field("password_last_changed")),
+			PasswordNextRotation: parseReportTime(field("password_next_rotation")),
+			MfaActive:            field("mfa_active") == "true",
+		}
+		for i := range row.AccessKeys {
+			prefix := fmt.Sprintf("access_key_%d_", i+1)
+			row.AccessKeys[i] = CredentialReportKey{
+				Active:          field(prefix+"active") == "true",
+				LastRotated:     parseReportTime(field(prefix + "last_rotated")),
+				LastUsedDate:    parseReportTime(field(prefix + "last_used_date")),
+				LastUsedService: field(prefix + "last_used_service"),
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// LoadCredentialReport parses a credential report CSV saved to disk.
+func LoadCredentialReport(path string) ([]CredentialReportRow, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening credential report %s: %w", path, err)
+	}
+	defer file.Close()
+
+	rows, err := ParseCredentialReport(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rows, nil
+}
+
+// credentialReportRows returns the report rows from ReportFile when set and
+// from the IAM API otherwise.
+func (input GetWrapperInputs) credentialReportRows() ([]CredentialReportRow, error) {
+	if input.ReportFile != "" {
+		return LoadCredentialReport(input.ReportFile)
+	}
+	return input.Client.GetCredentialReport()
+}
+
+func parseReportTime(value string) time.Time {
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return parsed
+}
+
+// ReportAccessKeys builds access key data from credential report rows,
+// applying the same age and expired-only rules as ListAccessKeys. The report
+// does not carry key ids, so keys are named after their report slot.
+func ReportAccessKeys(rows []CredentialReportRow, input GetWrapperInputs) ([]UserData, error) {
+	loc, err := time.LoadLocation(input.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load time zone %s: %w", input.TimeZone, err)
+	}
+
+	selected, err := selectReportRows(rows, input)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyRows []CredentialReportRow
+	for _, row := range selected {
+		if slices.ContainsFunc(row.AccessKeys[:], CredentialReportKey.Present) {
+			keyRows = append(keyRows, row)
+		}
+	}
+	policies, failures := input.reportPolicies(keyRows)
+
+	var userKeyData []UserData
+	for _, row := range keyRows {
+		if err, failed := failures[row.User]; failed {
+			userKeyData = append(userKeyData, UserAccessKeyData{UserName: row.User, Error: err.Error()})
+			continue
+		}
+		policy := policies[row.User]
+
+		var keys []AccessKeyData
+		hasMatch := false
+
+		for i, reportKey := range row.AccessKeys {
+			if !reportKey.Present() {
+				continue
+			}
+
+			keyData := AccessKeyData{
+				Id:              aws.String(fmt.Sprintf("access_key_%d", i+1)),
+				CreateDate:      reportKey.LastRotated.In(loc),
+				KeyStatus:       types.StatusTypeInactive,
+				LastUsedService: "n/a",
+			}
+			if reportKey.Active {
+				keyData.KeyStatus = types.StatusTypeActive
+			}
+			if !reportKey.LastUsedDate.IsZero() {
+				keyData.LastUsedTime = reportKey.LastUsedDate.In(loc)
+				keyData.LastUsedService = reportKey.LastUsedService
+			}
+			applyKeyCriteria(&keyData, input.Expired, policy.MaxAge)
+
+			keys = append(keys, keyData)
+			if keyData.MatchesCriteria {
+				hasMatch = true
+			}
+		}
+
+		if keys == nil || (input.Expired && !hasMatch) {
+			continue
+		}
+		userKeyData = append(userKeyData, UserAccessKeyData{
+			UserName: row.User,
+			Keys:     keys,
+			Policy:   policy,
+		})
+	}
+
+	sortUserData(userKeyData)
+	return userKeyData, nil
+}
+
+// ReportLoginProfiles builds login profile data from credential report rows.
+// The password creation date is taken from password_last_changed.
+func ReportLoginProfiles(rows []CredentialReportRow, input GetWrapperInputs) ([]UserData, error) {
+	selected, err := selectReportRows(rows, input)
+	if err != nil {
+		return nil, err
+	}
+
+	var consoleRows []CredentialReportRow
+	for _, row := range selected {
+		isRoot := row.User == RootAccountName
+		if row.PasswordEnabled || (isRoot && !row.PasswordLastUsed.IsZero()) {
+			consoleRows = append(consoleRows, row)
+		}
+	}
+	policies, failures := input.reportPolicies(consoleRows)
+
+	var userLoginProfiles []UserData
+	for _, row := range consoleRows {
+		if err, failed := failures[row.User]; failed {
+			userLoginProfiles = append(userLoginProfiles, UserLoginData{UserName: row.User, Error: err.Error()})
+			continue
+		}
+
+		createDate := row.PasswordLastChanged
+		if createDate.IsZero() {
+			createDate = row.UserCreationTime
+		}
+
+		userLogin := UserLoginData{
+			UserName:            row.User,
+			LastUsedTime:        row.PasswordLastUsed,
+			PasswordLastChanged: row.PasswordLastChanged,
+			MfaActive:           aws.Bool(row.MfaActive),
+			Policy:              policies[row.User],
+			LoginProfile: &types.LoginProfile{
+				UserName:   aws.String(row.User),
+				CreateDate: aws.Time(createDate),
+			},
+		}
+
+		if input.Expired && !isLoginProfileExpired(userLogin, userLogin.Policy.MaxAge) {
+			continue
+		}
+		userLoginProfiles = append(userLoginProfiles, userLogin)
+	}
+
+	sortUserData(userLoginProfiles)
+	return userLoginProfiles, nil
+}
+
+// selectReportRows applies --username, --exclude, the user filter and the
+// MaxUsers limit to report rows. The root account row does not count towards
+// the limit.
+func selectReportRows(rows []CredentialReportRow, input GetWrapperInputs) ([]CredentialReportRow, error) {
+	if input.ReportFile != "" && input.Filter.NeedsAPI() {
+		return nil, fmt.Errorf("group and tag filters need AWS access and cannot be used with a saved credential report")
+	}
+	selector, err := newUserSelector(input)
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []CredentialReportRow
+	users := int32(0)
+	for _, row := range rows {
+		if input.UserName != "" && row.User != input.UserName {
+			continue
+		}
+		if row.User != RootAccountName {
+			kept, err := selector.keep(types.User{
+				UserName: aws.String(row.User),
+				Arn:      aws.String(row.Arn),
+				Path:     aws.String(userPathFromArn(row.Arn)),
+			})
+			if err != nil {
+				return nil, err
+			}
+			if !kept {
+				continue
+			}
+			if input.MaxUsers > 0 && users == input.MaxUsers {
+				continue
+			}
+			users++
+		} else if input.Filter.hasIncludes() || selector.exclude[row.User] {
+			// The root account has no path, groups or tags to match.
+			continue
+		}
+		selected = append(selected, row)
+	}
+	return selected, nil
+}
+
+// userPathFromArn extracts the IAM path from a user ARN such as
+// arn:aws:iam::123456789012:user/svc/alice.
+func userPathFromArn(arn string) string {
+	_, resource, found := strings.Cut(arn, ":user/")
+	if !found {
+		return "/"
+	}
+	if i := strings.LastIndex(resource, "/"); i >= 0 {
+		return "/" + resource[:i+1]
+	}
+	return "/"
+}
+
+// sortUserData orders listing data by user name, as the API paths do.
+func sortUserData(data []UserData) {
+	sort.Slice(data, func(i, j int) bool {
+		return userDataName(data[i]) < userDataName(data[j])
+	})
+}
+
+func userDataName(item UserData) string {
+	switch user := item.(type) {
+	case UserAccessKeyData:
+		return user.UserName
+	case UserLoginData:
+		return user.UserName
+	}
+	return ""
+}
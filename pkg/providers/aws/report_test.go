@@ -0,0 +1,266 @@
+package iam
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const reportHeader = "user,arn,user_creation_time,password_enabled,password_last_used,password_last_changed,password_next_rotation,mfa_active," +
+	"access_key_1_active,access_key_1_last_rotated,access_key_1_last_used_date,access_key_1_last_used_region,access_key_1_last_used_service," +
+	"access_key_2_active,access_key_2_last_rotated,access_key_2_last_used_date,access_key_2_last_used_region,access_key_2_last_used_service," +
+	"cert_1_active,cert_1_last_rotated,cert_2_active,cert_2_last_rotated\n"
+
+// testReport is a credential report with the root account, alice under
+// /svc/ with an old active key, an inactive key and a console password, and
+// bob with a key created today and no password.
+func testReport() string {
+	today := time.Now().UTC().Format(time.DateOnly) + "T00:00:00+00:00"
+	return reportHeader +
+		"<root_account>,arn:aws:iam::123456789012:root,2020-01-01T00:00:00+00:00,not_supported,2026-01-05T00:00:00+00:00,not_supported,not_supported,true,false,N/A,N/A,N/A,N/A,false,N/A,N/A,N/A,N/A,false,N/A,false,N/A\n" +
+		"alice,arn:aws:iam::123456789012:user/svc/alice,2020-01-01T00:00:00+00:00,true,no_information,2022-01-01T00:00:00+00:00,N/A,false,true,2022-01-01T00:00:00+00:00,2026-01-02T00:00:00+00:00,us-east-1,iam,false,2025-12-01T00:00:00+00:00,N/A,N/A,N/A,false,N/A,false,N/A\n" +
+		"bob,arn:aws:iam::123456789012:user/bob,2020-01-01T00:00:00+00:00,false,N/A,N/A,N/A,false,true," + today + ",N/A,N/A,N/A,false,N/A,N/A,N/A,N/A,false,N/A,false,N/A\n"
+}
+
+func reportDate(value string) time.Time {
+	parsed, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+// inUTC returns rows with every time in UTC, so they compare with
+// reflect.DeepEqual whatever zone the parser picked for +00:00.
+func inUTC(rows []CredentialReportRow) []CredentialReportRow {
+	for i := range rows {
+		row := &rows[i]
+		for _, value := range []*time.Time{&row.UserCreationTime, &row.PasswordLastUsed, &row.PasswordLastChanged, &row.PasswordNextRotation} {
+			*value = value.UTC()
+		}
+		for k := range row.AccessKeys {
+			row.AccessKeys[k].LastRotated = row.AccessKeys[k].LastRotated.UTC()
+			row.AccessKeys[k].LastUsedDate = row.AccessKeys[k].LastUsedDate.UTC()
+		}
+	}
+	return rows
+}
+
+func TestParseCredentialReport(t *testing.T) {
+	tests := []struct {
+		name    string
+		report  string
+		want    []CredentialReportRow
+		wantErr bool
+	}{
+		{
+			name: "N/A, not_supported and no_information are zero",
+			report: reportHeader +
+				"<root_account>,arn:aws:iam::123456789012:root,2020-01-01T00:00:00+00:00,not_supported,2026-01-05T00:00:00+00:00,not_supported,not_supported,true,false,N/A,N/A,N/A,N/A,false,N/A,N/A,N/A,N/A,false,N/A,false,N/A\n" +
+				"alice,arn:aws:iam::123456789012:user/svc/alice,2020-01-01T00:00:00+00:00,true,no_information,2022-01-01T00:00:00+00:00,N/A,false,true,2022-01-01T00:00:00+00:00,2026-01-02T00:00:00+00:00,us-east-1,iam,false,2025-12-01T00:00:00+00:00,N/A,N/A,N/A,false,N/A,false,N/A\n",
+			want: []CredentialReportRow{
+				{
+					User:             RootAccountName,
+					Arn:              "arn:aws:iam::123456789012:root",
+					UserCreationTime: reportDate("2020-01-01"),
+					PasswordLastUsed: reportDate("2026-01-05"),
+					MfaActive:        true,
+					AccessKeys:       [2]CredentialReportKey{{LastUsedService: "N/A"}, {LastUsedService: "N/A"}},
+				},
+				{
+					User:                "alice",
+					Arn:                 "arn:aws:iam::123456789012:user/svc/alice",
+					UserCreationTime:    reportDate("2020-01-01"),
+					PasswordEnabled:     true,
+					PasswordLastChanged: reportDate("2022-01-01"),
+					AccessKeys: [2]CredentialReportKey{
+						{Active: true, LastRotated: reportDate("2022-01-01"), LastUsedDate: reportDate("2026-01-02"), LastUsedService: "iam"},
+						{LastRotated: reportDate("2025-12-01"), LastUsedService: "N/A"},
+					},
+				},
+			},
+		},
+		{
+			name:   "missing columns are zero",
+			report: "user,arn,password_enabled\nalice,arn:aws:iam::123456789012:user/alice,true\n",
+			want: []CredentialReportRow{
+				{User: "alice", Arn: "arn:aws:iam::123456789012:user/alice", PasswordEnabled: true},
+			},
+		},
+		{
+			name:   "header only",
+			report: reportHeader,
+		},
+		{
+			name:    "no user column",
+			report:  "name,arn\nalice,arn:aws:iam::123456789012:user/alice\n",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rows, err := ParseCredentialReport(strings.NewReader(test.report))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseCredentialReport() error = %v, want error %v", err, test.wantErr)
+			}
+			if got := inUTC(rows); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("rows = %+v\nwant %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUserPathFromArn(t *testing.T) {
+	tests := []struct {
+		arn  string
+		want string
+	}{
+		{arn: "arn:aws:iam::123456789012:user/alice", want: "/"},
+		{arn: "arn:aws:iam::123456789012:user/svc/alice", want: "/svc/"},
+		{arn: "arn:aws:iam::123456789012:user/svc/ci/alice", want: "/svc/ci/"},
+		{arn: "arn:aws:iam::123456789012:root", want: "/"},
+		{arn: "", want: "/"},
+	}
+
+	for _, test := range tests {
+		if got := userPathFromArn(test.arn); got != test.want {
+			t.Errorf("userPathFromArn(%q) = %q, want %q", test.arn, got, test.want)
+		}
+	}
+}
+
+func TestReportAccessKeys(t *testing.T) {
+	rows, err := ParseCredentialReport(strings.NewReader(testReport()))
+	if err != nil {
+		t.Fatalf("ParseCredentialReport: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		input   GetWrapperInputs
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "users with keys",
+			want: []string{"alice access_key_1 Active expired", "alice access_key_2 Inactive expired", "bob access_key_1 Active"},
+		},
+		{
+			name:  "expired only",
+			input: GetWrapperInputs{Expired: true},
+			want:  []string{"alice access_key_1 Active expired", "alice access_key_2 Inactive expired"},
+		},
+		{
+			name:  "username",
+			input: GetWrapperInputs{UserName: "bob"},
+			want:  []string{"bob access_key_1 Active"},
+		},
+		{
+			name:  "path prefix from the ARN",
+			input: GetWrapperInputs{Filter: UserFilter{PathPrefix: "/svc/"}},
+			want:  []string{"alice access_key_1 Active expired", "alice access_key_2 Inactive expired"},
+		},
+		{
+			name:  "limit",
+			input: GetWrapperInputs{MaxUsers: 1},
+			want:  []string{"alice access_key_1 Active expired", "alice access_key_2 Inactive expired"},
+		},
+		{
+			name:    "group filter on a saved report",
+			input:   GetWrapperInputs{Filter: UserFilter{Groups: []string{"admins"}}},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input := test.input
+			input.Age, input.TimeZone, input.ReportFile = 90, "UTC", "report.csv"
+			data, err := ReportAccessKeys(rows, input)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ReportAccessKeys() error = %v, want error %v", err, test.wantErr)
+			}
+
+			var got []string
+			for _, item := range data {
+				user := item.(UserAccessKeyData)
+				for _, key := range user.Keys {
+					name := fmt.Sprintf("%s %s %s", user.UserName, aws.ToString(key.Id), key.KeyStatus)
+					if key.IsExpired {
+						name += " expired"
+					}
+					got = append(got, name)
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("keys = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReportLoginProfiles(t *testing.T) {
+	rows, err := ParseCredentialReport(strings.NewReader(testReport()))
+	if err != nil {
+		t.Fatalf("ParseCredentialReport: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		input GetWrapperInputs
+		want  []string
+	}{
+		{
+			name: "users with a password and the root account",
+			want: []string{"<root_account> 2020-01-01", "alice 2022-01-01"},
+		},
+		{
+			name:  "root account excluded",
+			input: GetWrapperInputs{Exclude: []string{RootAccountName}},
+			want:  []string{"alice 2022-01-01"},
+		},
+		{
+			name:  "include filters leave out the root account",
+			input: GetWrapperInputs{Filter: UserFilter{PathPrefix: "/svc/"}},
+			want:  []string{"alice 2022-01-01"},
+		},
+		{
+			name:  "root account does not count towards the limit",
+			input: GetWrapperInputs{MaxUsers: 1},
+			want:  []string{"<root_account> 2020-01-01", "alice 2022-01-01"},
+		},
+		{
+			name:  "username without a password",
+			input: GetWrapperInputs{UserName: "bob"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input := test.input
+			input.Age, input.TimeZone, input.ReportFile = 90, "UTC", "report.csv"
+			data, err := ReportLoginProfiles(rows, input)
+			if err != nil {
+				t.Fatalf("ReportLoginProfiles: %v", err)
+			}
+
+			var got []string
+			for _, item := range data {
+				user := item.(UserLoginData)
+				got = append(got, user.UserName+" "+user.LoginProfile.CreateDate.UTC().Format(time.DateOnly))
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("login profiles = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
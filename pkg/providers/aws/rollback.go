@@ -0,0 +1,181 @@
+package iam
+
+import (
+	"context"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/charmbracelet/log"
+)
+
+// RollbackResult reports one change undone by RollbackRotation. Keys the
+// run deleted cannot be restored; they are reported with no Action and a
+// Detail saying so, which does not fail the rollback.
+type RollbackResult struct {
+	Account
+	UserName    string
+	Action      string
+	AccessKeyId string
+	Detail      string
+	Error       string
+}
+
+// RollbackRotation undoes the steps a run completed or was interrupted in:
+// keys it deactivated are reactivated, then keys it created are deleted.
+// Keys it deleted are reported as lost. Rolled back users are marked so a
+// later resume leaves them alone. With dryRun the changes are returned as a
+// plan instead.
+func (wrapper UserWrapper) RollbackRotation(run *RotationRun, dryRun bool) []UserData {
+	var results []UserData
+	for i := range run.Users {
+		user := &run.Users[i]
+		if user.Status == RunRolledBack {
+			log.Debugf("User %s was already rolled back", user.UserName)
+			continue
+		}
+
+		var userResults []UserData
+		failed := false
+		steps := user.Steps
+		if dryRun {
+			steps = slices.Clone(user.Steps)
+		}
+		found, err := wrapper.findInterruptedKeys(user, steps)
+		if err != nil {
+			log.Errorf("Failed to list the access keys of user %s: %v", user.UserName, err)
+			userResults = append(userResults, RollbackResult{UserName: user.UserName, Action: ActionDeleteKey, Detail: "created by the interrupted run", Error: err.Error()})
+			failed = true
+		}
+		if found && !dryRun {
+			run.save()
+		}
+		for _, step := range rollbackOrder(steps) {
+			if dryRun {
+				userResults = append(userResults, rollbackPlanEntry(user.UserName, *step))
+				continue
+			}
+			result := wrapper.rollbackStep(user.UserName, step)
+			failed = failed || result.Error != ""
+			userResults = append(userResults, result)
+		}
+		if len(userResults) == 0 {
+			continue
+		}
+		results = append(results, userResults...)
+
+		if !dryRun && !failed {
+			user.Status = RunRolledBack
+			run.save()
+		}
+	}
+	return results
+}
+
+// findInterruptedKeys looks for the key of each create step that was
+// interrupted after it started, the way a resume does: a key IAM holds that
+// was unknown when the run was planned. A key found is recorded in the step,
+// which is then undone like a finished one. It reports whether any was found.
+func (wrapper UserWrapper) findInterruptedKeys(user *RunUser, steps []RunStep) (bool, error) {
+	found := false
+	for i := range steps {
+		step := &steps[i]
+		if step.Action != ActionCreateKey || step.Status != StepStarted {
+			continue
+		}
+		keyId, err := wrapper.findCreatedKey(user)
+		if err != nil {
+			return found, err
+		}
+		if keyId == "" {
+			log.Debugf("The interrupted key creation for user %s did not reach IAM", user.UserName)
+			continue
+		}
+		log.Warnf("Access key %s of user %s was created before the interruption", keyId, user.UserName)
+		step.Status, step.AccessKeyId = StepDone, keyId
+		found = true
+	}
+	return found, nil
+}
+
+// rollbackOrder returns the steps to undo, reactivations first so the old
+// keys work again before the new ones are removed. Deactivations that were
+// interrupted are undone too, as reactivating an active key changes nothing.
+// A key that was deactivated and then deleted is only reported as deleted.
+func rollbackOrder(steps []RunStep) []*RunStep {
+	deleted := map[string]bool{}
+	for _, step := range steps {
+		if step.Status == StepDone && step.Action == ActionDeleteKey {
+			deleted[step.AccessKeyId] = true
+		}
+	}
+
+	var reactivate, remove []*RunStep
+	for i := range steps {
+		step := &steps[i]
+		interrupted := step.Status == StepStarted && step.Action == ActionDeactivateKey
+		if step.Status != StepDone && !interrupted {
+			continue
+		}
+		switch step.Action {
+		case ActionDeactivateKey:
+			if !deleted[step.AccessKeyId] {
+				reactivate = append(reactivate, step)
+			}
+		case ActionCreateKey, ActionDeleteKey:
+			remove = append(remove, step)
+		}
+	}
+	return append(reactivate, remove...)
+}
+
+func rollbackPlanEntry(userName string, step RunStep) RotationPlanEntry {
+	entry := RotationPlanEntry{UserName: userName, AccessKeyId: step.AccessKeyId}
+	switch step.Action {
+	case ActionDeactivateKey:
+		entry.Action, entry.Detail = ActionReactivateKey, "deactivated by the run"
+	case ActionCreateKey:
+		entry.Action, entry.Detail = ActionDeleteKey, "created by the run"
+	case ActionDeleteKey:
+		entry.Detail = "deleted by the run, cannot be restored"
+	}
+	return entry
+}
+
+func (wrapper UserWrapper) rollbackStep(userName string, step *RunStep) RollbackResult {
+	result := RollbackResult{UserName: userName, AccessKeyId: step.AccessKeyId}
+	switch step.Action {
+	case ActionDeactivateKey:
+		result.Action, result.Detail = ActionReactivateKey, "deactivated by the run"
+		_, err := wrapper.IamClient.UpdateAccessKey(context.TODO(), &iam.UpdateAccessKeyInput{
+			UserName:    aws.String(userName),
+			AccessKeyId: aws.String(step.AccessKeyId),
+			Status:      types.StatusTypeActive,
+		})
+		if err != nil {
+			log.Errorf("Failed to reactivate access key %s of user %s: %v", step.AccessKeyId, userName, err)
+			result.Error = err.Error()
+			return result
+		}
+		log.Infof("Reactivated access key %s of user %s", step.AccessKeyId, userName)
+
+	case ActionCreateKey:
+		result.Action, result.Detail = ActionDeleteKey, "created by the run"
+		if step.AccessKeyId == wrapper.AccessKeyId {
+			result.Error = "gyro is authenticated with this key, delete it with other credentials"
+			return result
+		}
+		if err := wrapper.deleteKey(userName, step.AccessKeyId); err != nil {
+			log.Errorf("Failed to delete access key %s of user %s: %v", step.AccessKeyId, userName, err)
+			result.Error = err.Error()
+			return result
+		}
+		log.Infof("Deleted access key %s of user %s", step.AccessKeyId, userName)
+
+	case ActionDeleteKey:
+		result.Detail = "deleted by the run, cannot be restored"
+		log.Warnf("Access key %s of user %s was deleted by the run and cannot be restored", step.AccessKeyId, userName)
+	}
+	return result
+}
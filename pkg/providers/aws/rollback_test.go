@@ -0,0 +1,183 @@
+package iam
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/javiercm1410/gyro/pkg/providers/aws/fakeiam"
+)
+
+// rollbackNames renders rollback results and plan entries as
+// "action key" strings, with " failed" appended to failed changes.
+func rollbackNames(results []UserData) []string {
+	var names []string
+	for _, item := range results {
+		switch result := item.(type) {
+		case RollbackResult:
+			name := result.Action + " " + result.AccessKeyId
+			if result.Error != "" {
+				name += " failed"
+			}
+			names = append(names, name)
+		case RotationPlanEntry:
+			names = append(names, result.Action+" "+result.AccessKeyId)
+		}
+	}
+	return names
+}
+
+func TestRollbackRotation(t *testing.T) {
+	deactivated := RunStep{Action: ActionDeactivateKey, AccessKeyId: "AKIAOLD", Status: StepDone}
+	created := RunStep{Action: ActionCreateKey, AccessKeyId: "AKIANEW", Status: StepDone}
+
+	tests := []struct {
+		name   string
+		caller string
+		// known are the keys of the user when the run was planned,
+		// AKIAOLD when empty.
+		known      []string
+		dryRun     bool
+		user       RunUser
+		want       []string
+		wantStatus string
+		// wantKeys are the keys of the user afterwards and their status.
+		wantKeys map[string]types.StatusType
+	}{
+		{
+			name:       "reactivates the old key and deletes the new one",
+			user:       RunUser{Status: RunCompleted, Steps: []RunStep{deactivated, created}},
+			want:       []string{"reactivate-key AKIAOLD", "delete-key AKIANEW"},
+			wantStatus: RunRolledBack,
+			wantKeys:   map[string]types.StatusType{"AKIAOLD": types.StatusTypeActive},
+		},
+		{
+			name: "deleted key is reported as lost without failing",
+			user: RunUser{Status: RunCompleted, Steps: []RunStep{
+				{Action: ActionDeactivateKey, AccessKeyId: "AKIAGONE", Status: StepDone},
+				{Action: ActionDeleteKey, AccessKeyId: "AKIAGONE", Status: StepDone},
+				created,
+			}},
+			want:       []string{" AKIAGONE", "delete-key AKIANEW"},
+			wantStatus: RunRolledBack,
+			wantKeys:   map[string]types.StatusType{"AKIAOLD": types.StatusTypeInactive},
+		},
+		{
+			name: "steps that did not finish are not undone",
+			user: RunUser{Status: RunFailed, Steps: []RunStep{
+				deactivated,
+				{Action: ActionCreateKey, Status: StepFailed},
+			}},
+			want:       []string{"reactivate-key AKIAOLD"},
+			wantStatus: RunRolledBack,
+			wantKeys:   map[string]types.StatusType{"AKIAOLD": types.StatusTypeActive, "AKIANEW": types.StatusTypeActive},
+		},
+		{
+			name: "interrupted create that reached IAM is deleted",
+			user: RunUser{Status: RunInProgress, Steps: []RunStep{
+				deactivated,
+				{Action: ActionCreateKey, Status: StepStarted},
+			}},
+			want:       []string{"reactivate-key AKIAOLD", "delete-key AKIANEW"},
+			wantStatus: RunRolledBack,
+			wantKeys:   map[string]types.StatusType{"AKIAOLD": types.StatusTypeActive},
+		},
+		{
+			name:  "interrupted create that never reached IAM",
+			known: []string{"AKIAOLD", "AKIANEW"},
+			user: RunUser{Status: RunInProgress, Steps: []RunStep{
+				deactivated,
+				{Action: ActionCreateKey, Status: StepStarted},
+			}},
+			want:       []string{"reactivate-key AKIAOLD"},
+			wantStatus: RunRolledBack,
+			wantKeys:   map[string]types.StatusType{"AKIAOLD": types.StatusTypeActive, "AKIANEW": types.StatusTypeActive},
+		},
+		{
+			name: "interrupted deactivation is undone",
+			user: RunUser{Status: RunInProgress, Steps: []RunStep{
+				{Action: ActionDeactivateKey, AccessKeyId: "AKIAOLD", Status: StepStarted},
+			}},
+			want:       []string{"reactivate-key AKIAOLD"},
+			wantStatus: RunRolledBack,
+			wantKeys:   map[string]types.StatusType{"AKIAOLD": types.StatusTypeActive, "AKIANEW": types.StatusTypeActive},
+		},
+		{
+			name:   "dry run plans deleting the key of an interrupted create",
+			dryRun: true,
+			user: RunUser{Status: RunInProgress, Steps: []RunStep{
+				deactivated,
+				{Action: ActionCreateKey, Status: StepStarted},
+			}},
+			want:       []string{"reactivate-key AKIAOLD", "delete-key AKIANEW"},
+			wantStatus: RunInProgress,
+			wantKeys:   map[string]types.StatusType{"AKIAOLD": types.StatusTypeInactive, "AKIANEW": types.StatusTypeActive},
+		},
+		{
+			name:       "rolled back user is skipped",
+			user:       RunUser{Status: RunRolledBack, Steps: []RunStep{deactivated, created}},
+			wantStatus: RunRolledBack,
+			wantKeys:   map[string]types.StatusType{"AKIAOLD": types.StatusTypeInactive, "AKIANEW": types.StatusTypeActive},
+		},
+		{
+			name:       "dry run only plans",
+			dryRun:     true,
+			user:       RunUser{Status: RunCompleted, Steps: []RunStep{deactivated, created}},
+			want:       []string{"reactivate-key AKIAOLD", "delete-key AKIANEW"},
+			wantStatus: RunCompleted,
+			wantKeys:   map[string]types.StatusType{"AKIAOLD": types.StatusTypeInactive, "AKIANEW": types.StatusTypeActive},
+		},
+		{
+			name:       "the key gyro is authenticated with is kept",
+			caller:     "AKIANEW",
+			user:       RunUser{Status: RunCompleted, Steps: []RunStep{deactivated, created}},
+			want:       []string{"reactivate-key AKIAOLD", "delete-key AKIANEW failed"},
+			wantStatus: RunCompleted,
+			wantKeys:   map[string]types.StatusType{"AKIAOLD": types.StatusTypeActive, "AKIANEW": types.StatusTypeActive},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oldKey := fixtureKey("AKIAOLD", "2022-01-01")
+			oldKey.Status = "Inactive"
+			fixture := fakeiam.Fixture{
+				Users: []fakeiam.FixtureUser{
+					{UserName: "alice", AccessKeys: []fakeiam.FixtureAccessKey{oldKey, fixtureKey("AKIANEW", "2026-01-01")}},
+				},
+			}
+			if test.caller != "" {
+				fixture.Caller = &fakeiam.FixtureCaller{UserName: "alice", AccessKeyId: test.caller}
+			}
+			wrapper, client := fakeWrapper(t, fixture)
+
+			run, err := newRotationRun(t.TempDir(), "")
+			if err != nil {
+				t.Fatalf("newRotationRun: %v", err)
+			}
+			user := test.user
+			user.UserName = "alice"
+			user.KnownKeyIds = test.known
+			if user.KnownKeyIds == nil {
+				user.KnownKeyIds = []string{"AKIAOLD"}
+			}
+			run.Users = []RunUser{user}
+
+			got := rollbackNames(wrapper.RollbackRotation(run, test.dryRun))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("results = %q, want %q", got, test.want)
+			}
+			if status := run.Users[0].Status; status != test.wantStatus {
+				t.Errorf("status = %s, want %s", status, test.wantStatus)
+			}
+
+			keys := map[string]types.StatusType{}
+			for _, key := range client.AccessKeys("alice") {
+				keys[key.AccessKeyId] = key.Status
+			}
+			if !reflect.DeepEqual(keys, test.wantKeys) {
+				t.Errorf("keys = %v, want %v", keys, test.wantKeys)
+			}
+		})
+	}
+}
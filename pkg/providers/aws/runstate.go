@@ -0,0 +1,410 @@
+package iam
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/charmbracelet/log"
+)
+
+// Status of a user in a rotation run. RunRolledBack users were undone by
+// RollbackRotation and are not resumed.
+const (
+	RunPlanned    = "planned"
+	RunInProgress = "in-progress"
+	RunCompleted  = "completed"
+	RunFailed     = "failed"
+	RunRolledBack = "rolled-back"
+)
+
+// Status of one step of a user's rotation. A step is marked started before
+// its IAM call and done after it, so a step left started was interrupted and
+// may or may not have reached IAM.
+const (
+	StepPending = "pending"
+	StepStarted = "started"
+	StepDone    = "done"
+	StepSkipped = "skipped"
+	StepFailed  = "failed"
+)
+
+// RotationRun is the persisted state of one `gyro rotate keys` run, written
+// after every step so an interrupted run can be resumed.
+type RotationRun struct {
+	RunId     string    `json:"runId"`
+	StartedAt time.Time `json:"startedAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// AccountId is the organization account the run rotated keys in, empty
+	// for the account of the default credentials.
+	AccountId string    `json:"accountId,omitempty"`
+	Users     []RunUser `json:"users"`
+	path      string
+}
+
+// RunUser is the rotation plan of one user and how far it got.
+type RunUser struct {
+	UserName string `json:"userName"`
+	Status   string `json:"status"`
+	// KnownKeyIds are the user's keys when the run was planned, used to
+	// recognise a key created by an interrupted step.
+	KnownKeyIds []string  `json:"knownKeyIds"`
+	Steps       []RunStep `json:"steps"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// RunStep is one IAM change of a user's rotation. AccessKeyId is the key
+// acted on, or the new key once a create-key step is done.
+type RunStep struct {
+	Action      string `json:"action"`
+	AccessKeyId string `json:"accessKeyId,omitempty"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+}
+
+// DefaultRunDir returns ~/.config/gyro/runs.
+func DefaultRunDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "runs"
+	}
+	return filepath.Join(home, ".config", "gyro", "runs")
+}
+
+// RunPath returns the state file of a run in dir.
+func RunPath(dir, runId string) string {
+	return filepath.Join(dir, runId+".json")
+}
+
+// newRotationRun starts an empty run whose state is kept in dir.
+func newRotationRun(dir, accountId string) (*RotationRun, error) {
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("error generating run id: %w", err)
+	}
+	now := time.Now().UTC()
+	runId := now.Format("20060102T150405") + "-" + hex.EncodeToString(suffix)
+	return &RotationRun{RunId: runId, StartedAt: now, AccountId: accountId, path: RunPath(dir, runId)}, nil
+}
+
+// LoadRotationRun reads the state of a run from dir.
+func LoadRotationRun(dir, runId string) (*RotationRun, error) {
+	path := RunPath(dir, runId)
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no rotation run %s in %s", runId, dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading rotation run %s: %w", path, err)
+	}
+
+	run := &RotationRun{path: path}
+	if err := json.Unmarshal(raw, run); err != nil {
+		return nil, fmt.Errorf("error parsing rotation run %s: %w", path, err)
+	}
+	return run, nil
+}
+
+// Save writes the run state through a temporary file, so an interruption
+// never leaves a truncated state behind.
+func (run *RotationRun) Save() error {
+	run.UpdatedAt = time.Now().UTC()
+	marshaled, err := json.MarshalIndent(run, "", "   ")
+	if err != nil {
+		return fmt.Errorf("error marshaling rotation run: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(run.path), 0o700); err != nil {
+		return fmt.Errorf("error creating directory for %s: %w", run.path, err)
+	}
+	tmp := run.path + ".tmp"
+	if err := os.WriteFile(tmp, marshaled, 0o600); err != nil {
+		return fmt.Errorf("error writing rotation run %s: %w", run.path, err)
+	}
+	if err := os.Rename(tmp, run.path); err != nil {
+		return fmt.Errorf("error writing rotation run %s: %w", run.path, err)
+	}
+	return nil
+}
+
+// Path returns the state file of the run.
+func (run *RotationRun) Path() string {
+	return run.path
+}
+
+// Pending reports whether some user of the run has not completed.
+func (run *RotationRun) Pending() bool {
+	return slices.ContainsFunc(run.Users, RunUser.Pending)
+}
+
+// Verifies reports whether a user still to be resumed has new keys to
+// verify, which needs a verification timeout.
+func (run *RotationRun) Verifies() bool {
+	return slices.ContainsFunc(run.Users, func(user RunUser) bool {
+		return user.Pending() && slices.ContainsFunc(user.Steps, func(step RunStep) bool {
+			return step.Action == ActionVerifyKey && step.Status != StepDone && step.Status != StepSkipped
+		})
+	})
+}
+
+// Pending reports whether the user still has to be resumed.
+func (user RunUser) Pending() bool {
+	return user.Status != RunCompleted && user.Status != RunRolledBack
+}
+
+// save persists the run, logging instead of failing: losing the state must
+// not stop a rotation half way through a user. A run without a state file is
+// only kept in memory.
+func (run *RotationRun) save() {
+	if run == nil || run.path == "" {
+		return
+	}
+	if err := run.Save(); err != nil {
+		log.Errorf("Failed to save rotation state: %v", err)
+	}
+}
+
+// runUserFromPlan turns an access key plan into the steps of a run.
+func runUserFromPlan(user UserAccessKeyData, plan accessKeyPlan) RunUser {
+	runUser := RunUser{UserName: user.UserName, Status: RunPlanned}
+	for _, key := range user.Keys {
+		runUser.KnownKeyIds = append(runUser.KnownKeyIds, aws.ToString(key.Id))
+	}
+	for _, step := range plan.steps() {
+		runStep := RunStep{Action: step.action, Status: StepPending}
+		if step.key != nil {
+			runStep.AccessKeyId = *step.key.Id
+		}
+		runUser.Steps = append(runUser.Steps, runStep)
+	}
+	return runUser
+}
+
+// executeRunUser runs the steps of user that are not done yet, saving run
+// after every transition. A failed deactivation does not stop the user; a
+// failed deletion does, as no room is left for the new key, and so does a
+// new key that fails verification, which is deleted again. Steps left
+// started by an interrupted run are reconciled with IAM before being retried.
+func (wrapper UserWrapper) executeRunUser(run *RotationRun, user *RunUser, verifyTimeout time.Duration) AccessKeyRotationResult {
+	result := AccessKeyRotationResult{UserName: user.UserName}
+	user.Status = RunInProgress
+	user.Error = ""
+	run.save()
+
+	var create *RunStep
+	var secret string
+	failed := false
+	for i := range user.Steps {
+		step := &user.Steps[i]
+		if step.Action == ActionCreateKey {
+			create = step
+		}
+		if step.Status == StepDone || step.Status == StepSkipped {
+			continue
+		}
+
+		interrupted := step.Status == StepStarted
+		step.Status = StepStarted
+		step.Error = ""
+		run.save()
+
+		var err error
+		switch {
+		case step.Action == ActionDeactivateKey:
+			err = wrapper.deactivateKey(user.UserName, step.AccessKeyId)
+		case step.Action == ActionDeleteKey:
+			err = wrapper.deleteKey(user.UserName, step.AccessKeyId)
+		case step.Action == ActionCreateKey:
+			step.AccessKeyId, secret, err = wrapper.createKey(user, interrupted)
+		case step.Action == ActionVerifyKey && create != nil:
+			step.AccessKeyId, err = wrapper.verifyRunKey(run, user, create, &secret, verifyTimeout)
+		default:
+			err = fmt.Errorf("unknown step %q in rotation state", step.Action)
+		}
+
+		if err != nil {
+			log.Errorf("Failed to %s for user %s: %v", step.Action, user.UserName, err)
+			step.Status = StepFailed
+			step.Error = err.Error()
+			result.Error = appendResultError(result.Error, fmt.Sprintf("%s %s: %v", step.Action, step.AccessKeyId, err))
+			failed = true
+			if step.Action == ActionVerifyKey {
+				result.Error = appendResultError(result.Error, wrapper.rollbackRunKey(user, create))
+				result.AccessKeyId, result.SecretAccessKey = "", ""
+			}
+			run.save()
+			if step.Action == ActionDeactivateKey {
+				continue
+			}
+			break
+		}
+
+		step.Status = StepDone
+		run.save()
+
+		switch step.Action {
+		case ActionDeactivateKey:
+			log.Infof("Successfully deactivated access key %s", step.AccessKeyId)
+			result.DeactivatedKeys = append(result.DeactivatedKeys, step.AccessKeyId)
+		case ActionDeleteKey:
+			log.Infof("Successfully deleted access key %s for user %s", step.AccessKeyId, user.UserName)
+			result.DeletedKeyId = step.AccessKeyId
+		case ActionCreateKey, ActionVerifyKey:
+			result.AccessKeyId = step.AccessKeyId
+			result.SecretAccessKey = secret
+			if step.Action == ActionVerifyKey {
+				continue
+			}
+			if secret == "" && !slices.ContainsFunc(user.Steps, func(step RunStep) bool { return step.Action == ActionVerifyKey }) {
+				result.Error = appendResultError(result.Error, "key created before the interruption, its secret was not recorded")
+			} else {
+				log.Infof("Successfully rotated access key for user: %s", user.UserName)
+				log.Infof("Access Key ID: %s", step.AccessKeyId)
+			}
+		}
+	}
+
+	user.Status = RunCompleted
+	if failed {
+		user.Status = RunFailed
+		user.Error = result.Error
+	}
+	run.save()
+	return result
+}
+
+// verifyRunKey verifies the key created by the create step. When its secret
+// is not known, because the key was created by an interrupted run, the key
+// cannot be verified: it is replaced with a new one first.
+func (wrapper UserWrapper) verifyRunKey(run *RotationRun, user *RunUser, create *RunStep, secret *string, timeout time.Duration) (string, error) {
+	if *secret == "" {
+		log.Warnf("The secret of access key %s of user %s was not recorded, replacing the key to verify it", create.AccessKeyId, user.UserName)
+		if err := wrapper.deleteKey(user.UserName, create.AccessKeyId); err != nil {
+			return create.AccessKeyId, err
+		}
+		create.Status, create.AccessKeyId = StepPending, ""
+		run.save()
+
+		keyId, newSecret, err := wrapper.createKey(user, false)
+		if err != nil {
+			return "", err
+		}
+		create.Status, create.AccessKeyId, *secret = StepDone, keyId, newSecret
+		run.save()
+	}
+	return create.AccessKeyId, wrapper.VerifyAccessKey(create.AccessKeyId, *secret, timeout)
+}
+
+// rollbackRunKey deletes a new key that failed verification and marks its
+// creation pending again, so a resumed run issues and verifies a fresh key.
+// The keys the user already had are left untouched. It returns the outcome
+// for the result's error.
+func (wrapper UserWrapper) rollbackRunKey(user *RunUser, create *RunStep) string {
+	if create == nil || create.Status != StepDone {
+		return "old keys left untouched"
+	}
+	if err := wrapper.deleteKey(user.UserName, create.AccessKeyId); err != nil {
+		log.Errorf("Failed to delete unverified access key %s of user %s: %v", create.AccessKeyId, user.UserName, err)
+		return fmt.Sprintf("deleting unverified key %s failed: %v", create.AccessKeyId, err)
+	}
+	log.Warnf("Deleted unverified access key %s of user %s, its old keys are untouched", create.AccessKeyId, user.UserName)
+	deleted := create.AccessKeyId
+	create.Status, create.AccessKeyId = StepPending, ""
+	return fmt.Sprintf("new key %s deleted, old keys left untouched", deleted)
+}
+
+func (wrapper UserWrapper) deactivateKey(userName, keyId string) error {
+	_, err := wrapper.IamClient.UpdateAccessKey(context.TODO(), &iam.UpdateAccessKeyInput{
+		UserName:    aws.String(userName),
+		AccessKeyId: aws.String(keyId),
+		Status:      types.StatusTypeInactive,
+	})
+	return err
+}
+
+// deleteKey deletes an access key; a key that is already gone counts as
+// deleted.
+func (wrapper UserWrapper) deleteKey(userName, keyId string) error {
+	_, err := wrapper.IamClient.DeleteAccessKey(context.TODO(), &iam.DeleteAccessKeyInput{
+		UserName:    aws.String(userName),
+		AccessKeyId: aws.String(keyId),
+	})
+	var notFound *types.NoSuchEntityException
+	if errors.As(err, &notFound) {
+		log.Infof("Access key %s of user %s is already deleted", keyId, userName)
+		return nil
+	}
+	return err
+}
+
+// createKey creates the user's new access key. After an interruption, a key
+// unknown when the run was planned is taken as the one the interrupted step
+// created, so no second key is issued; its secret is lost.
+func (wrapper UserWrapper) createKey(user *RunUser, interrupted bool) (string, string, error) {
+	if interrupted {
+		keyId, err := wrapper.findCreatedKey(user)
+		if err != nil {
+			return "", "", err
+		}
+		if keyId != "" {
+			log.Warnf("Access key %s of user %s was created before the interruption, its secret is not available", keyId, user.UserName)
+			return keyId, "", nil
+		}
+	}
+
+	output, err := wrapper.IamClient.CreateAccessKey(context.TODO(), &iam.CreateAccessKeyInput{
+		UserName: aws.String(user.UserName),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return aws.ToString(output.AccessKey.AccessKeyId), aws.ToString(output.AccessKey.SecretAccessKey), nil
+}
+
+func (wrapper UserWrapper) findCreatedKey(user *RunUser) (string, error) {
+	output, err := wrapper.IamClient.ListAccessKeys(context.TODO(), &iam.ListAccessKeysInput{
+		UserName: aws.String(user.UserName),
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, key := range output.AccessKeyMetadata {
+		if !slices.Contains(user.KnownKeyIds, aws.ToString(key.AccessKeyId)) {
+			return aws.ToString(key.AccessKeyId), nil
+		}
+	}
+	return "", nil
+}
+
+func appendResultError(current, message string) string {
+	if current == "" {
+		return message
+	}
+	return current + "; " + message
+}
+
+// ResumeRotation continues every user of an interrupted run that has not
+// completed, from its first step that is not done. verifyTimeout bounds the
+// verification of new keys for runs planned with it.
+func (wrapper UserWrapper) ResumeRotation(run *RotationRun, verifyTimeout time.Duration) []UserData {
+	var results []UserData
+	for i := range run.Users {
+		user := &run.Users[i]
+		if !user.Pending() {
+			continue
+		}
+		log.Infof("Resuming rotation of user %s (%s)", user.UserName, user.Status)
+		results = append(results, wrapper.executeRunUser(run, user, verifyTimeout))
+	}
+	return results
+}
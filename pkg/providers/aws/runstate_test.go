@@ -0,0 +1,172 @@
+package iam
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/javiercm1410/gyro/pkg/providers/aws/fakeiam"
+)
+
+func TestResumeRotation(t *testing.T) {
+	tests := []struct {
+		name string
+		// extraKey is a key IAM holds but the run did not know of.
+		extraKey    string
+		user        RunUser
+		wantResults int
+		wantCreates int
+		wantStatus  string
+		wantKeyId   string
+		wantError   string
+	}{
+		{
+			name: "continues after the last finished step",
+			user: RunUser{Status: RunInProgress, Steps: []RunStep{
+				{Action: ActionDeactivateKey, AccessKeyId: "AKIAOLD", Status: StepDone},
+				{Action: ActionCreateKey, Status: StepPending},
+			}},
+			wantResults: 1,
+			wantCreates: 1,
+			wantStatus:  RunCompleted,
+		},
+		{
+			name:     "interrupted create that reached IAM is not repeated",
+			extraKey: "AKIAPARTIAL",
+			user: RunUser{Status: RunInProgress, Steps: []RunStep{
+				{Action: ActionDeactivateKey, AccessKeyId: "AKIAOLD", Status: StepDone},
+				{Action: ActionCreateKey, Status: StepStarted},
+			}},
+			wantResults: 1,
+			wantCreates: 0,
+			wantStatus:  RunCompleted,
+			wantKeyId:   "AKIAPARTIAL",
+			wantError:   "secret was not recorded",
+		},
+		{
+			name: "interrupted create that never reached IAM is retried",
+			user: RunUser{Status: RunInProgress, Steps: []RunStep{
+				{Action: ActionDeactivateKey, AccessKeyId: "AKIAOLD", Status: StepDone},
+				{Action: ActionCreateKey, Status: StepStarted},
+			}},
+			wantResults: 1,
+			wantCreates: 1,
+			wantStatus:  RunCompleted,
+		},
+		{
+			name: "failed deactivation is retried",
+			user: RunUser{Status: RunFailed, Steps: []RunStep{
+				{Action: ActionDeactivateKey, AccessKeyId: "AKIAOLD", Status: StepFailed, Error: "throttled"},
+				{Action: ActionCreateKey, AccessKeyId: "AKIADONE", Status: StepDone},
+			}},
+			wantResults: 1,
+			wantCreates: 0,
+			wantStatus:  RunCompleted,
+			wantKeyId:   "AKIADONE",
+		},
+		{
+			name: "completed user is left alone",
+			user: RunUser{Status: RunCompleted, Steps: []RunStep{
+				{Action: ActionCreateKey, AccessKeyId: "AKIADONE", Status: StepDone},
+			}},
+			wantStatus: RunCompleted,
+			wantKeyId:  "AKIADONE",
+		},
+		{
+			name: "rolled back user is left alone",
+			user: RunUser{Status: RunRolledBack, Steps: []RunStep{
+				{Action: ActionCreateKey, AccessKeyId: "AKIADONE", Status: StepDone},
+			}},
+			wantStatus: RunRolledBack,
+			wantKeyId:  "AKIADONE",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keys := []fakeiam.FixtureAccessKey{fixtureKey("AKIAOLD", "2022-01-01")}
+			if test.extraKey != "" {
+				keys = append(keys, fixtureKey(test.extraKey, "2026-01-01"))
+			}
+			wrapper, client := fakeWrapper(t, fakeiam.Fixture{
+				Users: []fakeiam.FixtureUser{{UserName: "alice", AccessKeys: keys}},
+			})
+
+			dir := t.TempDir()
+			run, err := newRotationRun(dir, "")
+			if err != nil {
+				t.Fatalf("newRotationRun: %v", err)
+			}
+			user := test.user
+			user.UserName = "alice"
+			user.KnownKeyIds = []string{"AKIAOLD"}
+			run.Users = []RunUser{user}
+
+			results := wrapper.ResumeRotation(run, 0)
+			if len(results) != test.wantResults {
+				t.Fatalf("got %d results, want %d: %+v", len(results), test.wantResults, results)
+			}
+			if calls := client.Calls("CreateAccessKey"); calls != test.wantCreates {
+				t.Errorf("CreateAccessKey calls = %d, want %d", calls, test.wantCreates)
+			}
+
+			if test.wantResults > 0 {
+				saved, err := LoadRotationRun(dir, run.RunId)
+				if err != nil {
+					t.Fatalf("LoadRotationRun: %v", err)
+				}
+				if status := saved.Users[0].Status; status != test.wantStatus {
+					t.Errorf("saved status = %s, want %s", status, test.wantStatus)
+				}
+			}
+			if status := run.Users[0].Status; status != test.wantStatus {
+				t.Errorf("status = %s, want %s", status, test.wantStatus)
+			}
+
+			for _, step := range run.Users[0].Steps {
+				if step.Action != ActionCreateKey {
+					continue
+				}
+				if step.AccessKeyId == "" || (test.wantKeyId != "" && step.AccessKeyId != test.wantKeyId) {
+					t.Errorf("created key = %q, want %q", step.AccessKeyId, test.wantKeyId)
+				}
+			}
+
+			if test.wantResults == 0 {
+				return
+			}
+			result := results[0].(AccessKeyRotationResult)
+			if test.wantError == "" && result.Error != "" {
+				t.Errorf("unexpected error %q", result.Error)
+			}
+			if !strings.Contains(result.Error, test.wantError) {
+				t.Errorf("error = %q, want it to contain %q", result.Error, test.wantError)
+			}
+		})
+	}
+}
+
+func TestRotationRunVerifies(t *testing.T) {
+	verify := func(status string) RunStep { return RunStep{Action: ActionVerifyKey, Status: status} }
+	create := RunStep{Action: ActionCreateKey, Status: StepPending}
+
+	tests := []struct {
+		name  string
+		users []RunUser
+		want  bool
+	}{
+		{name: "no verify steps", users: []RunUser{{Status: RunFailed, Steps: []RunStep{create}}}},
+		{name: "pending verification", users: []RunUser{{Status: RunFailed, Steps: []RunStep{create, verify(StepPending)}}}, want: true},
+		{name: "verification done", users: []RunUser{{Status: RunFailed, Steps: []RunStep{create, verify(StepDone)}}}},
+		{name: "verification skipped", users: []RunUser{{Status: RunFailed, Steps: []RunStep{create, verify(StepSkipped)}}}},
+		{name: "completed user", users: []RunUser{{Status: RunCompleted, Steps: []RunStep{create, verify(StepFailed)}}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			run := &RotationRun{Users: test.users}
+			if got := run.Verifies(); got != test.want {
+				t.Errorf("Verifies() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,420 @@
+package iam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/charmbracelet/log"
+)
+
+// Stages of a staged access key rotation. A user moves from created to
+// deactivated to completed, one step per run at most.
+const (
+	StageCreated     = "new-key-created"
+	StageDeactivated = "old-key-deactivated"
+	StageCompleted   = "completed"
+)
+
+// StagedRotation is the persisted progress of one user's staged rotation.
+type StagedRotation struct {
+	UserName      string    `json:"userName"`
+	Stage         string    `json:"stage"`
+	OldKeyIds     []string  `json:"oldKeyIds"`
+	NewKeyId      string    `json:"newKeyId"`
+	CreatedAt     time.Time `json:"createdAt"`
+	DeactivatedAt time.Time `json:"deactivatedAt,omitempty"`
+}
+
+// StagedRotationState holds every staged rotation in progress, keyed by user.
+type StagedRotationState struct {
+	Users map[string]StagedRotation `json:"users"`
+
+	path string
+}
+
+// StagedRotationResult reports what a run did for one user. Action is set
+// when the run changed IAM for that user.
+type StagedRotationResult struct {
+	Account
+	UserName        string
+	Stage           string
+	Action          string
+	OldKeyIds       []string
+	NewKeyId        string
+	SecretAccessKey string
+	Detail          string
+	Error           string
+}
+
+// DefaultStagedStatePath returns ~/.config/gyro/staged-rotation.json.
+func DefaultStagedStatePath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "staged-rotation.json"
+	}
+	return filepath.Join(home, ".config", "gyro", "staged-rotation.json")
+}
+
+// LoadStagedState reads the state file; a missing file is an empty state.
+func LoadStagedState(path string) (StagedRotationState, error) {
+	state := StagedRotationState{Users: map[string]StagedRotation{}, path: path}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("error reading staged rotation state %s: %w", path, err)
+	}
+
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return state, fmt.Errorf("error parsing staged rotation state %s: %w", path, err)
+	}
+	if state.Users == nil {
+		state.Users = map[string]StagedRotation{}
+	}
+	return state, nil
+}
+
+// Save writes the state file, creating its directory when needed.
+func (state StagedRotationState) Save(path string) error {
+	marshaled, err := json.MarshalIndent(state, "", "   ")
+	if err != nil {
+		return fmt.Errorf("error marshaling staged rotation state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("error creating directory for %s: %w", path, err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, marshaled, 0o600); err != nil {
+		return fmt.Errorf("error writing staged rotation state %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error writing staged rotation state %s: %w", path, err)
+	}
+	return nil
+}
+
+// save persists the state after a user's transition so an interrupted run
+// does not lose the keys it already created. Errors are logged; the caller
+// saves once more at the end and reports a failure there.
+func (state *StagedRotationState) save() {
+	if state.path == "" {
+		return
+	}
+	if err := state.Save(state.path); err != nil {
+		log.Errorf("Failed to save staged rotation state: %v", err)
+	}
+}
+
+// StagedUsers returns the users AdvanceStagedRotation works on: the users in
+// keys, which already passed the selection, and the users in state that pass
+// it too. State users are matched against --username, --exclude, the user
+// filters and --skip-current-user, so a narrowed run does not advance
+// rotations it was not asked about. Names are sorted.
+func (wrapper UserWrapper) StagedUsers(keys []UserData, inputs RotateWrapperInputs, state StagedRotationState) ([]string, error) {
+	listed := map[string]bool{}
+	userNames := make([]string, 0, len(keys)+len(state.Users))
+	for _, keyData := range keys {
+		if user, ok := keyData.(UserAccessKeyData); ok {
+			listed[user.UserName] = true
+			userNames = append(userNames, user.UserName)
+		}
+	}
+
+	var pending []string
+	for name := range state.Users {
+		if listed[name] {
+			continue
+		}
+		if inputs.UserName != "" && name != inputs.UserName {
+			continue
+		}
+		pending = append(pending, name)
+	}
+	sort.Strings(pending)
+
+	if len(pending) > 0 && inputs.SkipCurrentUser {
+		identity, err := wrapper.GetCallerIdentity()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't resolve the current user for --skip-current-user: %w", err)
+		}
+		pending = slices.DeleteFunc(pending, func(name string) bool {
+			if name == identity.UserName {
+				log.Infof("Skipping current user %s", name)
+				return true
+			}
+			return false
+		})
+	}
+
+	if len(pending) > 0 && (inputs.Filter.isSet() || len(inputs.Exclude) > 0) {
+		selector, err := newUserSelector(inputs.GetWrapperInputs)
+		if err != nil {
+			return nil, err
+		}
+		var kept []string
+		for _, name := range pending {
+			output, err := wrapper.IamClient.GetUser(context.TODO(), &iam.GetUserInput{UserName: aws.String(name)})
+			if err != nil {
+				return nil, fmt.Errorf("user %s: %w", name, classifyError(err, ErrUserNotFound))
+			}
+			keep, err := selector.keep(*output.User)
+			if err != nil {
+				return nil, err
+			}
+			if keep {
+				kept = append(kept, name)
+			} else {
+				log.Debugf("Not advancing the staged rotation of user %s: it does not match the user filters", name)
+			}
+		}
+		pending = kept
+	}
+
+	userNames = append(userNames, pending...)
+	sort.Strings(userNames)
+	return userNames, nil
+}
+
+// AdvanceStagedRotation moves each of userNames, as returned by StagedUsers,
+// one stage forward:
+//
+//  1. users with expired active keys get a new key (new-key-created),
+//  2. once the new key has been used, or GracePeriod has passed since it was
+//     created, the old keys are deactivated (old-key-deactivated),
+//  3. DeleteAfter later the old keys are deleted (completed).
+//
+// The state is updated in place and written back after every user that
+// changes stage; with DryRun set the planned steps are returned and nothing
+// is changed.
+func (wrapper UserWrapper) AdvanceStagedRotation(keys []UserData, userNames []string, inputs RotateWrapperInputs, state *StagedRotationState) []UserData {
+	var results []UserData
+	now := time.Now()
+
+	listed := map[string]UserAccessKeyData{}
+	for _, keyData := range keys {
+		if user, ok := keyData.(UserAccessKeyData); ok {
+			listed[user.UserName] = user
+		}
+	}
+
+	for _, name := range userNames {
+		staged, inProgress := state.Users[name]
+		if !inProgress {
+			if policy := listed[name].Policy; policy.Exempt {
+				log.Infof("Skipping user %s: %s", name, policy.ExemptionStatus())
+				continue
+			}
+			if result, ok := wrapper.startStagedRotation(listed[name], inputs, state, now); ok {
+				results = append(results, result)
+			}
+			continue
+		}
+
+		result := StagedRotationResult{
+			UserName:  name,
+			Stage:     staged.Stage,
+			OldKeyIds: staged.OldKeyIds,
+			NewKeyId:  staged.NewKeyId,
+		}
+
+		switch staged.Stage {
+		case StageCreated:
+			ready, reason, err := wrapper.newKeyReady(staged, inputs.GracePeriod, now)
+			if err != nil {
+				result.Error = err.Error()
+				break
+			}
+			if !ready {
+				result.Detail = reason
+				break
+			}
+			if inputs.DryRun {
+				result.Detail = fmt.Sprintf("would deactivate %s (%s)", strings.Join(staged.OldKeyIds, ", "), reason)
+				break
+			}
+			if err := wrapper.setKeysStatus(name, staged.OldKeyIds, types.StatusTypeInactive); err != nil {
+				result.Error = err.Error()
+				break
+			}
+			staged.Stage = StageDeactivated
+			staged.DeactivatedAt = now
+			state.Users[name] = staged
+			state.save()
+			result.Stage = staged.Stage
+			result.Action = ActionDeactivateKey
+			result.Detail = fmt.Sprintf("old keys deactivated (%s)", reason)
+			log.Infof("Deactivated old access keys of user %s", name)
+
+		case StageDeactivated:
+			deleteAt := staged.DeactivatedAt.Add(inputs.DeleteAfter)
+			if now.Before(deleteAt) {
+				result.Detail = fmt.Sprintf("old keys will be deleted after %s", deleteAt.Format(time.DateTime))
+				break
+			}
+			if inputs.DryRun {
+				result.Detail = fmt.Sprintf("would delete %s", strings.Join(staged.OldKeyIds, ", "))
+				break
+			}
+			if err := wrapper.deleteKeys(name, staged.OldKeyIds); err != nil {
+				result.Error = err.Error()
+				break
+			}
+			delete(state.Users, name)
+			state.save()
+			result.Stage = StageCompleted
+			result.Action = ActionDeleteKey
+			result.Detail = "old keys deleted"
+			log.Infof("Completed staged rotation for user %s", name)
+
+		default:
+			result.Error = fmt.Sprintf("unknown stage %q in state file", staged.Stage)
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// startStagedRotation creates the replacement key for a user with expired
+// active keys. Inactive keys are deleted first when the user is at the two key
+// limit; two active keys cannot be staged.
+func (wrapper UserWrapper) startStagedRotation(user UserAccessKeyData, inputs RotateWrapperInputs, state *StagedRotationState, now time.Time) (StagedRotationResult, bool) {
+	result := StagedRotationResult{UserName: user.UserName}
+
+	var oldKeys, inactiveKeys []string
+	for _, key := range user.Keys {
+		switch {
+		case key.KeyStatus == types.StatusTypeInactive:
+			inactiveKeys = append(inactiveKeys, *key.Id)
+		case key.IsExpired:
+			if !inputs.AllowCurrentKey && *key.Id == wrapper.AccessKeyId {
+				log.Warnf("Not staging access key %s of user %s: gyro is authenticated with it", *key.Id, user.UserName)
+				continue
+			}
+			if key.Exemption != nil {
+				log.Infof("Not staging access key %s of user %s: %s", *key.Id, user.UserName, key.ExemptionStatus())
+				continue
+			}
+			oldKeys = append(oldKeys, *key.Id)
+		}
+	}
+	if len(oldKeys) == 0 {
+		return result, false
+	}
+	result.OldKeyIds = oldKeys
+
+	var toDelete []string
+	if len(user.Keys) >= 2 {
+		if len(inactiveKeys) == 0 {
+			result.Error = "user already has two active keys, no room for a new key"
+			return result, true
+		}
+		toDelete = inactiveKeys[:1]
+	}
+
+	if inputs.DryRun {
+		result.Detail = "would create a new key"
+		if len(toDelete) > 0 {
+			result.Detail = fmt.Sprintf("would delete inactive %s and create a new key", strings.Join(toDelete, ", "))
+		}
+		return result, true
+	}
+
+	if err := wrapper.deleteKeys(user.UserName, toDelete); err != nil {
+		result.Error = err.Error()
+		return result, true
+	}
+
+	createOutput, err := wrapper.IamClient.CreateAccessKey(context.TODO(), &iam.CreateAccessKeyInput{
+		UserName: aws.String(user.UserName),
+	})
+	if err != nil {
+		result.Error = fmt.Sprintf("create: %v", err)
+		return result, true
+	}
+
+	state.Users[user.UserName] = StagedRotation{
+		UserName:  user.UserName,
+		Stage:     StageCreated,
+		OldKeyIds: oldKeys,
+		NewKeyId:  *createOutput.AccessKey.AccessKeyId,
+		CreatedAt: now,
+	}
+	state.save()
+	log.Infof("Created access key %s for user %s, old keys stay active until it is used", *createOutput.AccessKey.AccessKeyId, user.UserName)
+
+	result.Stage = StageCreated
+	result.Action = ActionCreateKey
+	result.NewKeyId = *createOutput.AccessKey.AccessKeyId
+	result.SecretAccessKey = *createOutput.AccessKey.SecretAccessKey
+	result.Detail = "distribute the new key; old keys stay active until it is used"
+	return result, true
+}
+
+// newKeyReady reports whether the old keys can be retired: the new key has
+// been used or the grace period has passed.
+func (wrapper UserWrapper) newKeyReady(staged StagedRotation, gracePeriod time.Duration, now time.Time) (bool, string, error) {
+	lastUsed, err := wrapper.IamClient.GetAccessKeyLastUsed(context.TODO(), &iam.GetAccessKeyLastUsedInput{
+		AccessKeyId: aws.String(staged.NewKeyId),
+	})
+	if err != nil {
+		return false, "", fmt.Errorf("last used for %s: %w", staged.NewKeyId, err)
+	}
+
+	if lastUsed.AccessKeyLastUsed != nil && lastUsed.AccessKeyLastUsed.LastUsedDate != nil {
+		return true, fmt.Sprintf("new key used %s", lastUsed.AccessKeyLastUsed.LastUsedDate.Format(time.DateTime)), nil
+	}
+
+	graceEnd := staged.CreatedAt.Add(gracePeriod)
+	if !now.Before(graceEnd) {
+		return true, "grace period elapsed", nil
+	}
+	return false, fmt.Sprintf("waiting for the new key to be used or until %s", graceEnd.Format(time.DateTime)), nil
+}
+
+func (wrapper UserWrapper) setKeysStatus(userName string, keyIds []string, status types.StatusType) error {
+	for _, keyId := range keyIds {
+		_, err := wrapper.IamClient.UpdateAccessKey(context.TODO(), &iam.UpdateAccessKeyInput{
+			UserName:    aws.String(userName),
+			AccessKeyId: aws.String(keyId),
+			Status:      status,
+		})
+		if err != nil {
+			return fmt.Errorf("update %s: %w", keyId, err)
+		}
+	}
+	return nil
+}
+
+func (wrapper UserWrapper) deleteKeys(userName string, keyIds []string) error {
+	for _, keyId := range keyIds {
+		_, err := wrapper.IamClient.DeleteAccessKey(context.TODO(), &iam.DeleteAccessKeyInput{
+			UserName:    aws.String(userName),
+			AccessKeyId: aws.String(keyId),
+		})
+		if err != nil {
+			var notFound *types.NoSuchEntityException
+			if errors.As(err, &notFound) {
+				log.Warnf("Access key %s of user %s is already gone", keyId, userName)
+				continue
+			}
+			return fmt.Errorf("delete %s: %w", keyId, err)
+		}
+	}
+	return nil
+}
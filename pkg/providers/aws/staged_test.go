@@ -0,0 +1,64 @@
+package iam
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/javiercm1410/gyro/pkg/providers/aws/fakeiam"
+)
+
+func TestStagedUsers(t *testing.T) {
+	wrapper, _ := fakeWrapper(t, fakeiam.Fixture{
+		Caller: &fakeiam.FixtureCaller{UserName: "ops", AccessKeyId: "AKIAOPS"},
+		Users: []fakeiam.FixtureUser{
+			{UserName: "alice", Path: "/svc/"},
+			{UserName: "bob", Path: "/"},
+			{UserName: "carol", Path: "/"},
+			{UserName: "ops", Path: "/", AccessKeys: []fakeiam.FixtureAccessKey{fixtureKey("AKIAOPS", "2026-01-01")}},
+		},
+	})
+
+	staged := StagedRotation{Stage: StageDeactivated, OldKeyIds: []string{"AKIAOLD"}, DeactivatedAt: time.Now()}
+	state := StagedRotationState{Users: map[string]StagedRotation{"alice": staged, "bob": staged, "ops": staged}}
+	listed := []UserData{UserAccessKeyData{UserName: "carol"}}
+
+	tests := []struct {
+		name   string
+		keys   []UserData
+		inputs RotateWrapperInputs
+		want   []string
+	}{
+		{name: "listed and state users", keys: listed, want: []string{"alice", "bob", "carol", "ops"}},
+		{name: "username", inputs: RotateWrapperInputs{GetWrapperInputs: GetWrapperInputs{UserName: "alice"}}, want: []string{"alice"}},
+		{name: "exclude", keys: listed, inputs: RotateWrapperInputs{GetWrapperInputs: GetWrapperInputs{Exclude: []string{"bob"}}}, want: []string{"alice", "carol", "ops"}},
+		{name: "user filters", keys: listed, inputs: RotateWrapperInputs{GetWrapperInputs: GetWrapperInputs{Filter: UserFilter{PathPrefix: "/svc/"}}}, want: []string{"alice", "carol"}},
+		{name: "skip current user", keys: listed, inputs: RotateWrapperInputs{SkipCurrentUser: true}, want: []string{"alice", "bob", "carol"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inputs := test.inputs
+			inputs.Client = wrapper
+			got, err := wrapper.StagedUsers(test.keys, inputs, state)
+			if err != nil {
+				t.Fatalf("StagedUsers: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("users = %q, want %q", got, test.want)
+			}
+
+			inputs.DryRun = true
+			var advanced []string
+			for _, result := range wrapper.AdvanceStagedRotation(test.keys, got, inputs, &state) {
+				advanced = append(advanced, result.(StagedRotationResult).UserName)
+			}
+			// carol has no expired key, so nothing starts for her.
+			want := slices.DeleteFunc(slices.Clone(test.want), func(name string) bool { return name == "carol" })
+			if !reflect.DeepEqual(advanced, want) {
+				t.Errorf("advanced = %q, want %q", advanced, want)
+			}
+		})
+	}
+}
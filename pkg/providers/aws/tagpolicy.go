@@ -0,0 +1,181 @@
+package iam
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/charmbracelet/log"
+	"github.com/javiercm1410/gyro/pkg/exemptions"
+)
+
+// IAM user tags that override the global rotation policy for one user.
+const (
+	// TagMaxAge is the number of days after which the user's credentials are
+	// stale, replacing --age.
+	TagMaxAge = "gyro:max-age"
+	// TagExempt set to true keeps the user out of rotation.
+	TagExempt = "gyro:exempt"
+	// TagExemptUntil keeps the user out of rotation until the given date
+	// (YYYY-MM-DD or RFC 3339).
+	TagExemptUntil = "gyro:exempt-until"
+	// TagOwner names the person or team responsible for the user.
+	TagOwner = "gyro:owner"
+)
+
+// UserPolicy is the rotation policy of one user: the global --age unless its
+// gyro:* tags or the exemptions file say otherwise.
+type UserPolicy struct {
+	// MaxAge is the number of days after which credentials are stale.
+	MaxAge      int
+	Exempt      bool
+	ExemptUntil *time.Time
+	Owner       string
+	// Exemption is the exemptions file entry covering the whole user.
+	Exemption *exemptions.Exemption
+}
+
+// policyFromTags applies the gyro:* tags to the global policy. Malformed
+// values are logged and ignored.
+func policyFromTags(userName string, tags map[string]string, defaultAge int, now time.Time) UserPolicy {
+	policy := UserPolicy{MaxAge: defaultAge, Owner: tags[TagOwner]}
+
+	if value, ok := tags[TagMaxAge]; ok {
+		maxAge, err := strconv.Atoi(value)
+		if err != nil || maxAge < 0 {
+			log.Warnf("Ignoring tag %s=%q of user %s: expected a number of days", TagMaxAge, value, userName)
+		} else {
+			policy.MaxAge = maxAge
+		}
+	}
+
+	if value, ok := tags[TagExempt]; ok {
+		exempt, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Warnf("Ignoring tag %s=%q of user %s: expected true or false", TagExempt, value, userName)
+		} else {
+			policy.Exempt = exempt
+		}
+	}
+
+	if value, ok := tags[TagExemptUntil]; ok {
+		until, err := parseExemptUntil(value)
+		if err != nil {
+			log.Warnf("Ignoring tag %s=%q of user %s: expected YYYY-MM-DD or an RFC 3339 time", TagExemptUntil, value, userName)
+		} else {
+			policy.ExemptUntil = &until
+			if now.Before(until) {
+				policy.Exempt = true
+			}
+		}
+	}
+
+	return policy
+}
+
+func parseExemptUntil(value string) (time.Time, error) {
+	if until, err := time.Parse(time.DateOnly, value); err == nil {
+		return until, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// tagsDenied remembers the accounts where ListUserTags was refused, so the
+// fallback to the global policy is only logged once per account.
+var tagsDenied sync.Map
+
+// userPolicy reads the tags of a user and returns its rotation policy. Without
+// TagPolicy, or when gyro may not list user tags, every user gets the global
+// policy.
+func (input GetWrapperInputs) userPolicy(userName string) (UserPolicy, error) {
+	if !input.TagPolicy {
+		return input.withExemption(userName, UserPolicy{MaxAge: input.Age}), nil
+	}
+	tags, err := input.Client.userTags(userName)
+	if isAccessDenied(err) {
+		if _, warned := tagsDenied.LoadOrStore(input.Client.Account.AccountId, true); !warned {
+			log.Warnf("Not allowed to list user tags, gyro:* tags are ignored and every user gets the global policy: %v", err)
+		}
+		return input.withExemption(userName, UserPolicy{MaxAge: input.Age}), nil
+	}
+	if err != nil {
+		return UserPolicy{}, err
+	}
+	return input.withExemption(userName, policyFromTags(userName, tags, input.Age, time.Now())), nil
+}
+
+// withExemption marks the user exempt when the exemptions file has an active
+// entry for all of its credentials.
+func (input GetWrapperInputs) withExemption(userName string, policy UserPolicy) UserPolicy {
+	if exemption := input.Exemptions.ForUser(input.Client.Account.AccountId, userName, time.Now()); exemption != nil {
+		policy.Exempt = true
+		policy.Exemption = exemption
+	}
+	return policy
+}
+
+// exemptKeys attaches the active exemptions file entries to the user's keys.
+func (input GetWrapperInputs) exemptKeys(keyData *UserAccessKeyData) {
+	now := time.Now()
+	for i, key := range keyData.Keys {
+		keyData.Keys[i].Exemption = input.Exemptions.ForKey(input.Client.Account.AccountId, keyData.UserName, aws.ToString(key.Id), now)
+	}
+}
+
+// reportPolicies looks up the policy of every user in the report rows on
+// input.Concurrency workers. A saved report has no API access, so every user
+// gets the global policy; so does the root account, which has no tags.
+func (input GetWrapperInputs) reportPolicies(rows []CredentialReportRow) (map[string]UserPolicy, map[string]error) {
+	policies := make(map[string]UserPolicy, len(rows))
+	failures := map[string]error{}
+
+	var users []types.User
+	for _, row := range rows {
+		if input.ReportFile != "" || row.User == RootAccountName {
+			policies[row.User] = input.withExemption(row.User, UserPolicy{MaxAge: input.Age})
+			continue
+		}
+		users = append(users, types.User{UserName: aws.String(row.User)})
+	}
+
+	var mu sync.Mutex
+	ForEachUser(users, input.Concurrency, func(user types.User) {
+		name := aws.ToString(user.UserName)
+		policy, err := input.userPolicy(name)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			failures[name] = classifyError(err, ErrUserNotFound)
+			return
+		}
+		policies[name] = policy
+	})
+
+	return policies, failures
+}
+
+// ExemptionStatus describes why a user is exempt from rotation, e.g.
+// "exempt until 2026-12-01", and is empty for users rotated as usual.
+func (policy UserPolicy) ExemptionStatus() string {
+	switch {
+	case !policy.Exempt:
+		return ""
+	case policy.Exemption != nil:
+		return "exempt until " + policy.Exemption.Expires.Format(time.DateOnly)
+	case policy.ExemptUntil != nil && time.Now().Before(*policy.ExemptUntil):
+		return "exempt until " + policy.ExemptUntil.Format(time.DateOnly)
+	}
+	return "exempt"
+}
+
+// ExemptionStatus describes the exemptions file entry of the key, and is
+// empty for keys rotated as usual.
+func (key AccessKeyData) ExemptionStatus() string {
+	if key.Exemption == nil {
+		return ""
+	}
+	return "exempt until " + key.Exemption.Expires.Format(time.DateOnly)
+}
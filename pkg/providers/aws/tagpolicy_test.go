@@ -0,0 +1,120 @@
+package iam
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+	"github.com/javiercm1410/gyro/pkg/providers/aws/fakeiam"
+)
+
+func TestPolicyFromTags(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		tags       map[string]string
+		wantMaxAge int
+		wantExempt bool
+		wantOwner  string
+	}{
+		{name: "no tags", wantMaxAge: 90},
+		{name: "max age", tags: map[string]string{TagMaxAge: "30"}, wantMaxAge: 30},
+		{name: "malformed max age is ignored", tags: map[string]string{TagMaxAge: "monthly"}, wantMaxAge: 90},
+		{name: "negative max age is ignored", tags: map[string]string{TagMaxAge: "-1"}, wantMaxAge: 90},
+		{name: "exempt", tags: map[string]string{TagExempt: "true"}, wantMaxAge: 90, wantExempt: true},
+		{name: "malformed exempt is ignored", tags: map[string]string{TagExempt: "yes please"}, wantMaxAge: 90},
+		{name: "exempt until a later date", tags: map[string]string{TagExemptUntil: "2026-06-01"}, wantMaxAge: 90, wantExempt: true},
+		{name: "exempt until an RFC 3339 time", tags: map[string]string{TagExemptUntil: "2026-01-02T00:00:00Z"}, wantMaxAge: 90, wantExempt: true},
+		{name: "exemption expired", tags: map[string]string{TagExemptUntil: "2025-12-01"}, wantMaxAge: 90},
+		{name: "owner", tags: map[string]string{TagOwner: "platform"}, wantMaxAge: 90, wantOwner: "platform"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := policyFromTags("alice", test.tags, 90, now)
+			if policy.MaxAge != test.wantMaxAge || policy.Exempt != test.wantExempt || policy.Owner != test.wantOwner {
+				t.Errorf("policy = %+v, want max age %d, exempt %v, owner %q", policy, test.wantMaxAge, test.wantExempt, test.wantOwner)
+			}
+		})
+	}
+}
+
+func TestUserPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		tagPolicy  bool
+		failWith   error
+		wantMaxAge int
+		wantCalls  int
+		wantErr    bool
+	}{
+		{name: "tag policy", tagPolicy: true, wantMaxAge: 30, wantCalls: 1},
+		{name: "tag policy disabled", wantMaxAge: 90, wantCalls: 0},
+		{
+			name:       "access denied falls back to the global policy",
+			tagPolicy:  true,
+			failWith:   &smithy.GenericAPIError{Code: "AccessDenied", Message: "not authorized to perform iam:ListUserTags"},
+			wantMaxAge: 90,
+			wantCalls:  1,
+		},
+		{
+			name:      "other errors are returned",
+			tagPolicy: true,
+			failWith:  &smithy.GenericAPIError{Code: "ServiceFailure"},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wrapper, client := fakeWrapper(t, fakeiam.Fixture{
+				Users: []fakeiam.FixtureUser{{UserName: "alice", Tags: map[string]string{TagMaxAge: "30"}}},
+			})
+			if test.failWith != nil {
+				client.FailOn("ListUserTags", test.failWith)
+			}
+
+			input := GetWrapperInputs{Age: 90, Client: wrapper, TagPolicy: test.tagPolicy}
+			policy, err := input.userPolicy("alice")
+			if test.wantErr != (err != nil) {
+				t.Fatalf("userPolicy error = %v, want error %v", err, test.wantErr)
+			}
+			if policy.MaxAge != test.wantMaxAge {
+				t.Errorf("MaxAge = %d, want %d", policy.MaxAge, test.wantMaxAge)
+			}
+			if calls := client.Calls("ListUserTags"); calls != test.wantCalls {
+				t.Errorf("ListUserTags calls = %d, want %d", calls, test.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRotateAccessKeysSkipsExemptUsers(t *testing.T) {
+	wrapper, client := fakeWrapper(t, fakeiam.Fixture{
+		Users: []fakeiam.FixtureUser{
+			{UserName: "alice", Tags: map[string]string{TagExempt: "true"}, AccessKeys: []fakeiam.FixtureAccessKey{fixtureKey("AKIAALICE", "2022-01-01")}},
+			{UserName: "bob", AccessKeys: []fakeiam.FixtureAccessKey{fixtureKey("AKIABOB", "2022-01-01")}},
+		},
+	})
+
+	inputs := RotateWrapperInputs{
+		GetWrapperInputs: GetWrapperInputs{Age: 90, TimeZone: "UTC", Concurrency: 1, Client: wrapper, TagPolicy: true},
+		SkipConfirmation: true,
+	}
+	keys, err := GetUserAccessKey(inputs.GetWrapperInputs)
+	if err != nil {
+		t.Fatalf("GetUserAccessKey: %v", err)
+	}
+	if err := CheckResults(wrapper.RotateAccessKeys(keys, inputs)); err != nil {
+		t.Fatalf("RotateAccessKeys: %v", err)
+	}
+
+	if keys := client.AccessKeys("alice"); len(keys) != 1 {
+		t.Errorf("exempt user has %d keys, want the untouched one", len(keys))
+	}
+	if keys := client.AccessKeys("bob"); len(keys) != 2 {
+		t.Errorf("bob has %d keys, want the old and a new one", len(keys))
+	}
+}
@@ -0,0 +1,271 @@
+package iam
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/charmbracelet/log"
+)
+
+// Defaults for --concurrency and --rate-limit.
+const (
+	DefaultConcurrency = 8
+	DefaultRateLimit   = 10.0
+)
+
+// RetryPolicy controls the exponential backoff applied to throttled and
+// transient IAM errors. Every wait is drawn uniformly from zero to the
+// current backoff (full jitter).
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultRetryPolicy is used by WithRateLimit.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 6,
+	BaseDelay:   200 * time.Millisecond,
+	MaxDelay:    20 * time.Second,
+}
+
+// RateLimiter spaces calls evenly so that at most a fixed number start per
+// second, shared by every worker.
+type RateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// NewRateLimiter returns a limiter allowing perSecond calls per second. A
+// non-positive rate disables limiting.
+func NewRateLimiter(perSecond float64) *RateLimiter {
+	if perSecond <= 0 {
+		return &RateLimiter{}
+	}
+	return &RateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// Wait blocks until the caller may issue its next call or ctx is done.
+func (limiter *RateLimiter) Wait(ctx context.Context) error {
+	if limiter == nil || limiter.interval == 0 {
+		return nil
+	}
+
+	limiter.mu.Lock()
+	now := time.Now()
+	slot := limiter.next
+	if slot.Before(now) {
+		slot = now
+	}
+	limiter.next = slot.Add(limiter.interval)
+	limiter.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(slot))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WithRateLimit returns a copy of the wrapper whose IAM calls go through a
+// shared rate limiter allowing perSecond calls, and are retried with
+// DefaultRetryPolicy when IAM throttles or fails transiently.
+func (wrapper UserWrapper) WithRateLimit(perSecond float64) UserWrapper {
+	if wrapper.IamClient == nil {
+		return wrapper
+	}
+	wrapper.IamClient = &throttledClient{
+		next:    wrapper.IamClient,
+		limiter: NewRateLimiter(perSecond),
+		policy:  DefaultRetryPolicy,
+	}
+	return wrapper
+}
+
+// isRetryable reports whether err is a throttling or transient error worth
+// retrying, using the SDK's classification.
+func isRetryable(err error) bool {
+	if retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err) == aws.TrueTernary {
+		return true
+	}
+	if retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary {
+		return true
+	}
+	var serviceFailure *types.ServiceFailureException
+	return errors.As(err, &serviceFailure)
+}
+
+// callWithRetry runs call under the limiter and retries it with backoff while
+// it fails with a retryable error.
+func callWithRetry[T any](ctx context.Context, limiter *RateLimiter, policy RetryPolicy, operation string, call func() (T, error)) (T, error) {
+	backoff := policy.BaseDelay
+	for attempt := 1; ; attempt++ {
+		if err := limiter.Wait(ctx); err != nil {
+			var zero T
+			return zero, err
+		}
+
+		output, err := call()
+		if err == nil || attempt >= policy.MaxAttempts || !isRetryable(err) {
+			return output, err
+		}
+
+		wait := time.Duration(rand.Int64N(int64(backoff) + 1))
+		log.Debugf("%s failed (attempt %d/%d), retrying in %s: %v", operation, attempt, policy.MaxAttempts, wait.Round(time.Millisecond), err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			var zero T
+			return zero, ctx.Err()
+		}
+
+		backoff *= 2
+		if backoff > policy.MaxDelay {
+			backoff = policy.MaxDelay
+		}
+	}
+}
+
+// ForEachUser runs fn for every user on at most concurrency workers and waits
+// for them to finish.
+func ForEachUser(users []types.User, concurrency int, fn func(user types.User)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	queue := make(chan types.User)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency && i < len(users); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for user := range queue {
+				fn(user)
+			}
+		}()
+	}
+
+	for _, user := range users {
+		queue <- user
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// throttledClient is an IamAPI that applies a rate limiter and retries to
+// every call of the client it wraps.
+type throttledClient struct {
+	next    IamAPI
+	limiter *RateLimiter
+	policy  RetryPolicy
+}
+
+func (c *throttledClient) ListUsers(ctx context.Context, params *iam.ListUsersInput, optFns ...func(*iam.Options)) (*iam.ListUsersOutput, error) {
+	return callWithRetry(ctx, c.limiter, c.policy, "ListUsers", func() (*iam.ListUsersOutput, error) {
+		return c.next.ListUsers(ctx, params, optFns...)
+	})
+}
+
+func (c *throttledClient) GetUser(ctx context.Context, params *iam.GetUserInput, optFns ...func(*iam.Options)) (*iam.GetUserOutput, error) {
+	return callWithRetry(ctx, c.limiter, c.policy, "GetUser", func() (*iam.GetUserOutput, error) {
+		return c.next.GetUser(ctx, params, optFns...)
+	})
+}
+
+func (c *throttledClient) ListAccessKeys(ctx context.Context, params *iam.ListAccessKeysInput, optFns ...func(*iam.Options)) (*iam.ListAccessKeysOutput, error) {
+	return callWithRetry(ctx, c.limiter, c.policy, "ListAccessKeys", func() (*iam.ListAccessKeysOutput, error) {
+		return c.next.ListAccessKeys(ctx, params, optFns...)
+	})
+}
+
+func (c *throttledClient) GetAccessKeyLastUsed(ctx context.Context, params *iam.GetAccessKeyLastUsedInput, optFns ...func(*iam.Options)) (*iam.GetAccessKeyLastUsedOutput, error) {
+	return callWithRetry(ctx, c.limiter, c.policy, "GetAccessKeyLastUsed", func() (*iam.GetAccessKeyLastUsedOutput, error) {
+		return c.next.GetAccessKeyLastUsed(ctx, params, optFns...)
+	})
+}
+
+func (c *throttledClient) CreateAccessKey(ctx context.Context, params *iam.CreateAccessKeyInput, optFns ...func(*iam.Options)) (*iam.CreateAccessKeyOutput, error) {
+	return callWithRetry(ctx, c.limiter, c.policy, "CreateAccessKey", func() (*iam.CreateAccessKeyOutput, error) {
+		return c.next.CreateAccessKey(ctx, params, optFns...)
+	})
+}
+
+func (c *throttledClient) UpdateAccessKey(ctx context.Context, params *iam.UpdateAccessKeyInput, optFns ...func(*iam.Options)) (*iam.UpdateAccessKeyOutput, error) {
+	return callWithRetry(ctx, c.limiter, c.policy, "UpdateAccessKey", func() (*iam.UpdateAccessKeyOutput, error) {
+		return c.next.UpdateAccessKey(ctx, params, optFns...)
+	})
+}
+
+func (c *throttledClient) DeleteAccessKey(ctx context.Context, params *iam.DeleteAccessKeyInput, optFns ...func(*iam.Options)) (*iam.DeleteAccessKeyOutput, error) {
+	return callWithRetry(ctx, c.limiter, c.policy, "DeleteAccessKey", func() (*iam.DeleteAccessKeyOutput, error) {
+		return c.next.DeleteAccessKey(ctx, params, optFns...)
+	})
+}
+
+func (c *throttledClient) GetLoginProfile(ctx context.Context, params *iam.GetLoginProfileInput, optFns ...func(*iam.Options)) (*iam.GetLoginProfileOutput, error) {
+	return callWithRetry(ctx, c.limiter, c.policy, "GetLoginProfile", func() (*iam.GetLoginProfileOutput, error) {
+		return c.next.GetLoginProfile(ctx, params, optFns...)
+	})
+}
+
+func (c *throttledClient) UpdateLoginProfile(ctx context.Context, params *iam.UpdateLoginProfileInput, optFns ...func(*iam.Options)) (*iam.UpdateLoginProfileOutput, error) {
+	return callWithRetry(ctx, c.limiter, c.policy, "UpdateLoginProfile", func() (*iam.UpdateLoginProfileOutput, error) {
+		return c.next.UpdateLoginProfile(ctx, params, optFns...)
+	})
+}
+
+func (c *throttledClient) DeleteLoginProfile(ctx context.Context, params *iam.DeleteLoginProfileInput, optFns ...func(*iam.Options)) (*iam.DeleteLoginProfileOutput, error) {
+	return callWithRetry(ctx, c.limiter, c.policy, "DeleteLoginProfile", func() (*iam.DeleteLoginProfileOutput, error) {
+		return c.next.DeleteLoginProfile(ctx, params, optFns...)
+	})
+}
+
+func (c *throttledClient) GenerateCredentialReport(ctx context.Context, params *iam.GenerateCredentialReportInput, optFns ...func(*iam.Options)) (*iam.GenerateCredentialReportOutput, error) {
+	return callWithRetry(ctx, c.limiter, c.policy, "GenerateCredentialReport", func() (*iam.GenerateCredentialReportOutput, error) {
+		return c.next.GenerateCredentialReport(ctx, params, optFns...)
+	})
+}
+
+func (c *throttledClient) GetCredentialReport(ctx context.Context, params *iam.GetCredentialReportInput, optFns ...func(*iam.Options)) (*iam.GetCredentialReportOutput, error) {
+	return callWithRetry(ctx, c.limiter, c.policy, "GetCredentialReport", func() (*iam.GetCredentialReportOutput, error) {
+		return c.next.GetCredentialReport(ctx, params, optFns...)
+	})
+}
+
+func (c *throttledClient) GetAccountPasswordPolicy(ctx context.Context, params *iam.GetAccountPasswordPolicyInput, optFns ...func(*iam.Options)) (*iam.GetAccountPasswordPolicyOutput, error) {
+	return callWithRetry(ctx, c.limiter, c.policy, "GetAccountPasswordPolicy", func() (*iam.GetAccountPasswordPolicyOutput, error) {
+		return c.next.GetAccountPasswordPolicy(ctx, params, optFns...)
+	})
+}
+
+func (c *throttledClient) GetGroup(ctx context.Context, params *iam.GetGroupInput, optFns ...func(*iam.Options)) (*iam.GetGroupOutput, error) {
+	return callWithRetry(ctx, c.limiter, c.policy, "GetGroup", func() (*iam.GetGroupOutput, error) {
+		return c.next.GetGroup(ctx, params, optFns...)
+	})
+}
+
+func (c *throttledClient) ListUserTags(ctx context.Context, params *iam.ListUserTagsInput, optFns ...func(*iam.Options)) (*iam.ListUserTagsOutput, error) {
+	return callWithRetry(ctx, c.limiter, c.policy, "ListUserTags", func() (*iam.ListUserTagsOutput, error) {
+		return c.next.ListUserTags(ctx, params, optFns...)
+	})
+}
+
+func (c *throttledClient) ListAccountAliases(ctx context.Context, params *iam.ListAccountAliasesInput, optFns ...func(*iam.Options)) (*iam.ListAccountAliasesOutput, error) {
+	return callWithRetry(ctx, c.limiter, c.policy, "ListAccountAliases", func() (*iam.ListAccountAliasesOutput, error) {
+		return c.next.ListAccountAliases(ctx, params, optFns...)
+	})
+}
@@ -2,7 +2,8 @@ package iam
 
 import (
 	"context"
-	"math/rand"
+	"errors"
+	"fmt"
 	"sort"
 	"sync"
 	"time"
@@ -14,42 +15,72 @@ import (
 )
 
 type UserLoginData struct {
+	Account
 	UserName     string
 	LastUsedTime time.Time
 	LoginProfile *types.LoginProfile
+	// PasswordLastChanged and MfaActive are only known when the data comes
+	// from the credential report.
+	PasswordLastChanged time.Time
+	MfaActive           *bool
+	// Policy is the user's rotation policy, from its gyro:* tags.
+	Policy UserPolicy
+	// Error is set when the user's login profile could not be read.
+	Error string
 }
 
 type LoginProfileRotationResult struct {
+	Account
 	UserName string
+	Action   string
 	Password string
+	Error    string
 }
 
-// ListUsers fetches a list of IAM users up to the specified maximum.
-func (wrapper UserWrapper) ListUsers(maxUsers int32) ([]types.User, error) {
+// maxPageSize is the largest MaxItems IAM list calls accept.
+const maxPageSize = 1000
+
+// ListUsers pages through the IAM users under pathPrefix and returns the
+// ones keep accepts (every user when keep is nil). It stops as soon as limit
+// users have been collected; a limit of 0 walks every page.
+func (wrapper UserWrapper) ListUsers(limit int32, pathPrefix string, keep func(types.User) (bool, error)) ([]types.User, error) {
 	var users []types.User
 
-	input := &iam.ListUsersInput{
-		MaxItems: aws.Int32(maxUsers),
+	input := &iam.ListUsersInput{}
+	if pathPrefix != "" {
+		input.PathPrefix = aws.String(pathPrefix)
 	}
-
 	for {
+		if limit > 0 {
+			input.MaxItems = aws.Int32(min(limit-int32(len(users)), maxPageSize))
+		}
+
 		result, err := wrapper.IamClient.ListUsers(context.TODO(), input)
 		if err != nil {
-			return nil, err
+			return nil, classifyError(err, nil)
 		}
 
-		users = append(users, result.Users...)
-
-		if result.Marker != nil {
-			input.Marker = result.Marker
+		for _, user := range result.Users {
+			if keep != nil {
+				kept, err := keep(user)
+				if err != nil {
+					return nil, err
+				}
+				if !kept {
+					continue
+				}
+			}
+			users = append(users, user)
+			if limit > 0 && int32(len(users)) == limit {
+				return users, nil
+			}
 		}
-		// maxUsers != because 50 is the default value, so it should get all
-		if !result.IsTruncated || maxUsers != 50 {
-			break
+
+		if !result.IsTruncated || result.Marker == nil {
+			return users, nil
 		}
+		input.Marker = result.Marker
 	}
-
-	return users, nil
 }
 
 // GetLoginProfile fetches login profile info for a specific user.
@@ -87,9 +118,43 @@ func (wrapper UserWrapper) GetLoginProfile(user types.User, expired, debug bool,
 	return userLoginProfile, nil
 }
 
-// RotateLoginProfiles rotates the login profile (password) for the provided users.
-func (wrapper UserWrapper) RotateLoginProfiles(users []UserData) []UserData {
+// isLoginProfileExpired reports whether the password is older than stale days.
+func isLoginProfileExpired(user UserLoginData, stale int) bool {
+	if user.LoginProfile == nil || user.LoginProfile.CreateDate == nil {
+		return false
+	}
+	return time.Since(*user.LoginProfile.CreateDate).Hours() > float64(stale*24)
+}
+
+// loginProfileAction decides what a rotation does to a user's console access.
+// In expire-only mode users with a fresh password are left alone and stale
+// ones are forced to reset their password, or lose it entirely when
+// RemoveLoginProfile is set. It returns an empty action for skipped users.
+func loginProfileAction(user UserLoginData, inputs RotateWrapperInputs) string {
+	if !inputs.ExpireOnly {
+		return ActionResetPassword
+	}
+	if !isLoginProfileExpired(user, user.Policy.MaxAge) {
+		return ""
+	}
+	if inputs.RemoveLoginProfile {
+		return ActionRemoveLoginProfile
+	}
+	return ActionRequireReset
+}
+
+var loginProfileActionDetails = map[string]string{
+	ActionResetPassword:      "new temporary password, reset required at next sign-in",
+	ActionRequireReset:       "password reset required at next sign-in, no new password",
+	ActionRemoveLoginProfile: "console access removed",
+}
+
+// RotateLoginProfiles rotates the login profile (password) for the provided
+// users. Users whose rotation fails are reported with the Error field set.
+// With DryRun set no IAM call is made and the planned changes are returned.
+func (wrapper UserWrapper) RotateLoginProfiles(users []UserData, inputs RotateWrapperInputs) []UserData {
 	var results []UserData
+	var policy *PasswordPolicy
 	for _, userData := range users {
 		user, ok := userData.(UserLoginData)
 		if !ok {
@@ -97,35 +162,100 @@ func (wrapper UserWrapper) RotateLoginProfiles(users []UserData) []UserData {
 			continue
 		}
 
-		tempPassword := generateRandomString(12)
+		if user.Error != "" {
+			log.Warnf("Skipping user %s, its login profile could not be read: %s", user.UserName, user.Error)
+			continue
+		}
+
+		if user.UserName == RootAccountName {
+			log.Warn("Skipping the root account, its password cannot be rotated through IAM")
+			continue
+		}
 
-		input := &iam.UpdateLoginProfileInput{
-			UserName:              aws.String(user.UserName),
-			Password:              aws.String(tempPassword),
-			PasswordResetRequired: aws.Bool(true),
+		if user.Policy.Exempt {
+			log.Infof("Skipping user %s: %s", user.UserName, user.Policy.ExemptionStatus())
+			continue
 		}
 
-		_, err := wrapper.IamClient.UpdateLoginProfile(context.TODO(), input)
-		if err != nil {
-			log.Errorf("Failed to rotate password for user %s: %v", user.UserName, err)
+		action := loginProfileAction(user, inputs)
+		if action == "" {
+			log.Debugf("Skipping user %s, login profile is not expired", user.UserName)
 			continue
 		}
 
-		log.Infof("Successfully rotated password for user: %s", user.UserName)
-		log.Infof("New Password: %s", tempPassword)
+		if inputs.DryRun {
+			results = append(results, RotationPlanEntry{
+				UserName: user.UserName,
+				Action:   action,
+				Detail:   loginProfileActionDetails[action],
+			})
+			continue
+		}
 
-		results = append(results, LoginProfileRotationResult{
+		result := LoginProfileRotationResult{
 			UserName: user.UserName,
-			Password: tempPassword,
-		})
+			Action:   action,
+		}
+
+		var err error
+		switch action {
+		case ActionRemoveLoginProfile:
+			_, err = wrapper.IamClient.DeleteLoginProfile(context.TODO(), &iam.DeleteLoginProfileInput{
+				UserName: aws.String(user.UserName),
+			})
+		case ActionRequireReset:
+			_, err = wrapper.IamClient.UpdateLoginProfile(context.TODO(), &iam.UpdateLoginProfileInput{
+				UserName:              aws.String(user.UserName),
+				PasswordResetRequired: aws.Bool(true),
+			})
+		default:
+			if policy == nil {
+				accountPolicy, policyErr := wrapper.GetPasswordPolicy()
+				if policyErr != nil {
+					err = policyErr
+					break
+				}
+				policy = &accountPolicy
+			}
+			result.Password, err = wrapper.resetPassword(user.UserName, *policy, inputs.Password)
+		}
+
+		if err != nil {
+			log.Errorf("Failed to %s for user %s: %v", action, user.UserName, err)
+			results = append(results, LoginProfileRotationResult{
+				UserName: user.UserName,
+				Action:   action,
+				Error:    err.Error(),
+			})
+			continue
+		}
+
+		log.Infof("Successfully applied %s for user: %s", action, user.UserName)
+		results = append(results, result)
 	}
 	return results
 }
 
-func GetLoginProfiles(input GetWrapperInputs) []UserData {
+// GetLoginProfiles lists the login profiles of the selected users. Users
+// whose profile could not be read are returned with Error set. With a single
+// --username, a missing user or login profile is returned as ErrUserNotFound
+// or ErrNoLoginProfile.
+func GetLoginProfiles(input GetWrapperInputs) ([]UserData, error) {
 	var usersData []types.User
 	var err error
 
+	if len(input.Accounts) > 0 {
+		return input.forEachAccount(GetLoginProfiles)
+	}
+
+	if input.CredentialReport || input.ReportFile != "" {
+		rows, err := input.credentialReportRows()
+		if err != nil {
+			return nil, err
+		}
+		return ReportLoginProfiles(rows, input)
+	}
+
 	if input.UserName != "" {
 		inputGetUser := &iam.GetUserInput{
 			UserName: &input.UserName,
@@ -133,84 +263,113 @@ func GetLoginProfiles(input GetWrapperInputs) []UserData {
 
 		selectedUser, err := input.Client.IamClient.GetUser(context.TODO(), inputGetUser)
 		if err != nil {
-			log.Fatalf("Failed to get users: %v", err)
+			return nil, fmt.Errorf("user %s: %w", input.UserName, classifyError(err, ErrUserNotFound))
+		}
+		if kept, err := input.keepNamedUser(*selectedUser.User); err != nil || !kept {
+			return nil, err
 		}
 
-		usersData = []types.User{{
+		user := types.User{
 			UserName:         aws.String(input.UserName),
 			PasswordLastUsed: selectedUser.User.PasswordLastUsed,
-		}}
-
-	} else {
-		usersData, err = input.Client.ListUsers(input.MaxUsers)
+		}
+		policy, err := input.userPolicy(input.UserName)
 		if err != nil {
-			log.Fatalf("Failed to get users: %v", err)
+			return nil, fmt.Errorf("user %s: %w", input.UserName, classifyError(err, ErrUserNotFound))
+		}
+		userLogin, err := input.Client.GetLoginProfile(user, input.Expired, false, policy.MaxAge)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %w", input.UserName, classifyError(err, ErrNoLoginProfile))
+		}
+		if userLogin.UserName == "" {
+			return nil, nil
 		}
+		userLogin.Policy = policy
+		return []UserData{userLogin}, nil
+	}
+
+	selector, err := newUserSelector(input)
+	if err != nil {
+		return nil, err
+	}
+	usersData, err = input.Client.ListUsers(input.MaxUsers, input.Filter.PathPrefix, selector.keep)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
 	}
 
 	var (
 		userLoginProfiles []UserData
 		mu                sync.Mutex
-		wg                sync.WaitGroup
-		// errors            []error
 	)
 
-	wg.Add(len(usersData))
-
-	for _, user := range usersData {
-		go func(user types.User) {
-			defer wg.Done()
-			userLogin, err := input.Client.GetLoginProfile(user, input.Expired, false, input.Age)
-			if err != nil {
-				// mu.Lock()
+	ForEachUser(usersData, input.Concurrency, func(user types.User) {
+		policy, err := input.userPolicy(*user.UserName)
+		if err != nil {
+			mu.Lock()
+			userLoginProfiles = append(userLoginProfiles, UserLoginData{
+				UserName: *user.UserName,
+				Error:    classifyError(err, ErrUserNotFound).Error(),
+			})
+			mu.Unlock()
+			return
+		}
 
-				// We do this on purpose to avoid logs for profiles without login: 404 error
-				// errors = append(errors, err)
-				// mu.Unlock()
+		userLogin, err := input.Client.GetLoginProfile(user, input.Expired, false, policy.MaxAge)
+		if err != nil {
+			// Users without a console password are expected and left out;
+			// any other failure is reported in the output.
+			var notFound *types.NoSuchEntityException
+			if errors.As(err, &notFound) {
 				return
 			}
+			userLogin = UserLoginData{
+				UserName: *user.UserName,
+				Error:    classifyError(err, ErrUserNotFound).Error(),
+			}
+		}
+		if userLogin.UserName == "" {
+			return
+		}
+		userLogin.Policy = policy
 
-			mu.Lock()
-			userLoginProfiles = append(userLoginProfiles, userLogin)
-
-			mu.Unlock()
-		}(user)
-	}
-
-	wg.Wait()
+		mu.Lock()
+		userLoginProfiles = append(userLoginProfiles, userLogin)
+		mu.Unlock()
+	})
 
 	sort.Slice(userLoginProfiles, func(i, j int) bool {
 		return userLoginProfiles[j].(UserLoginData).UserName > userLoginProfiles[i].(UserLoginData).UserName
 	})
 
-	// if len(errors) > 0 {
-	// 	// CHange error message
-	// 	return userLoginProfiles, errors[0] // Returning the first error as an example
-	// }
-
-	return userLoginProfiles
+	return userLoginProfiles, nil
 }
 
-const (
-	letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	numberBytes = "0123456789"
-	allBytes    = letterBytes + numberBytes
-)
+// resetPassword sets a new temporary password that must be changed at the
+// next sign-in. A password IAM rejects, e.g. under the reuse rules, is
+// replaced by a fresh one a few times before giving up.
+func (wrapper UserWrapper) resetPassword(userName string, policy PasswordPolicy, options PasswordOptions) (string, error) {
+	var err error
+	for attempt := 1; attempt <= maxPasswordAttempts; attempt++ {
+		var tempPassword string
+		tempPassword, err = GeneratePassword(policy, options)
+		if err != nil {
+			return "", err
+		}
 
-func generateRandomString(n int) string {
-	b := make([]byte, n)
-	// Ensure at least one number
-	b[0] = numberBytes[rand.Int63()%int64(len(numberBytes))]
+		_, err = wrapper.IamClient.UpdateLoginProfile(context.TODO(), &iam.UpdateLoginProfileInput{
+			UserName:              aws.String(userName),
+			Password:              aws.String(tempPassword),
+			PasswordResetRequired: aws.Bool(true),
+		})
+		if err == nil {
+			return tempPassword, nil
+		}
 
-	// Fill the rest with mixed characters
-	for i := 1; i < n; i++ {
-		b[i] = allBytes[rand.Int63()%int64(len(allBytes))]
+		var violation *types.PasswordPolicyViolationException
+		if !errors.As(err, &violation) {
+			return "", err
+		}
+		log.Warnf("Generated password for user %s was rejected by the password policy (attempt %d): %v", userName, attempt, err)
 	}
-
-	// Shuffle the bytes to randomize the position of the number
-	rand.Shuffle(len(b), func(i, j int) {
-		b[i], b[j] = b[j], b[i]
-	})
-
-	return string(b)
+	return "", err
 }
@@ -0,0 +1,118 @@
+package iam
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/javiercm1410/gyro/pkg/providers/aws/fakeiam"
+)
+
+func TestListUsers(t *testing.T) {
+	// Seven users served two per page; the odd ones are under /svc/.
+	var users []fakeiam.FixtureUser
+	for i, name := range []string{"u1", "u2", "u3", "u4", "u5", "u6", "u7"} {
+		path := "/"
+		if i%2 == 0 {
+			path = "/svc/"
+		}
+		users = append(users, fakeiam.FixtureUser{UserName: name, Path: path})
+	}
+	notEven := func(user types.User) (bool, error) {
+		name := aws.ToString(user.UserName)
+		return name[1]%2 == 1, nil
+	}
+
+	tests := []struct {
+		name       string
+		limit      int32
+		pathPrefix string
+		keep       func(types.User) (bool, error)
+		want       []string
+		wantCalls  int
+		wantErr    bool
+	}{
+		{name: "limit 0 lists every user", want: []string{"u1", "u2", "u3", "u4", "u5", "u6", "u7"}, wantCalls: 4},
+		{name: "limit across a page boundary", limit: 3, want: []string{"u1", "u2", "u3"}, wantCalls: 2},
+		{name: "limit on a page boundary", limit: 4, want: []string{"u1", "u2", "u3", "u4"}, wantCalls: 2},
+		{name: "limit above the user count", limit: 10, want: []string{"u1", "u2", "u3", "u4", "u5", "u6", "u7"}, wantCalls: 4},
+		{name: "filtered out users do not count", limit: 3, keep: notEven, want: []string{"u1", "u3", "u5"}, wantCalls: 3},
+		{name: "filter without a limit", keep: notEven, want: []string{"u1", "u3", "u5", "u7"}, wantCalls: 4},
+		{name: "path prefix", limit: 3, pathPrefix: "/svc/", want: []string{"u1", "u3", "u5"}, wantCalls: 2},
+		{
+			name:    "filter error",
+			keep:    func(types.User) (bool, error) { return false, errors.New("GetUser: denied") },
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wrapper, client := fakeWrapper(t, fakeiam.Fixture{Users: users, PageSize: 2})
+			listed, err := wrapper.ListUsers(test.limit, test.pathPrefix, test.keep)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ListUsers() error = %v, want error %v", err, test.wantErr)
+			}
+
+			var got []string
+			for _, user := range listed {
+				got = append(got, aws.ToString(user.UserName))
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("users = %q, want %q", got, test.want)
+			}
+			if !test.wantErr && client.Calls("ListUsers") != test.wantCalls {
+				t.Errorf("ListUsers calls = %d, want %d", client.Calls("ListUsers"), test.wantCalls)
+			}
+		})
+	}
+}
+
+func TestLoginProfileAction(t *testing.T) {
+	stale := time.Now().AddDate(0, 0, -100)
+	fresh := time.Now().AddDate(0, 0, -10)
+
+	tests := []struct {
+		name    string
+		created time.Time
+		maxAge  int
+		inputs  RotateWrapperInputs
+		want    string
+	}{
+		{name: "rotation resets any password", created: fresh, want: ActionResetPassword},
+		{name: "expire only skips a fresh password", created: fresh, inputs: RotateWrapperInputs{ExpireOnly: true}},
+		{name: "expire only requires a reset of a stale password", created: stale, inputs: RotateWrapperInputs{ExpireOnly: true}, want: ActionRequireReset},
+		{
+			name:    "expire only removes a stale login profile",
+			created: stale,
+			inputs:  RotateWrapperInputs{ExpireOnly: true, RemoveLoginProfile: true},
+			want:    ActionRemoveLoginProfile,
+		},
+		{
+			name:    "expire only uses the max age of the tag policy",
+			created: fresh,
+			maxAge:  5,
+			inputs:  RotateWrapperInputs{ExpireOnly: true},
+			want:    ActionRequireReset,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.maxAge == 0 {
+				test.maxAge = 90
+			}
+			user := UserLoginData{
+				UserName:     "carol",
+				LoginProfile: &types.LoginProfile{CreateDate: &test.created},
+				Policy:       UserPolicy{MaxAge: test.maxAge},
+			}
+			if got := loginProfileAction(user, test.inputs); got != test.want {
+				t.Errorf("action = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/1password/onepassword-sdk-go"
+	iam "github.com/javiercm1410/gyro/pkg/providers/aws"
+)
+
+// OnePasswordTokenEnv is the environment variable holding the 1Password
+// service account token.
+const OnePasswordTokenEnv = "OP_SERVICE_ACCOUNT_TOKEN"
+
+// OnePasswordSink keeps one 1Password item per IAM user and credential type,
+// creating it on the first rotation and updating it afterwards.
+type OnePasswordSink struct {
+	Client  *onepassword.Client
+	VaultId string
+}
+
+// NewOnePasswordSink authenticates with a service account token and resolves
+// vault, which may be a vault id or title.
+func NewOnePasswordSink(token, vault, version string) (OnePasswordSink, error) {
+	if token == "" {
+		return OnePasswordSink{}, fmt.Errorf("1Password service account token is empty, set %s", OnePasswordTokenEnv)
+	}
+	if vault == "" {
+		return OnePasswordSink{}, errors.New("1Password vault is empty, use --op-vault")
+	}
+
+	client, err := onepassword.NewClient(
+		context.TODO(),
+		onepassword.WithServiceAccountToken(token),
+		onepassword.WithIntegrationInfo("gyro", version),
+	)
+	if err != nil {
+		return OnePasswordSink{}, fmt.Errorf("error creating 1Password client: %w", err)
+	}
+
+	vaults, err := client.Vaults.ListAll(context.TODO())
+	if err != nil {
+		return OnePasswordSink{}, fmt.Errorf("error listing 1Password vaults: %w", err)
+	}
+	for {
+		overview, err := vaults.Next()
+		if errors.Is(err, onepassword.ErrorIteratorDone) {
+			break
+		}
+		if err != nil {
+			return OnePasswordSink{}, fmt.Errorf("error listing 1Password vaults: %w", err)
+		}
+		if overview.ID == vault || overview.Title == vault {
+			return OnePasswordSink{Client: client, VaultId: overview.ID}, nil
+		}
+	}
+
+	return OnePasswordSink{}, fmt.Errorf("1Password vault %s not found", vault)
+}
+
+// StoreAccessKey upserts an API credential item holding the new key pair.
+func (sink OnePasswordSink) StoreAccessKey(result iam.AccessKeyRotationResult, rotatedAt time.Time) error {
+	return sink.upsert(
+		itemTitle("access key", result.UserName, result.Account),
+		onepassword.ItemCategoryAPICredentials,
+		append([]onepassword.ItemField{
+			{ID: "username", Title: "username", FieldType: onepassword.ItemFieldTypeText, Value: result.UserName},
+			{ID: "access_key_id", Title: "access key id", FieldType: onepassword.ItemFieldTypeText, Value: result.AccessKeyId},
+			{ID: "credential", Title: "secret access key", FieldType: onepassword.ItemFieldTypeConcealed, Value: result.SecretAccessKey},
+			{ID: "rotated_at", Title: "rotated at", FieldType: onepassword.ItemFieldTypeText, Value: rotatedAt.Format(time.RFC3339)},
+		}, accountFields(result.Account)...),
+	)
+}
+
+// StoreLoginProfile upserts a login item holding the temporary console password.
+func (sink OnePasswordSink) StoreLoginProfile(result iam.LoginProfileRotationResult, rotatedAt time.Time) error {
+	return sink.upsert(
+		itemTitle("console", result.UserName, result.Account),
+		onepassword.ItemCategoryLogin,
+		append([]onepassword.ItemField{
+			{ID: "username", Title: "username", FieldType: onepassword.ItemFieldTypeText, Value: result.UserName},
+			{ID: "password", Title: "password", FieldType: onepassword.ItemFieldTypeConcealed, Value: result.Password},
+			{ID: "rotated_at", Title: "rotated at", FieldType: onepassword.ItemFieldTypeText, Value: rotatedAt.Format(time.RFC3339)},
+		}, accountFields(result.Account)...),
+	)
+}
+
+// itemTitle names the item of a user's credential. Rows of an --org run are
+// qualified by their account, so users with the same name in different
+// accounts keep separate items.
+func itemTitle(kind, userName string, account iam.Account) string {
+	if account.AccountId == "" {
+		return fmt.Sprintf("AWS %s - %s", kind, userName)
+	}
+	return fmt.Sprintf("AWS %s - %s (%s)", kind, userName, account.AccountId)
+}
+
+// accountFields records the account of an --org row on its item.
+func accountFields(account iam.Account) []onepassword.ItemField {
+	if account.AccountId == "" {
+		return nil
+	}
+	fields := []onepassword.ItemField{
+		{ID: "account_id", Title: "account id", FieldType: onepassword.ItemFieldTypeText, Value: account.AccountId},
+	}
+	if account.AccountAlias != "" {
+		fields = append(fields, onepassword.ItemField{ID: "account_alias", Title: "account alias", FieldType: onepassword.ItemFieldTypeText, Value: account.AccountAlias})
+	}
+	return fields
+}
+
+func (sink OnePasswordSink) upsert(title string, category onepassword.ItemCategory, fields []onepassword.ItemField) error {
+	itemId, err := sink.findItem(title)
+	if err != nil {
+		return err
+	}
+
+	if itemId == "" {
+		_, err := sink.Client.Items.Create(context.TODO(), onepassword.ItemCreateParams{
+			Category: category,
+			VaultID:  sink.VaultId,
+			Title:    title,
+			Fields:   fields,
+			Tags:     []string{"gyro"},
+		})
+		if err != nil {
+			return fmt.Errorf("error creating 1Password item %s: %w", title, err)
+		}
+		return nil
+	}
+
+	item, err := sink.Client.Items.Get(context.TODO(), sink.VaultId, itemId)
+	if err != nil {
+		return fmt.Errorf("error reading 1Password item %s: %w", title, err)
+	}
+	item.Fields = mergeFields(item.Fields, fields)
+
+	if _, err := sink.Client.Items.Put(context.TODO(), item); err != nil {
+		return fmt.Errorf("error updating 1Password item %s: %w", title, err)
+	}
+	return nil
+}
+
+func (sink OnePasswordSink) findItem(title string) (string, error) {
+	items, err := sink.Client.Items.ListAll(context.TODO(), sink.VaultId)
+	if err != nil {
+		return "", fmt.Errorf("error listing 1Password items: %w", err)
+	}
+
+	for {
+		overview, err := items.Next()
+		if errors.Is(err, onepassword.ErrorIteratorDone) {
+			return "", nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("error listing 1Password items: %w", err)
+		}
+		if overview.Title == title {
+			return overview.ID, nil
+		}
+	}
+}
+
+// mergeFields overwrites fields with the same id and keeps any other field a
+// user may have added to the item.
+func mergeFields(existing, updates []onepassword.ItemField) []onepassword.ItemField {
+	merged := make([]onepassword.ItemField, 0, len(existing)+len(updates))
+	replaced := map[string]bool{}
+
+	for _, field := range existing {
+		for _, update := range updates {
+			if field.ID == update.ID {
+				field = update
+				replaced[update.ID] = true
+				break
+			}
+		}
+		merged = append(merged, field)
+	}
+
+	for _, update := range updates {
+		if !replaced[update.ID] {
+			merged = append(merged, update)
+		}
+	}
+
+	return merged
+}
@@ -0,0 +1,68 @@
+package secrets
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/1password/onepassword-sdk-go"
+	iam "github.com/javiercm1410/gyro/pkg/providers/aws"
+)
+
+func TestMergeFields(t *testing.T) {
+	text := onepassword.ItemFieldTypeText
+	tests := []struct {
+		name     string
+		existing []onepassword.ItemField
+		updates  []onepassword.ItemField
+		want     []onepassword.ItemField
+	}{
+		{
+			name:    "new item",
+			updates: []onepassword.ItemField{{ID: "username", FieldType: text, Value: "alice"}},
+			want:    []onepassword.ItemField{{ID: "username", FieldType: text, Value: "alice"}},
+		},
+		{
+			name: "same ids are overwritten in place and user fields kept",
+			existing: []onepassword.ItemField{
+				{ID: "notes", FieldType: text, Value: "owned by payments"},
+				{ID: "access_key_id", FieldType: text, Value: "AKIAOLD"},
+				{ID: "credential", FieldType: onepassword.ItemFieldTypeConcealed, Value: "old"},
+			},
+			updates: []onepassword.ItemField{
+				{ID: "access_key_id", FieldType: text, Value: "AKIANEW"},
+				{ID: "credential", FieldType: onepassword.ItemFieldTypeConcealed, Value: "new"},
+				{ID: "rotated_at", FieldType: text, Value: "2026-01-01T00:00:00Z"},
+			},
+			want: []onepassword.ItemField{
+				{ID: "notes", FieldType: text, Value: "owned by payments"},
+				{ID: "access_key_id", FieldType: text, Value: "AKIANEW"},
+				{ID: "credential", FieldType: onepassword.ItemFieldTypeConcealed, Value: "new"},
+				{ID: "rotated_at", FieldType: text, Value: "2026-01-01T00:00:00Z"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := mergeFields(test.existing, test.updates); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("mergeFields() = %+v\nwant %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestItemTitle(t *testing.T) {
+	tests := []struct {
+		account iam.Account
+		want    string
+	}{
+		{want: "AWS access key - alice"},
+		{account: iam.Account{AccountId: "123456789012", AccountAlias: "prod"}, want: "AWS access key - alice (123456789012)"},
+	}
+
+	for _, test := range tests {
+		if got := itemTitle("access key", "alice", test.account); got != test.want {
+			t.Errorf("itemTitle() = %q, want %q", got, test.want)
+		}
+	}
+}
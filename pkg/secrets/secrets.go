@@ -0,0 +1,81 @@
+package secrets
+
+import (
+	"fmt"
+	"time"
+
+	iam "github.com/javiercm1410/gyro/pkg/providers/aws"
+)
+
+// Sink names accepted by --secret-sink.
+const (
+	SinkNone        = "none"
+	SinkOnePassword = "1password"
+)
+
+// StoredMarker replaces secrets in the output once they have been stored.
+const StoredMarker = "<stored in 1Password>"
+
+// Sink stores the credentials produced by a rotation.
+type Sink interface {
+	StoreAccessKey(result iam.AccessKeyRotationResult, rotatedAt time.Time) error
+	StoreLoginProfile(result iam.LoginProfileRotationResult, rotatedAt time.Time) error
+}
+
+// Store hands every successful rotation result to the sink and returns the
+// results with the secret replaced by StoredMarker. Results that could not be
+// stored keep their secret and get the failure appended to Error, so the
+// credential is not lost.
+func Store(sink Sink, results []iam.UserData) []iam.UserData {
+	rotatedAt := time.Now().UTC()
+	stored := make([]iam.UserData, 0, len(results))
+
+	for _, item := range results {
+		switch result := item.(type) {
+		case iam.AccessKeyRotationResult:
+			if result.SecretAccessKey != "" {
+				if err := sink.StoreAccessKey(result, rotatedAt); err != nil {
+					result.Error = appendError(result.Error, fmt.Sprintf("store secret: %v", err))
+				} else {
+					result.SecretAccessKey = StoredMarker
+				}
+			}
+			stored = append(stored, result)
+		case iam.StagedRotationResult:
+			if result.SecretAccessKey != "" {
+				keyResult := iam.AccessKeyRotationResult{
+					Account:         result.Account,
+					UserName:        result.UserName,
+					AccessKeyId:     result.NewKeyId,
+					SecretAccessKey: result.SecretAccessKey,
+				}
+				if err := sink.StoreAccessKey(keyResult, rotatedAt); err != nil {
+					result.Error = appendError(result.Error, fmt.Sprintf("store secret: %v", err))
+				} else {
+					result.SecretAccessKey = StoredMarker
+				}
+			}
+			stored = append(stored, result)
+		case iam.LoginProfileRotationResult:
+			if result.Password != "" {
+				if err := sink.StoreLoginProfile(result, rotatedAt); err != nil {
+					result.Error = appendError(result.Error, fmt.Sprintf("store secret: %v", err))
+				} else {
+					result.Password = StoredMarker
+				}
+			}
+			stored = append(stored, result)
+		default:
+			stored = append(stored, item)
+		}
+	}
+
+	return stored
+}
+
+func appendError(existing, message string) string {
+	if existing == "" {
+		return message
+	}
+	return existing + "; " + message
+}
@@ -0,0 +1,97 @@
+package secrets
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	iam "github.com/javiercm1410/gyro/pkg/providers/aws"
+)
+
+// fakeSink records what it stores and fails for the users in fail.
+type fakeSink struct {
+	fail   map[string]bool
+	stored []string
+}
+
+func (sink *fakeSink) store(kind, userName, secret string) error {
+	if sink.fail[userName] {
+		return errors.New("vault is read-only")
+	}
+	sink.stored = append(sink.stored, kind+" "+userName+" "+secret)
+	return nil
+}
+
+func (sink *fakeSink) StoreAccessKey(result iam.AccessKeyRotationResult, rotatedAt time.Time) error {
+	return sink.store("key", result.UserName, result.AccessKeyId+":"+result.SecretAccessKey)
+}
+
+func (sink *fakeSink) StoreLoginProfile(result iam.LoginProfileRotationResult, rotatedAt time.Time) error {
+	return sink.store("password", result.UserName, result.Password)
+}
+
+func TestStore(t *testing.T) {
+	tests := []struct {
+		name       string
+		fail       map[string]bool
+		results    []iam.UserData
+		want       []iam.UserData
+		wantStored []string
+	}{
+		{
+			name: "stored secrets are replaced by the marker",
+			results: []iam.UserData{
+				iam.AccessKeyRotationResult{UserName: "alice", AccessKeyId: "AKIANEW", SecretAccessKey: "secret"},
+				iam.StagedRotationResult{UserName: "bob", NewKeyId: "AKIABOB", SecretAccessKey: "staged"},
+				iam.LoginProfileRotationResult{UserName: "carol", Password: "hunter2"},
+			},
+			want: []iam.UserData{
+				iam.AccessKeyRotationResult{UserName: "alice", AccessKeyId: "AKIANEW", SecretAccessKey: StoredMarker},
+				iam.StagedRotationResult{UserName: "bob", NewKeyId: "AKIABOB", SecretAccessKey: StoredMarker},
+				iam.LoginProfileRotationResult{UserName: "carol", Password: StoredMarker},
+			},
+			wantStored: []string{"key alice AKIANEW:secret", "key bob AKIABOB:staged", "password carol hunter2"},
+		},
+		{
+			name: "failed store keeps the secret and appends the error",
+			fail: map[string]bool{"alice": true, "carol": true},
+			results: []iam.UserData{
+				iam.AccessKeyRotationResult{UserName: "alice", AccessKeyId: "AKIANEW", SecretAccessKey: "secret", Error: "delete old key: denied"},
+				iam.LoginProfileRotationResult{UserName: "carol", Password: "hunter2"},
+			},
+			want: []iam.UserData{
+				iam.AccessKeyRotationResult{UserName: "alice", AccessKeyId: "AKIANEW", SecretAccessKey: "secret", Error: "delete old key: denied; store secret: vault is read-only"},
+				iam.LoginProfileRotationResult{UserName: "carol", Password: "hunter2", Error: "store secret: vault is read-only"},
+			},
+		},
+		{
+			name: "results without a secret are left alone",
+			results: []iam.UserData{
+				iam.AccessKeyRotationResult{UserName: "alice", Error: "create key: denied"},
+				iam.StagedRotationResult{UserName: "bob", Stage: iam.StageDeactivated},
+				iam.LoginProfileRotationResult{UserName: "carol", Action: "skipped"},
+				iam.UserAccessKeyData{UserName: "dave"},
+			},
+			want: []iam.UserData{
+				iam.AccessKeyRotationResult{UserName: "alice", Error: "create key: denied"},
+				iam.StagedRotationResult{UserName: "bob", Stage: iam.StageDeactivated},
+				iam.LoginProfileRotationResult{UserName: "carol", Action: "skipped"},
+				iam.UserAccessKeyData{UserName: "dave"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sink := &fakeSink{fail: test.fail}
+			got := Store(sink, test.results)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Store() = %+v\nwant %+v", got, test.want)
+			}
+			if !reflect.DeepEqual(sink.stored, test.wantStored) {
+				t.Errorf("stored = %q, want %q", sink.stored, test.wantStored)
+			}
+		})
+	}
+}
@@ -1,68 +1,157 @@
 package utils
 
 import (
+	"bytes"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/javiercm1410/gyro/pkg/audit"
+	"github.com/javiercm1410/gyro/pkg/exemptions"
 	iam "github.com/javiercm1410/gyro/pkg/providers/aws"
 
 	"github.com/charmbracelet/lipgloss"
 	"github.com/charmbracelet/lipgloss/table"
 	"github.com/charmbracelet/log"
+	"gopkg.in/yaml.v3"
 )
 
 const dateFormat = "2006-01-02 15:04:05"
 
-// DisplayData processes and displays data in the specified format.
+// OutputFormats are the formats DisplayData renders. "file" is JSON written
+// to a file, kept for compatibility with --output-file.
+var OutputFormats = []string{"table", "json", "yaml", "csv", "file"}
+
+// defaultOutputFile is where the "file" format writes without a path.
+const defaultOutputFile = "./output.json"
+
+// DisplayData processes and displays data in the specified format. When path
+// is set the output is written to that file instead of stdout.
 func DisplayData(outputFormat, path string, stale int, value []iam.UserData) {
 	if len(value) == 0 {
 		log.Warn("No data available to display")
 		return
 	}
 
-	switch outputFormat {
-	case "json":
-		if err := jsonOutput(value); err != nil {
-			log.Error("Failed to generate JSON output", "error", err)
+	if outputFormat == "file" {
+		outputFormat = "json"
+		if path == "" {
+			path = defaultOutputFile
 		}
-	case "file":
-		if err := fileOutput(value, path); err != nil {
-			log.Error("Failed to write data to file", "error", err)
+	}
+
+	if path == "" {
+		if err := renderData(os.Stdout, outputFormat, stale, value); err != nil {
+			log.Errorf("Failed to generate %s output: %v", outputFormat, err)
 		}
-	case "table":
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := renderData(&buf, outputFormat, stale, value); err != nil {
+		log.Errorf("Failed to generate %s output: %v", outputFormat, err)
+		return
+	}
+	if err := writeOutputFile(path, buf.Bytes()); err != nil {
+		log.Error("Failed to write data to file", "error", fmt.Errorf("error writing to file %s: %w", path, err))
+		return
+	}
+	log.Infof("Output saved to %s", path)
+}
+
+// writeOutputFile writes data readable by its owner only, as rotation results
+// carry new secrets. An existing file is restricted before it is written.
+func writeOutputFile(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := file.Chmod(0o600); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func renderData(w io.Writer, outputFormat string, stale int, value []iam.UserData) error {
+	switch outputFormat {
+	case "json":
+		return jsonOutput(w, value)
+	case "yaml":
+		return yamlOutput(w, value)
+	case "csv", "table":
 		headers, data, err := processTableData(value)
 		if err != nil {
-			log.Error("Failed to process table data", "error", err)
-			return
+			return fmt.Errorf("error processing table data: %w", err)
+		}
+		if outputFormat == "csv" {
+			return csvOutput(w, headers, data)
 		}
-		tableOutput(headers, data, stale)
+		tableOutput(w, headers, data, stale)
+		return nil
 	default:
-		log.Error("Generate output error", "Error", outputFormat)
+		return fmt.Errorf("unknown output format %s", outputFormat)
 	}
 }
 
-func jsonOutput(value any) error {
+func jsonOutput(w io.Writer, value any) error {
 	marshaled, err := json.MarshalIndent(value, "", "   ")
 	if err != nil {
 		return fmt.Errorf("error marshaling JSON: %w", err)
 	}
-	fmt.Println(string(marshaled))
+	fmt.Fprintln(w, string(marshaled))
 	return nil
 }
 
-func fileOutput(value any, path string) error {
-	marshaled, err := json.MarshalIndent(value, "", "   ")
+// yamlOutput renders value as YAML with the field names and order of the JSON
+// output, by decoding the JSON document into a YAML node tree.
+func yamlOutput(w io.Writer, value any) error {
+	marshaled, err := json.Marshal(value)
 	if err != nil {
 		return fmt.Errorf("error marshaling JSON: %w", err)
 	}
 
-	if err := os.WriteFile(path, marshaled, 0644); err != nil {
-		return fmt.Errorf("error writing to file %s: %w", path, err)
+	var node yaml.Node
+	if err := yaml.Unmarshal(marshaled, &node); err != nil {
+		return fmt.Errorf("error converting to YAML: %w", err)
 	}
+	blockStyle(&node)
 
-	log.Infof("Output saved to %s", path)
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&node); err != nil {
+		return fmt.Errorf("error marshaling YAML: %w", err)
+	}
+	return encoder.Close()
+}
+
+// blockStyle drops the flow style and quoting the nodes kept from JSON, so
+// the encoder picks the usual YAML layout.
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// csvOutput writes the table columns as CSV, one line per table row.
+func csvOutput(w io.Writer, headers []string, data [][]string) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(headers); err != nil {
+		return fmt.Errorf("error writing CSV: %w", err)
+	}
+	if err := writer.WriteAll(data); err != nil {
+		return fmt.Errorf("error writing CSV: %w", err)
+	}
 	return nil
 }
 
@@ -70,13 +159,29 @@ func processTableData(value []iam.UserData) ([]string, [][]string, error) {
 	var headers []string
 	data := make([][]string, 0, len(value))
 
+	// Rows read from several accounts start with the account they come from.
+	multiAccount := slices.ContainsFunc(value, func(item iam.UserData) bool {
+		return iam.AccountOf(item).AccountId != ""
+	})
+	withAccount := func(item iam.UserData, row []string) []string {
+		if !multiAccount {
+			return row
+		}
+		account := iam.AccountOf(item)
+		return append([]string{account.AccountId, account.AccountAlias}, row...)
+	}
+
 	if len(value) > 0 {
 		switch value[0].(type) {
 		case iam.UserAccessKeyData:
-			headers = []string{"UserName", "KeyId", "CreateDate", "KeyStatus", "LastUsedTime", "LastUsedService"}
+			headers = []string{"UserName", "KeyId", "CreateDate", "KeyStatus", "LastUsedTime", "LastUsedService", "LastRotated"}
 			for _, item := range value {
 				// Type assert each item to UserAccessKeyData
 				if user, ok := item.(iam.UserAccessKeyData); ok {
+					if user.Error != "" {
+						data = append(data, withAccount(user, []string{user.UserName, "n/a", "n/a", "n/a", "n/a", "n/a", "n/a", resultStatus(user.Error)}))
+						continue
+					}
 					for _, key := range user.Keys {
 						if !key.MatchesCriteria {
 							continue
@@ -87,6 +192,10 @@ func processTableData(value []iam.UserData) ([]string, [][]string, error) {
 						if !key.LastUsedTime.IsZero() {
 							lastUsedTime = key.LastUsedTime.Format(dateFormat)
 						}
+						lastRotated := "never"
+						if user.LastRotated != nil {
+							lastRotated = user.LastRotated.Format(dateFormat)
+						}
 
 						row := []string{
 							user.UserName,
@@ -95,36 +204,128 @@ func processTableData(value []iam.UserData) ([]string, [][]string, error) {
 							string(key.KeyStatus),
 							lastUsedTime,
 							key.LastUsedService,
+							lastRotated,
+							keyStatus(user.Policy, key),
 						}
-						data = append(data, row)
+						data = append(data, withAccount(user, row))
 					}
 				}
 			}
+			headers, data = statusColumn(headers, data)
 		case iam.AccessKeyRotationResult:
-			headers = []string{"UserName", "AccessKeyId", "SecretAccessKey"}
+			headers = []string{"UserName", "AccessKeyId", "SecretAccessKey", "Deactivated", "Deleted", "Status"}
 			for _, item := range value {
 				if result, ok := item.(iam.AccessKeyRotationResult); ok {
 					row := []string{
 						result.UserName,
 						result.AccessKeyId,
 						result.SecretAccessKey,
+						strings.Join(result.DeactivatedKeys, ", "),
+						result.DeletedKeyId,
+						resultStatus(result.Error),
 					}
-					data = append(data, row)
+					data = append(data, withAccount(result, row))
 				}
 			}
 		case iam.LoginProfileRotationResult:
-			headers = []string{"UserName", "Password"}
+			headers = []string{"UserName", "Action", "Password", "Status"}
 			for _, item := range value {
 				if result, ok := item.(iam.LoginProfileRotationResult); ok {
 					row := []string{
 						result.UserName,
+						result.Action,
 						result.Password,
+						resultStatus(result.Error),
 					}
-					data = append(data, row)
+					data = append(data, withAccount(result, row))
+				}
+			}
+		case iam.StagedRotationResult:
+			headers = []string{"UserName", "Stage", "OldKeyIds", "NewKeyId", "SecretAccessKey", "Detail", "Status"}
+			for _, item := range value {
+				if result, ok := item.(iam.StagedRotationResult); ok {
+					row := []string{
+						result.UserName,
+						result.Stage,
+						strings.Join(result.OldKeyIds, ", "),
+						result.NewKeyId,
+						result.SecretAccessKey,
+						result.Detail,
+						resultStatus(result.Error),
+					}
+					data = append(data, withAccount(result, row))
+				}
+			}
+		case iam.RollbackResult:
+			headers = []string{"UserName", "Action", "AccessKeyId", "Detail", "Status"}
+			for _, item := range value {
+				if result, ok := item.(iam.RollbackResult); ok {
+					row := []string{
+						result.UserName,
+						result.Action,
+						result.AccessKeyId,
+						result.Detail,
+						resultStatus(result.Error),
+					}
+					data = append(data, withAccount(result, row))
+				}
+			}
+		case iam.RotationPlanEntry:
+			headers = []string{"UserName", "Action", "AccessKeyId", "Detail"}
+			for _, item := range value {
+				if entry, ok := item.(iam.RotationPlanEntry); ok {
+					row := []string{
+						entry.UserName,
+						entry.Action,
+						entry.AccessKeyId,
+						entry.Detail,
+					}
+					data = append(data, withAccount(entry, row))
+				}
+			}
+		case exemptions.Status:
+			headers = []string{"UserName", "AccessKeyId", "Reason", "Approver", "Expires", "Status"}
+			for _, item := range value {
+				if status, ok := item.(exemptions.Status); ok {
+					keyId := status.AccessKeyId
+					if keyId == "" {
+						keyId = "all"
+					}
+					state := fmt.Sprintf("expires in %d days", status.DaysLeft)
+					if status.Expired {
+						state = "expired, back in scope"
+					}
+					data = append(data, []string{
+						status.User,
+						keyId,
+						status.Reason,
+						status.Approver,
+						status.Expires.Format(time.DateOnly),
+						state,
+					})
+				}
+			}
+		case audit.Record:
+			headers = []string{"Time", "UserName", "Action", "AccessKeyId", "Detail", "Outcome", "Caller"}
+			for _, item := range value {
+				if record, ok := item.(audit.Record); ok {
+					outcome := record.Outcome
+					if record.Error != "" {
+						outcome += ": " + record.Error
+					}
+					data = append(data, []string{
+						record.Time.Format(dateFormat),
+						record.UserName,
+						record.Action,
+						record.AccessKeyId,
+						record.Detail,
+						outcome,
+						record.Caller,
+					})
 				}
 			}
 		case iam.UserLoginData:
-			headers = []string{"UserName", "LastUsed", "CreateDate"}
+			headers = []string{"UserName", "LastUsed", "CreateDate", "MFA"}
 			for _, sublist := range value {
 				// If sublist is []types.User, iterate over it
 				if user, ok := sublist.(iam.UserLoginData); ok {
@@ -135,22 +336,37 @@ func processTableData(value []iam.UserData) ([]string, [][]string, error) {
 					if user.UserName == "" {
 						continue
 					}
+					if user.Error != "" {
+						data = append(data, withAccount(user, []string{user.UserName, "n/a", "n/a", "n/a", resultStatus(user.Error)}))
+						continue
+					}
 					if user.LoginProfile.CreateDate != nil && !user.LoginProfile.CreateDate.IsZero() {
 						lastUsedTime = user.LoginProfile.CreateDate.Format(dateFormat)
 					}
 
+					mfa := "n/a"
+					if user.MfaActive != nil {
+						mfa = "no"
+						if *user.MfaActive {
+							mfa = "yes"
+						}
+					}
+
 					row := []string{
 						user.UserName,
 						createDate,
 						lastUsedTime,
+						mfa,
+						policyStatus(user.Policy),
 					}
 
-					data = append(data, row)
+					data = append(data, withAccount(user, row))
 
 				} else {
 					log.Warnf("Unhandled type in value: %T", sublist)
 				}
 			}
+			headers, data = statusColumn(headers, data)
 
 		default:
 			return nil, nil, fmt.Errorf("undefined data type")
@@ -159,11 +375,54 @@ func processTableData(value []iam.UserData) ([]string, [][]string, error) {
 		return nil, nil, fmt.Errorf("value slice is empty")
 	}
 
+	if multiAccount {
+		headers = append([]string{"AccountId", "AccountAlias"}, headers...)
+	}
 	return headers, data, nil
 }
 
-func tableOutput(headers []string, data [][]string, age int) {
-	re := lipgloss.NewRenderer(os.Stdout)
+// resultStatus renders the outcome of a rotation for the Status column.
+func resultStatus(errMessage string) string {
+	if errMessage == "" {
+		return "ok"
+	}
+	return "failed: " + errMessage
+}
+
+// policyStatus renders the Status of a listed user, marking the ones exempt
+// from rotation by their tags.
+func policyStatus(policy iam.UserPolicy) string {
+	if exemption := policy.ExemptionStatus(); exemption != "" {
+		return exemption
+	}
+	return resultStatus("")
+}
+
+// keyStatus is policyStatus for one access key, which may be exempted on its
+// own.
+func keyStatus(policy iam.UserPolicy, key iam.AccessKeyData) string {
+	if exemption := key.ExemptionStatus(); exemption != "" {
+		return exemption
+	}
+	return policyStatus(policy)
+}
+
+// statusColumn keeps the trailing Status column of a listing only when some
+// user could not be read or is exempt, so other listings look as before.
+func statusColumn(headers []string, data [][]string) ([]string, [][]string) {
+	for _, row := range data {
+		if row[len(row)-1] != resultStatus("") {
+			return append(headers, "Status"), data
+		}
+	}
+	for i, row := range data {
+		data[i] = row[:len(row)-1]
+	}
+	return headers, data
+}
+
+func tableOutput(w io.Writer, headers []string, data [][]string, age int) {
+	re := lipgloss.NewRenderer(w)
 	baseStyle := re.NewStyle().Padding(0, 1)
 	headerStyle := baseStyle.Foreground(lipgloss.Color("252")).Bold(true)
 
@@ -173,12 +432,12 @@ func tableOutput(headers []string, data [][]string, age int) {
 		Headers(headers...).
 		Width(130).
 		Rows(data...).
-		StyleFunc(generateTableStyleFunc(data, baseStyle, headerStyle, age))
+		StyleFunc(generateTableStyleFunc(data, slices.Index(headers, "CreateDate"), baseStyle, headerStyle, age))
 
-	fmt.Println(t)
+	fmt.Fprintln(w, t)
 }
 
-func generateTableStyleFunc(data [][]string, baseStyle, headerStyle lipgloss.Style, age int) func(row, col int) lipgloss.Style {
+func generateTableStyleFunc(data [][]string, dateCol int, baseStyle, headerStyle lipgloss.Style, age int) func(row, col int) lipgloss.Style {
 	return func(row, col int) lipgloss.Style {
 		if row == table.HeaderRow {
 			return headerStyle
@@ -186,7 +445,7 @@ func generateTableStyleFunc(data [][]string, baseStyle, headerStyle lipgloss.Sty
 
 		even := row%2 == 0
 		if row < len(data) && col < len(data[row]) { // Ensure bounds: investigate
-			if col == 2 { // CreateDate column
+			if col == dateCol {
 				dateStr := data[row][col]
 				parsedDate, err := time.Parse(dateFormat, dateStr)
 				if err == nil {
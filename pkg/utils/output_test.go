@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	iam "github.com/javiercm1410/gyro/pkg/providers/aws"
+)
+
+func TestRenderData(t *testing.T) {
+	results := []iam.UserData{
+		iam.AccessKeyRotationResult{UserName: "alice", AccessKeyId: "AKIANEW", SecretAccessKey: "secret", DeactivatedKeys: []string{"AKIAOLD", "AKIAOLDER"}},
+		iam.AccessKeyRotationResult{UserName: "bob", Error: "create key: denied, \"quota\""},
+	}
+	orgResults := []iam.UserData{
+		iam.LoginProfileRotationResult{Account: iam.Account{AccountId: "123456789012", AccountAlias: "prod"}, UserName: "carol", Action: iam.ActionResetPassword, Password: "hunter2"},
+	}
+
+	tests := []struct {
+		name   string
+		format string
+		value  []iam.UserData
+		want   string
+	}{
+		{
+			name:   "csv",
+			format: "csv",
+			value:  results,
+			want: "UserName,AccessKeyId,SecretAccessKey,Deactivated,Deleted,Status\n" +
+				"alice,AKIANEW,secret,\"AKIAOLD, AKIAOLDER\",,ok\n" +
+				"bob,,,,,\"failed: create key: denied, \"\"quota\"\"\"\n",
+		},
+		{
+			name:   "csv with accounts",
+			format: "csv",
+			value:  orgResults,
+			want: "AccountId,AccountAlias,UserName,Action,Password,Status\n" +
+				"123456789012,prod,carol," + iam.ActionResetPassword + ",hunter2,ok\n",
+		},
+		{
+			name:   "yaml keeps the JSON field names and order",
+			format: "yaml",
+			value:  results,
+			want: "- UserName: alice\n" +
+				"  AccessKeyId: AKIANEW\n" +
+				"  SecretAccessKey: secret\n" +
+				"  DeactivatedKeys:\n" +
+				"    - AKIAOLD\n" +
+				"    - AKIAOLDER\n" +
+				"  DeletedKeyId: \"\"\n" +
+				"  Error: \"\"\n" +
+				"- UserName: bob\n" +
+				"  AccessKeyId: \"\"\n" +
+				"  SecretAccessKey: \"\"\n" +
+				"  DeactivatedKeys: null\n" +
+				"  DeletedKeyId: \"\"\n" +
+				"  Error: 'create key: denied, \"quota\"'\n",
+		},
+		{
+			name:   "yaml with accounts",
+			format: "yaml",
+			value:  orgResults,
+			want: "- AccountId: \"123456789012\"\n" +
+				"  AccountAlias: prod\n" +
+				"  UserName: carol\n" +
+				"  Action: " + iam.ActionResetPassword + "\n" +
+				"  Password: hunter2\n" +
+				"  Error: \"\"\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := renderData(&buf, test.format, 90, test.value); err != nil {
+				t.Fatalf("renderData: %v", err)
+			}
+			if got := buf.String(); got != test.want {
+				t.Errorf("output =\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRenderDataUnknownFormat(t *testing.T) {
+	var buf bytes.Buffer
+	if err := renderData(&buf, "xml", 90, []iam.UserData{iam.AccessKeyRotationResult{UserName: "alice"}}); err == nil {
+		t.Error("renderData accepted an unknown format")
+	}
+}
+
+func TestWriteOutputFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing os.FileMode
+	}{
+		{name: "new file"},
+		{name: "existing world readable file", existing: 0o644},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "output.json")
+			if test.existing != 0 {
+				if err := os.WriteFile(path, []byte("a previous and longer output"), test.existing); err != nil {
+					t.Fatalf("WriteFile: %v", err)
+				}
+			}
+
+			if err := writeOutputFile(path, []byte("secret")); err != nil {
+				t.Fatalf("writeOutputFile: %v", err)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("Stat: %v", err)
+			}
+			if mode := info.Mode().Perm(); mode != 0o600 {
+				t.Errorf("mode = %o, want 600", mode)
+			}
+			if raw, _ := os.ReadFile(path); string(raw) != "secret" {
+				t.Errorf("content = %q, want %q", raw, "secret")
+			}
+		})
+	}
+}